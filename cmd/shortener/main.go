@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,74 +18,224 @@ import (
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/analytics"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/configs"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/filebase"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/memory"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/postgres"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/grpc_handlers"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/certificate"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/jobs"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/metrics"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/pb"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/policy"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/router"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/server"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
 )
 
 var (
-	httpServer   *server.Server
-	grpcServer   *grpc.Server
-	buildVersion = "N/A"
-	buildDate    = "N/A"
-	buildCommit  = "N/A"
+	httpServer    *server.Server
+	grpcServer    *grpc.Server
+	metricsServer *http.Server
+	buildVersion  = "N/A"
+	buildDate     = "N/A"
+	buildCommit   = "N/A"
 )
 
 func main() {
-	log.Printf("Build version: %v\n", buildVersion)
-	log.Printf("Build date: %v\n", buildDate)
-	log.Printf("Build commit: %v\n", buildCommit)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := configs.New()
+
+	// the settings are validated when the config is loaded
+	l, _ := cfg.Logger(os.Stderr)
+
+	logger.SetDefault(l)
+
+	ctx = logger.NewContext(ctx, l)
+
+	l.Info("build", "version", buildVersion, "date", buildDate, "commit", buildCommit)
+
+	if flag.Arg(0) == "migrate" {
+		err := migrate(ctx, cfg, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
 
 	err := certificate.Generate()
 	if err != nil {
 		log.Fatal("There was a problem when generating the certificate")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
 	defer signal.Stop(interrupt)
 
-	cfg := configs.New()
-
-	var service *services.URLService
+	var repo services.RepositoryInterface
+	var jobStore jobs.Store
 	_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	wp := workers.New(ctx, cfg.Workers, cfg.WorkersBuffer)
+	wp.SetRetryPolicy(cfg.RetryPolicy())
 
 	go func() {
 		wp.Run(ctx)
 	}()
-	defer wp.Stop()
-	if cfg.DatabaseDSN != "" {
+
+	var m *metrics.Metrics
+
+	if cfg.MetricsAddress != "" {
+		m = metrics.New(wp)
+	}
+
+	switch cfg.Storage {
+	case configs.StorageMemory:
+		repo = memory.NewMemoryRepository(cfg.BaseURL)
+	case configs.StoragePostgres:
 		conn, err := postgres.Conn("postgres", cfg.DatabaseDSN)
 		if err != nil {
-			log.Printf("Unable to connect to the database: %s", err.Error())
+			log.Fatalf("Unable to connect to the database: %s", err.Error())
 		}
 
 		err = postgres.SetUpDataBase(ctx, conn)
 
 		if err != nil {
-			log.Printf("Unable to create database struct: %s", err.Error())
+			log.Fatalf("Unable to migrate the database: %s", err.Error())
 		}
 
-		service = services.New(postgres.NewDatabaseRepository(cfg.BaseURL, conn), cfg.BaseURL, wp, subnet)
-	} else {
-		service = services.New(filebase.NewFileRepository(ctx, cfg.FileStoragePath, cfg.BaseURL), cfg.BaseURL, wp, subnet)
+		repo = postgres.NewDatabaseRepository(cfg.BaseURL, conn)
+
+		if cfg.DurableQueue {
+			jobStore = postgres.NewJobStore(conn)
+		}
+	default:
+		repo = filebase.NewFileRepository(ctx, cfg.FileStoragePath, cfg.BaseURL)
+
+		if cfg.DurableQueue {
+			jobStore, err = filebase.NewJobStore(cfg.FileStoragePath)
+			if err != nil {
+				log.Fatalf("Unable to open the jobs journal: %s", err.Error())
+			}
+		}
+	}
+
+	if cfg.DurableQueue && jobStore == nil {
+		l.Warn("the durable queue is not supported by the storage", "storage", cfg.Storage)
+	}
+
+	if m != nil {
+		repo = m.Repository(repo, cfg.Storage)
+	}
+
+	// the sequence continues after the highest stored identifier, the rest of collisions are resolved by retries
+	var sequenceStart uint64
+
+	if cfg.ShortIDStrategy == shortener.StrategySequence {
+		shortURLs, err := repo.GetShortURLs(ctx)
+		if err != nil {
+			log.Fatalf("Unable to read the stored urls: %s", err.Error())
+		}
+
+		sequenceStart = shortener.SequenceStart(shortURLs, cfg.ShortIDAlphabet)
+	}
+
+	generator, err := shortener.New(cfg.ShortIDStrategy, cfg.ShortIDLength, cfg.ShortIDAlphabet, sequenceStart)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	validator := services.NewURLValidator(cfg.AllowedSchemes, cfg.MaxURLLength, cfg.StripPort, cfg.StripFragment)
+
+	destinationPolicy, err := policy.New(cfg.PolicyFile, cfg.PolicyResolveHosts)
+	if err != nil {
+		log.Fatalf("Unable to load the policy: %s", err.Error())
+	}
+
+	collector := analytics.New(repo, wp, cfg.ClickBatchSize, time.Duration(cfg.ClickFlushInterval)*time.Millisecond, cfg.ClickBuffer)
+
+	collectorCtx, stopCollector := context.WithCancel(ctx)
+	collectorDone := make(chan struct{})
+
+	go func() {
+		collector.Run(collectorCtx)
+		close(collectorDone)
+	}()
+
+	var clicks services.ClickRecorder = collector
+
+	if m != nil {
+		clicks = m.Redirects(collector)
+	}
+
+	var queue *jobs.Queue
+	var jobQueue services.JobQueue
+
+	if jobStore != nil {
+		queue = jobs.New(jobStore, wp)
+		jobQueue = queue
+	}
+
+	service := services.New(repo, cfg.BaseURL, wp, subnet, generator, validator, destinationPolicy, clicks, jobQueue)
+
+	if queue != nil {
+		queue.Handle(services.JobDeleteURLs, service.HandleDeleteJob)
+		queue.HandleFailure(services.JobDeleteURLs, service.HandleDeleteJobFailure)
+
+		resumed, err := queue.Resume(ctx)
+		if err != nil {
+			log.Fatalf("Unable to resume the jobs: %s", err.Error())
+		}
+
+		l.Info("jobs resumed", "jobs", resumed)
+	}
+
+	var enforcePolicy func()
+
+	if cfg.PolicyRetroactive {
+		enforcePolicy = func() {
+			wp.Push(ctx, func(ctx context.Context) error {
+				blocked, err := service.EnforcePolicy(ctx)
+				if err != nil {
+					return err
+				}
+
+				logger.FromContext(ctx).Info("policy enforced", "changed", blocked)
+
+				return nil
+			})
+		}
+
+		enforcePolicy()
+	}
+
+	go destinationPolicy.Watch(ctx, time.Duration(cfg.PolicyReloadInterval)*time.Second, enforcePolicy)
+
+	if cfg.SweepInterval > 0 {
+		go wp.Schedule(ctx, time.Duration(cfg.SweepInterval)*time.Second, func(ctx context.Context) error {
+			swept, err := service.SweepExpired(ctx, cfg.SweepArchive)
+			if err != nil {
+				return err
+			}
+
+			if swept > 0 {
+				logger.FromContext(ctx).Info("expired links swept", "swept", swept)
+			}
+
+			return nil
+		})
 	}
 
 	g, ctx := errgroup.WithContext(ctx)
@@ -91,10 +243,31 @@ func main() {
 	h := handlers.New(service, cfg.BaseURL, wp)
 	grpcHandler := grpchandlers.NewGRPCHandler(service)
 
-	mux := router.New(h, cfg)
+	mux := router.New(h, cfg, m, l)
+
+	keyring, err := cfg.CookieKeyring()
+	if err != nil {
+		log.Fatalf("Unable to load the cookie keys: %s", err.Error())
+	}
+
+	cookies, err := cfg.CookieSettings()
+	if err != nil {
+		log.Fatalf("Invalid cookie settings: %s", err.Error())
+	}
+
+	verifier, err := cfg.TokenVerifier()
+	if err != nil {
+		log.Fatalf("Unable to load the token keys: %s", err.Error())
+	}
+
+	if !cfg.HasCookieKeys() {
+		l.Warn("no cookie keys configured, the users lose their links on restart")
+	}
 
 	g.Go(func() error {
-		httpServer = server.New(cfg.ServerAddress, cfg.Key, mux)
+		httpServer = server.New(cfg.ServerAddress, keyring, cookies, verifier, mux)
+
+		l.Info("http server listening", "address", cfg.ServerAddress, "https", cfg.EnableHttps)
 
 		var err error
 
@@ -108,32 +281,58 @@ func main() {
 			return err
 		}
 
-		log.Printf("httpServer starting at: %v", cfg.ServerAddress)
-
 		return nil
 	})
 
 	g.Go(func() error {
 		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
 		if err != nil {
-			log.Printf("gRPC server failed to listen: %v", err.Error())
+			l.Error("grpc server failed to listen", "error", err)
 			return err
 		}
-		grpcServer = grpc.NewServer()
+
+		interceptors := []grpc.UnaryServerInterceptor{grpchandlers.LoggingInterceptor(l)}
+
+		if m != nil {
+			interceptors = append(interceptors, m.UnaryServerInterceptor())
+		}
+
+		interceptors = append(interceptors, grpchandlers.AuthInterceptor(verifier), grpchandlers.APIKeyInterceptor(service),
+			grpchandlers.UserInterceptor(keyring, cookies.TTL))
+
+		grpcServer = grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 		pb.RegisterURLServer(grpcServer, grpcHandler)
-		log.Printf("server listening at %v", lis.Addr())
+		l.Info("grpc server listening", "address", lis.Addr())
 		return grpcServer.Serve(lis)
 	})
 
+	if m != nil {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", m.Handler())
+
+		metricsServer = &http.Server{Addr: cfg.MetricsAddress, Handler: metricsMux}
+
+		g.Go(func() error {
+			l.Info("metrics listening", "address", cfg.MetricsAddress)
+
+			err := metricsServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				return err
+			}
+
+			return nil
+		})
+	}
+
 	select {
 	case <-interrupt:
-		log.Println("Stop server")
+		l.Info("stop server")
 		break
 	case <-ctx.Done():
 		break
 	}
 
-	log.Println("Receive shutdown signal")
+	l.Info("receive shutdown signal")
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 
@@ -147,10 +346,34 @@ func main() {
 		grpcServer.GracefulStop()
 	}
 
+	// the servers accept no more work, the collector passes the last clicks before the pool drains
+	stopCollector()
+	<-collectorDone
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Duration(cfg.DrainTimeout)*time.Second)
+
+	defer drainCancel()
+
+	abandoned := wp.Drain(drainCtx)
+	if abandoned > 0 {
+		l.Warn("worker pool drained with abandoned tasks", "abandoned", abandoned)
+	} else {
+		l.Info("worker pool drained")
+	}
+
+	// the metrics stay available during the drain, the shutdown context of the servers may be expired by now
+	if metricsServer != nil {
+		metricsCtx, metricsCancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		_ = metricsServer.Shutdown(metricsCtx)
+
+		metricsCancel()
+	}
+
 	err = g.Wait()
 	if err != nil {
-		log.Printf("server returning an error: %v", err)
+		l.Error("server returning an error", "error", err)
 	}
 
-	log.Println("Server Shutdown gracefully")
+	l.Info("server shutdown gracefully")
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/configs"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/postgres"
+)
+
+const migrateUsage = "usage: shortener [flags] migrate up | down [steps] | status"
+
+// migrate runs the "migrate" subcommand, args are the arguments after the subcommand name
+func migrate(ctx context.Context, cfg *configs.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := postgres.Conn("postgres", cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	migrator, err := postgres.NewMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	var version int
+
+	switch args[0] {
+	case "up":
+		version, err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive number: %s", args[1])
+			}
+		}
+
+		version, err = migrator.Down(ctx, steps)
+	case "status":
+		version, err = migrator.Version(ctx)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Database schema version %d, latest %d", version, migrator.Latest())
+
+	return nil
+}
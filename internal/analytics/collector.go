@@ -0,0 +1,114 @@
+// Package analytics collects the redirects and saves them in batches
+package analytics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+// flushTimeout - time given to save the last batch on shutdown
+const flushTimeout = 5 * time.Second
+
+// Store saves the clicks
+type Store interface {
+	AddClicks(ctx context.Context, clicks ...models.Click) error
+}
+
+// Collector buffers the clicks and passes them to the worker pool in batches
+type Collector struct {
+	store         Store
+	wp            *workers.WorkerPool
+	clicks        chan models.Click
+	batchSize     int
+	flushInterval time.Duration
+	// dropped - number of clicks lost because the buffer was full
+	dropped int64
+}
+
+// New is the collector constructor, buffer is the number of clicks waiting for a batch
+func New(store Store, wp *workers.WorkerPool, batchSize int, flushInterval time.Duration, buffer int) *Collector {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &Collector{
+		store:         store,
+		wp:            wp,
+		clicks:        make(chan models.Click, buffer),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+// Record queues the click without blocking, the click is dropped if the buffer is full
+func (c *Collector) Record(click models.Click) {
+	select {
+	case c.clicks <- click:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// Dropped returns the number of clicks lost because the buffer was full
+func (c *Collector) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Run groups the clicks until ctx is done, a batch is sent when it is full or flushInterval passes
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, c.batchSize)
+
+	for {
+		select {
+		case click := <-c.clicks:
+			batch = append(batch, click)
+
+			if len(batch) >= c.batchSize {
+				c.flush(ctx, batch)
+				batch = make([]models.Click, 0, c.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.flush(ctx, batch)
+				batch = make([]models.Click, 0, c.batchSize)
+			}
+		case <-ctx.Done():
+			c.drain(ctx, batch)
+			return
+		}
+	}
+}
+
+// flush passes the batch to the worker pool
+func (c *Collector) flush(ctx context.Context, batch []models.Click) {
+	c.wp.Push(ctx, func(ctx context.Context) error {
+		return c.store.AddClicks(ctx, batch...)
+	})
+}
+
+// drain saves the buffered clicks directly, the worker pool may be already stopped, ctx is used only for logging
+func (c *Collector) drain(ctx context.Context, batch []models.Click) {
+	for len(c.clicks) > 0 {
+		batch = append(batch, <-c.clicks)
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+
+	saveCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	err := c.store.AddClicks(saveCtx, batch...)
+	if err != nil {
+		logger.FromContext(ctx).Error("unable to save the clicks", "clicks", len(batch), "error", err)
+	}
+}
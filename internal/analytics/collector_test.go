@@ -0,0 +1,101 @@
+package analytics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+// stubStore remembers the saved batches
+type stubStore struct {
+	batches [][]models.Click
+	mtx     sync.Mutex
+}
+
+func (s *stubStore) AddClicks(ctx context.Context, clicks ...models.Click) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.batches = append(s.batches, clicks)
+
+	return nil
+}
+
+func (s *stubStore) sizes() []int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var result []int
+	for _, batch := range s.batches {
+		result = append(result, len(batch))
+	}
+
+	return result
+}
+
+func TestCollector_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := workers.New(ctx, 1, 10)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	store := &stubStore{}
+	collector := New(store, wp, 2, time.Hour, 10)
+
+	done := make(chan struct{})
+	go func() {
+		collector.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		collector.Record(models.Click{ShortURL: "go", Time: time.Now()})
+	}
+
+	require.Eventually(t, func() bool {
+		return len(store.sizes()) == 1
+	}, time.Second, 5*time.Millisecond, "a full batch is saved without waiting for the interval")
+
+	cancel()
+	<-done
+
+	assert.Equal(t, []int{2, 1}, store.sizes(), "the rest is saved on shutdown")
+}
+
+func TestCollector_FlushInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := workers.New(ctx, 1, 10)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	store := &stubStore{}
+	collector := New(store, wp, 100, 10*time.Millisecond, 10)
+
+	go collector.Run(ctx)
+
+	collector.Record(models.Click{ShortURL: "go", Time: time.Now()})
+
+	assert.Eventually(t, func() bool {
+		return len(store.sizes()) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCollector_Record(t *testing.T) {
+	collector := New(&stubStore{}, nil, 10, time.Hour, 1)
+
+	collector.Record(models.Click{ShortURL: "go"})
+	collector.Record(models.Click{ShortURL: "go"})
+
+	assert.Equal(t, int64(1), collector.Dropped(), "the click is dropped instead of blocking")
+}
@@ -0,0 +1,309 @@
+// Package auth authenticates the API clients by the bearer tokens
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// Signing algorithms of the accepted tokens.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// MinSecretLength - HS256 secrets shorter than the hash are refused
+const MinSecretLength = 32
+
+// leeway - allowed clock skew between the token issuer and the server
+const leeway = 30 * time.Second
+
+// maxSubjectLength - limit of the subject used as the user id
+const maxSubjectLength = 255
+
+// ErrInvalidToken - the token is malformed, not signed with a known key, expired or issued for another service
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// Claims are the registered claims of the accepted tokens, the times are Unix seconds
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// audience is the aud claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+
+	*a = many
+
+	return nil
+}
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Verifier validates the JWT signed with HS256 secrets or Ed25519 keys and maps the subject to the user id
+type Verifier struct {
+	secrets    [][]byte
+	publicKeys []ed25519.PublicKey
+	// issuer and audience - checked if set
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewVerifier is the verifier constructor, at least one secret or public key is required
+func NewVerifier(secrets [][]byte, publicKeys []ed25519.PublicKey, issuer, audience string) (*Verifier, error) {
+	if len(secrets) == 0 && len(publicKeys) == 0 {
+		return nil, errors.New("no token keys")
+	}
+
+	for _, secret := range secrets {
+		if len(secret) < MinSecretLength {
+			return nil, fmt.Errorf("the token secret must be at least %d bytes", MinSecretLength)
+		}
+	}
+
+	return &Verifier{
+		secrets:    secrets,
+		publicKeys: publicKeys,
+		issuer:     issuer,
+		audience:   audience,
+		now:        time.Now,
+	}, nil
+}
+
+// Verify checks the signature and the claims of the token and returns its subject
+func (v *Verifier) Verify(token string) (models.UserID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !v.verifySignature(h.Alg, []byte(parts[0]+"."+parts[1]), signature) {
+		return "", ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if err := v.validate(claims); err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// verifySignature checks the signature with every key of the algorithm, other algorithms including none are refused
+func (v *Verifier) verifySignature(alg string, signed, signature []byte) bool {
+	switch alg {
+	case AlgHS256:
+		for _, secret := range v.secrets {
+			if hmac.Equal(signHS256(secret, signed), signature) {
+				return true
+			}
+		}
+	case AlgEdDSA:
+		for _, key := range v.publicKeys {
+			if ed25519.Verify(key, signed, signature) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// validate checks the time, issuer and audience claims and the subject
+func (v *Verifier) validate(claims Claims) error {
+	now := v.now()
+
+	switch {
+	case claims.ExpiresAt == 0:
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)):
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)):
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case claims.Subject == "" || len(claims.Subject) > maxSubjectLength:
+		return fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	case v.issuer != "" && claims.Issuer != v.issuer:
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	case v.audience != "" && !claims.Audience.contains(v.audience):
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, item := range a {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SignHS256 issues a token signed with the secret, for the clients and the tests
+func SignHS256(secret []byte, claims Claims) (string, error) {
+	signed, err := signingInput(AlgHS256, claims)
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signHS256(secret, []byte(signed))), nil
+}
+
+// SignEdDSA issues a token signed with the Ed25519 private key, for the clients and the tests
+func SignEdDSA(key ed25519.PrivateKey, claims Claims) (string, error) {
+	signed, err := signingInput(AlgEdDSA, claims)
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signed))), nil
+}
+
+func signingInput(alg string, claims Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: alg, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c), nil
+}
+
+func signHS256(secret, signed []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signed)
+
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// ParsePublicKeys reads the Ed25519 public keys from the PEM "PUBLIC KEY" blocks
+func ParsePublicKeys(data []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token public key: %w", err)
+		}
+
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("invalid token public key: not an Ed25519 key")
+		}
+
+		keys = append(keys, edKey)
+	}
+
+	if len(keys) == 0 && len(bytes.TrimSpace(data)) > 0 {
+		return nil, errors.New("invalid token public keys: no PEM blocks")
+	}
+
+	return keys, nil
+}
+
+// BearerToken returns the token of the Authorization header value
+func BearerToken(value string) (string, bool) {
+	const prefix = "bearer "
+
+	if len(value) <= len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(value[len(prefix):]), true
+}
+
+type contextKey struct{}
+
+// NewContext returns the context carrying the authenticated user
+func NewContext(ctx context.Context, userID models.UserID) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// FromContext returns the authenticated user of the context
+func FromContext(ctx context.Context) (models.UserID, bool) {
+	userID, ok := ctx.Value(contextKey{}).(models.UserID)
+
+	return userID, ok
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	secret := []byte(strings.Repeat("s", MinSecretLength))
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	verifier, err := NewVerifier([][]byte{secret}, []ed25519.PublicKey{publicKey}, "ci", "shortener")
+	require.NoError(t, err)
+
+	now := time.Now()
+	claims := Claims{Subject: "user", Issuer: "ci", Audience: audience{"shortener"}, ExpiresAt: now.Add(time.Hour).Unix()}
+
+	sign := func(t *testing.T, claims Claims) string {
+		token, err := SignHS256(secret, claims)
+		require.NoError(t, err)
+
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr bool
+	}{
+		{
+			name:  "hs256",
+			token: func(t *testing.T) string { return sign(t, claims) },
+		},
+		{
+			name: "eddsa",
+			token: func(t *testing.T) string {
+				token, err := SignEdDSA(privateKey, claims)
+				require.NoError(t, err)
+				return token
+			},
+		},
+		{
+			name: "unknown secret",
+			token: func(t *testing.T) string {
+				token, err := SignHS256([]byte(strings.Repeat("x", MinSecretLength)), claims)
+				require.NoError(t, err)
+				return token
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			token: func(t *testing.T) string {
+				expired := claims
+				expired.ExpiresAt = now.Add(-time.Hour).Unix()
+				return sign(t, expired)
+			},
+			wantErr: true,
+		},
+		{
+			name: "no expiry",
+			token: func(t *testing.T) string {
+				unlimited := claims
+				unlimited.ExpiresAt = 0
+				return sign(t, unlimited)
+			},
+			wantErr: true,
+		},
+		{
+			name: "other audience",
+			token: func(t *testing.T) string {
+				other := claims
+				other.Audience = audience{"billing"}
+				return sign(t, other)
+			},
+			wantErr: true,
+		},
+		{
+			name: "other issuer",
+			token: func(t *testing.T) string {
+				other := claims
+				other.Issuer = "someone"
+				return sign(t, other)
+			},
+			wantErr: true,
+		},
+		{
+			name: "alg none",
+			token: func(t *testing.T) string {
+				parts := strings.Split(sign(t, claims), ".")
+				return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+			},
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   func(t *testing.T) string { return "abc" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := verifier.Verify(tt.token(t))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "user", userID)
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	_, err := NewVerifier(nil, nil, "", "")
+	assert.Error(t, err)
+
+	_, err = NewVerifier([][]byte{[]byte("short")}, nil, "", "")
+	assert.Error(t, err, "a short secret must be refused")
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	keys, err := ParsePublicKeys(append(data, data...))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, publicKey, keys[0])
+
+	_, err = ParsePublicKeys([]byte("not pem"))
+	assert.Error(t, err)
+}
+
+func TestBearerToken(t *testing.T) {
+	token, ok := BearerToken("bearer abc")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", token)
+
+	_, ok = BearerToken("Basic abc")
+	assert.False(t, ok)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// Scopes of the API keys.
+const (
+	ScopeLinksRead   = "links:read"
+	ScopeLinksWrite  = "links:write"
+	ScopeLinksDelete = "links:delete"
+	ScopeStatsRead   = "stats:read"
+)
+
+// Scopes - all the scopes an API key can carry
+var Scopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeLinksDelete, ScopeStatsRead}
+
+// APIKeyHeader - the header and, in lower case, the gRPC metadata key carrying the API key
+const APIKeyHeader = "X-API-Key"
+
+// ErrInvalidAPIKey - the API key is unknown or revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyAuthenticator looks up the API keys and records their use
+type APIKeyAuthenticator interface {
+	// AuthenticateAPIKey returns the key or ErrInvalidAPIKey
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
+}
+
+// ValidScope reports whether the scope can be granted to an API key
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type scopesKey struct{}
+
+// WithScopes returns the context of a caller limited to the scopes of its API key
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// IsAPIKey reports whether the caller is authenticated by an API key
+func IsAPIKey(ctx context.Context) bool {
+	_, ok := ctx.Value(scopesKey{}).([]string)
+
+	return ok
+}
+
+// HasScope reports whether the caller is allowed the scope, the callers without an API key have all the scopes
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ctx.Value(scopesKey{}).([]string)
+	if !ok {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,15 +2,28 @@
 package configs
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
 )
 
 const (
@@ -22,6 +35,52 @@ const (
 	DefaultEnableHttps     = false
 	DefaultTrustedSubnet   = "127.0.0.1/24"
 	DefaultGRPCPort        = 5000
+	DefaultShortIDStrategy = shortener.StrategyHash
+	DefaultShortIDLength   = shortener.DefaultLength
+	DefaultShortIDAlphabet = shortener.DefaultAlphabet
+	DefaultMaxURLLength    = 2048
+	DefaultStripPort       = true
+	DefaultStripFragment   = false
+	// DefaultPolicyReloadInterval - seconds between the policy file checks
+	DefaultPolicyReloadInterval = 30
+	// DefaultSweepInterval - seconds between the expired links sweeps
+	DefaultSweepInterval = 60
+	// DefaultClickBatchSize - maximum number of clicks saved at once
+	DefaultClickBatchSize = 100
+	// DefaultClickFlushInterval - milliseconds before an incomplete batch of clicks is saved
+	DefaultClickFlushInterval = 1000
+	// DefaultClickBuffer - number of clicks waiting for a batch, the rest are dropped
+	DefaultClickBuffer = 10000
+	// DefaultMetricsAddress - listener of the Prometheus metrics
+	DefaultMetricsAddress = ":9090"
+	// DefaultLogLevel - minimal level of the written log records
+	DefaultLogLevel = "info"
+	// DefaultLogFormat - json or logfmt
+	DefaultLogFormat = "json"
+	// DefaultDrainTimeout - seconds given to the queued tasks on shutdown
+	DefaultDrainTimeout = 30
+	// DefaultTaskMaxAttempts - runs of a failed background task including the first one
+	DefaultTaskMaxAttempts = 3
+	// DefaultTaskRetryDelay - milliseconds before the first retry of a background task
+	DefaultTaskRetryDelay = 200
+	// DefaultTaskRetryMaxDelay - limit of the retry delay in milliseconds
+	DefaultTaskRetryMaxDelay = 10000
+	// DefaultCookieTTL - seconds the user cookie is valid for, 30 days
+	DefaultCookieTTL = 30 * 24 * 60 * 60
+	// DefaultCookieSameSite - lax, strict or none
+	DefaultCookieSameSite = "lax"
+	DefaultCookieHTTPOnly = true
+	DefaultCookiePath     = "/"
+)
+
+// DefaultAllowedSchemes - schemes accepted for shortening
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// Storage backends available for the Storage setting.
+const (
+	StorageMemory   = "memory"
+	StorageFile     = "file"
+	StoragePostgres = "postgres"
 )
 
 // Config contains app configuration.
@@ -34,8 +93,30 @@ type Config struct {
 	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"FILE_STORAGE_PATH"`
 	// DatabaseDSN - path to the database
 	DatabaseDSN string `env:"DATABASE_DSN" json:"DATABASE_DSN"`
-	// Key - encryption key
-	Key []byte
+	// CookieKeys - hex encoded AES keys of the user cookies, the first one encrypts, the rest only decrypt the cookies issued before a rotation
+	CookieKeys []string `env:"COOKIE_KEYS" envSeparator:"," json:"COOKIE_KEYS"`
+	// CookieKeysFile - file with a hex encoded key per line in the order of CookieKeys, used if CookieKeys is empty
+	CookieKeysFile string `env:"COOKIE_KEYS_FILE" json:"COOKIE_KEYS_FILE"`
+	// CookieTTL - seconds the user cookie is valid for, it is renewed once less than half is left
+	CookieTTL int `env:"COOKIE_TTL" json:"COOKIE_TTL"`
+	// CookieSecure - send the cookie over HTTPS only, always set with EnableHttps
+	CookieSecure bool `env:"COOKIE_SECURE" json:"COOKIE_SECURE"`
+	// CookieHTTPOnly - hide the cookie from the scripts
+	CookieHTTPOnly bool `env:"COOKIE_HTTP_ONLY" json:"COOKIE_HTTP_ONLY"`
+	// CookieSameSite - lax, strict or none
+	CookieSameSite string `env:"COOKIE_SAME_SITE" json:"COOKIE_SAME_SITE"`
+	// CookieDomain - domain of the cookie, the host of the request if empty
+	CookieDomain string `env:"COOKIE_DOMAIN" json:"COOKIE_DOMAIN"`
+	// CookiePath - path of the cookie
+	CookiePath string `env:"COOKIE_PATH" json:"COOKIE_PATH"`
+	// JWTSecrets - HS256 secrets of the bearer tokens, at least 32 bytes each
+	JWTSecrets []string `env:"JWT_SECRETS" envSeparator:"," json:"JWT_SECRETS"`
+	// JWTPublicKeysFile - PEM file with the Ed25519 public keys of the EdDSA bearer tokens
+	JWTPublicKeysFile string `env:"JWT_PUBLIC_KEYS_FILE" json:"JWT_PUBLIC_KEYS_FILE"`
+	// JWTIssuer - required iss claim, not checked if empty
+	JWTIssuer string `env:"JWT_ISSUER" json:"JWT_ISSUER"`
+	// JWTAudience - required aud claim, not checked if empty
+	JWTAudience string `env:"JWT_AUDIENCE" json:"JWT_AUDIENCE"`
 	// Workers - number of workers
 	Workers int `env:"WORKERS"`
 	// WorkersBuffer - buffer size value
@@ -46,6 +127,199 @@ type Config struct {
 	// TrustedSubnet - available url for internal requests
 	TrustedSubnet string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
 	GRPCPort      int    `env:"GRPC_PORT" json:"grpc_port"`
+	// Storage - storage backend: memory, file or postgres, if empty it is chosen by DatabaseDSN
+	Storage string `env:"STORAGE" json:"STORAGE"`
+	// ShortIDStrategy - short url identifier generation: hash, sequence or random
+	ShortIDStrategy string `env:"SHORT_ID_STRATEGY" json:"SHORT_ID_STRATEGY"`
+	// ShortIDLength - length of the hash and random identifiers
+	ShortIDLength int `env:"SHORT_ID_LENGTH" json:"SHORT_ID_LENGTH"`
+	// ShortIDAlphabet - characters of the identifiers
+	ShortIDAlphabet string `env:"SHORT_ID_ALPHABET" json:"SHORT_ID_ALPHABET"`
+	// AllowedSchemes - url schemes accepted for shortening
+	AllowedSchemes []string `env:"ALLOWED_SCHEMES" envSeparator:"," json:"ALLOWED_SCHEMES"`
+	// MaxURLLength - maximum length of a url, 0 disables the check
+	MaxURLLength int `env:"MAX_URL_LENGTH" json:"MAX_URL_LENGTH"`
+	// StripPort - remove the default port of the scheme from urls
+	StripPort bool `env:"STRIP_PORT" json:"STRIP_PORT"`
+	// StripFragment - remove the fragment from urls
+	StripFragment bool `env:"STRIP_FRAGMENT" json:"STRIP_FRAGMENT"`
+	// PolicyFile - JSON file with the allow and deny rules for destination hosts, empty disables the rules
+	PolicyFile string `env:"POLICY_FILE" json:"POLICY_FILE"`
+	// PolicyReloadInterval - seconds between the policy file checks, 0 disables the reloading
+	PolicyReloadInterval int `env:"POLICY_RELOAD_INTERVAL" json:"POLICY_RELOAD_INTERVAL"`
+	// PolicyResolveHosts - refuse host names resolving to private addresses
+	PolicyResolveHosts bool `env:"POLICY_RESOLVE_HOSTS" json:"POLICY_RESOLVE_HOSTS"`
+	// PolicyRetroactive - block the stored links denied by the policy on start and on every reload
+	PolicyRetroactive bool `env:"POLICY_RETROACTIVE" json:"POLICY_RETROACTIVE"`
+	// SweepInterval - seconds between the expired links sweeps, 0 disables the sweeper
+	SweepInterval int `env:"SWEEP_INTERVAL" json:"SWEEP_INTERVAL"`
+	// SweepArchive - mark the expired links deleted instead of removing them
+	SweepArchive bool `env:"SWEEP_ARCHIVE" json:"SWEEP_ARCHIVE"`
+	// TrustedProxies - addresses or subnets of the proxies allowed to set X-Forwarded-For and X-Real-IP
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:"," json:"TRUSTED_PROXIES"`
+	// ClickBatchSize - maximum number of clicks saved at once
+	ClickBatchSize int `env:"CLICK_BATCH_SIZE" json:"CLICK_BATCH_SIZE"`
+	// ClickFlushInterval - milliseconds before an incomplete batch of clicks is saved
+	ClickFlushInterval int `env:"CLICK_FLUSH_INTERVAL" json:"CLICK_FLUSH_INTERVAL"`
+	// ClickBuffer - number of clicks waiting for a batch, the rest are dropped
+	ClickBuffer int `env:"CLICK_BUFFER" json:"CLICK_BUFFER"`
+	// MetricsAddress - listener of the Prometheus metrics separate from the API, empty disables the metrics
+	MetricsAddress string `env:"METRICS_ADDRESS" json:"METRICS_ADDRESS"`
+	// LogLevel - minimal level of the written log records: debug, info, warn or error
+	LogLevel string `env:"LOG_LEVEL" json:"LOG_LEVEL"`
+	// LogFormat - format of the log lines: json or logfmt
+	LogFormat string `env:"LOG_FORMAT" json:"LOG_FORMAT"`
+	// DrainTimeout - seconds given to the queued tasks on shutdown, the rest are abandoned
+	DrainTimeout int `env:"DRAIN_TIMEOUT" json:"DRAIN_TIMEOUT"`
+	// DurableQueue - save the batch deletes before they are run and resume them on start, not supported by the memory storage
+	DurableQueue bool `env:"DURABLE_QUEUE" json:"DURABLE_QUEUE"`
+	// TaskMaxAttempts - runs of a failed background task including the first one, then it goes to the dead letters
+	TaskMaxAttempts int `env:"TASK_MAX_ATTEMPTS" json:"TASK_MAX_ATTEMPTS"`
+	// TaskRetryDelay - milliseconds before the first retry, doubled for every next one
+	TaskRetryDelay int `env:"TASK_RETRY_DELAY" json:"TASK_RETRY_DELAY"`
+	// TaskRetryMaxDelay - limit of the retry delay in milliseconds
+	TaskRetryMaxDelay int `env:"TASK_RETRY_MAX_DELAY" json:"TASK_RETRY_MAX_DELAY"`
+}
+
+// RetryPolicy returns the policy of the background tasks
+func (c *Config) RetryPolicy() workers.RetryPolicy {
+	return workers.RetryPolicy{
+		MaxAttempts: c.TaskMaxAttempts,
+		BaseDelay:   time.Duration(c.TaskRetryDelay) * time.Millisecond,
+		MaxDelay:    time.Duration(c.TaskRetryMaxDelay) * time.Millisecond,
+	}
+}
+
+// TrustedProxyNetworks parses TrustedProxies, a single address is treated as a subnet of one host
+func (c *Config) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, proxy := range c.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// HasCookieKeys reports whether the cookie keys are configured
+func (c *Config) HasCookieKeys() bool {
+	return len(c.CookieKeys) > 0 || c.CookieKeysFile != ""
+}
+
+// CookieKeyring returns the keyring of CookieKeys or CookieKeysFile, a random one if no keys are configured
+func (c *Config) CookieKeyring() (*encryptor.Keyring, error) {
+	if !c.HasCookieKeys() {
+		return encryptor.NewRandomKeyring()
+	}
+
+	keys, err := encryptor.ParseKeys(c.CookieKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.CookieKeys) == 0 {
+		keys, err = encryptor.ReadKeys(c.CookieKeysFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encryptor.NewKeyring(keys...)
+}
+
+// CookieSettings returns the attributes of the user cookies
+func (c *Config) CookieSettings() (helpers.CookieSettings, error) {
+	if c.CookieTTL <= 0 {
+		return helpers.CookieSettings{}, fmt.Errorf("the cookie ttl must be positive, got %d", c.CookieTTL)
+	}
+
+	settings := helpers.CookieSettings{
+		Path:     c.CookiePath,
+		Domain:   c.CookieDomain,
+		TTL:      time.Duration(c.CookieTTL) * time.Second,
+		Secure:   c.CookieSecure || c.EnableHttps,
+		HTTPOnly: c.CookieHTTPOnly,
+	}
+
+	switch strings.ToLower(c.CookieSameSite) {
+	case "", "lax":
+		settings.SameSite = http.SameSiteLaxMode
+	case "strict":
+		settings.SameSite = http.SameSiteStrictMode
+	case "none":
+		// the browsers accept SameSite=None only with Secure
+		if !settings.Secure {
+			return helpers.CookieSettings{}, errors.New("the cookie same site none requires a secure cookie")
+		}
+
+		settings.SameSite = http.SameSiteNoneMode
+	default:
+		return helpers.CookieSettings{}, fmt.Errorf("unknown cookie same site %q", c.CookieSameSite)
+	}
+
+	return settings, nil
+}
+
+// TokenVerifier returns the verifier of the bearer tokens, nil if no JWTSecrets or JWTPublicKeysFile are configured
+func (c *Config) TokenVerifier() (*auth.Verifier, error) {
+	var secrets [][]byte
+
+	for _, secret := range c.JWTSecrets {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+
+	var publicKeys []ed25519.PublicKey
+
+	if c.JWTPublicKeysFile != "" {
+		data, err := ioutil.ReadFile(c.JWTPublicKeysFile)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKeys, err = auth.ParsePublicKeys(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(secrets) == 0 && len(publicKeys) == 0 {
+		return nil, nil
+	}
+
+	return auth.NewVerifier(secrets, publicKeys, c.JWTIssuer, c.JWTAudience)
+}
+
+// Logger returns the logger writing to w with LogLevel and LogFormat
+func (c *Config) Logger(w io.Writer) (*logger.Logger, error) {
+	level, err := logger.ParseLevel(c.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	return logger.New(w, c.LogFormat, level)
 }
 
 // The function checks for the presence of a flag. f - flag values
@@ -55,14 +329,37 @@ func checkExists(f string) bool {
 
 func defaultConfig() Config {
 	return Config{
-		BaseURL:         DefaultBaseURL,
-		ServerAddress:   DefaultServerAddress,
-		FileStoragePath: DefaultFileStoragePath,
-		Workers:         DefaultWorkers,
-		WorkersBuffer:   DefaultWorkersBuffer,
-		EnableHttps:     DefaultEnableHttps,
-		TrustedSubnet:   DefaultTrustedSubnet,
-		GRPCPort:        DefaultGRPCPort,
+		BaseURL:              DefaultBaseURL,
+		ServerAddress:        DefaultServerAddress,
+		FileStoragePath:      DefaultFileStoragePath,
+		Workers:              DefaultWorkers,
+		WorkersBuffer:        DefaultWorkersBuffer,
+		EnableHttps:          DefaultEnableHttps,
+		TrustedSubnet:        DefaultTrustedSubnet,
+		GRPCPort:             DefaultGRPCPort,
+		ShortIDStrategy:      DefaultShortIDStrategy,
+		ShortIDLength:        DefaultShortIDLength,
+		ShortIDAlphabet:      DefaultShortIDAlphabet,
+		AllowedSchemes:       DefaultAllowedSchemes,
+		MaxURLLength:         DefaultMaxURLLength,
+		StripPort:            DefaultStripPort,
+		StripFragment:        DefaultStripFragment,
+		PolicyReloadInterval: DefaultPolicyReloadInterval,
+		SweepInterval:        DefaultSweepInterval,
+		ClickBatchSize:       DefaultClickBatchSize,
+		ClickFlushInterval:   DefaultClickFlushInterval,
+		ClickBuffer:          DefaultClickBuffer,
+		MetricsAddress:       DefaultMetricsAddress,
+		LogLevel:             DefaultLogLevel,
+		LogFormat:            DefaultLogFormat,
+		DrainTimeout:         DefaultDrainTimeout,
+		TaskMaxAttempts:      DefaultTaskMaxAttempts,
+		TaskRetryDelay:       DefaultTaskRetryDelay,
+		TaskRetryMaxDelay:    DefaultTaskRetryMaxDelay,
+		CookieTTL:            DefaultCookieTTL,
+		CookieSameSite:       DefaultCookieSameSite,
+		CookieHTTPOnly:       DefaultCookieHTTPOnly,
+		CookiePath:           DefaultCookiePath,
 	}
 }
 
@@ -90,13 +387,6 @@ func readCfgFile(name string, cfg *Config) error {
 func New() *Config {
 	c := defaultConfig()
 
-	random, err := helpers.GenerateRandom(16)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	c.Key = random
-
 	if checkExists("s") {
 		flag.StringVar(&c.Config, "s", c.Config, "Config")
 	}
@@ -114,7 +404,7 @@ func New() *Config {
 		}
 	}
 
-	err = env.Parse(&c)
+	err := env.Parse(&c)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -151,7 +441,91 @@ func New() *Config {
 		flag.StringVar(&c.TrustedSubnet, "t", c.TrustedSubnet, "TrustedSubnet")
 	}
 
+	if checkExists("st") {
+		flag.StringVar(&c.Storage, "st", c.Storage, "Storage")
+	}
+
+	if checkExists("p") {
+		flag.StringVar(&c.PolicyFile, "p", c.PolicyFile, "PolicyFile")
+	}
+
+	if checkExists("m") {
+		flag.StringVar(&c.MetricsAddress, "m", c.MetricsAddress, "MetricsAddress")
+	}
+
 	flag.Parse()
 
+	_, err = c.TrustedProxyNetworks()
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
+	if c.HasCookieKeys() {
+		_, err = c.CookieKeyring()
+		if err != nil {
+			log.Fatalf("Invalid cookie keys: %v", err)
+		}
+	}
+
+	_, err = c.CookieSettings()
+	if err != nil {
+		log.Fatalf("Invalid cookie settings: %v", err)
+	}
+
+	_, err = c.TokenVerifier()
+	if err != nil {
+		log.Fatalf("Invalid token keys: %v", err)
+	}
+
+	_, err = c.Logger(ioutil.Discard)
+	if err != nil {
+		log.Fatalf("Invalid logger settings: %v", err)
+	}
+
+	err = c.validateClicks()
+	if err != nil {
+		log.Fatalf("Invalid click settings: %v", err)
+	}
+
+	err = c.resolveStorage()
+	if err != nil {
+		log.Fatalf("Invalid storage: %v", err)
+	}
+
 	return &c
 }
+
+// validateClicks checks the settings of the click collector, they must be positive
+func (c *Config) validateClicks() error {
+	if c.ClickBatchSize <= 0 {
+		return fmt.Errorf("the click batch size must be positive, got %d", c.ClickBatchSize)
+	}
+
+	if c.ClickFlushInterval <= 0 {
+		return fmt.Errorf("the click flush interval must be positive, got %d", c.ClickFlushInterval)
+	}
+
+	if c.ClickBuffer <= 0 {
+		return fmt.Errorf("the click buffer must be positive, got %d", c.ClickBuffer)
+	}
+
+	return nil
+}
+
+// resolveStorage chooses the storage by DatabaseDSN if Storage is empty, an unknown value is an error
+// instead of falling back to the file storage
+func (c *Config) resolveStorage() error {
+	switch c.Storage {
+	case "":
+		c.Storage = StorageFile
+
+		if c.DatabaseDSN != "" {
+			c.Storage = StoragePostgres
+		}
+	case StorageMemory, StorageFile, StoragePostgres:
+	default:
+		return fmt.Errorf("unknown storage %q, expected %s, %s or %s", c.Storage, StorageMemory, StorageFile, StoragePostgres)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package configs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ResolveStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		dsn     string
+		want    string
+		wantErr bool
+	}{
+		{name: "file by default", want: StorageFile},
+		{name: "postgres by dsn", dsn: "postgres://localhost/db", want: StoragePostgres},
+		{name: "explicit memory", storage: StorageMemory, dsn: "postgres://localhost/db", want: StorageMemory},
+		{name: "typo", storage: "postgress", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Storage: tt.storage, DatabaseDSN: tt.dsn}
+
+			err := c.resolveStorage()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, c.Storage)
+		})
+	}
+}
+
+func TestConfig_ValidateClicks(t *testing.T) {
+	c := defaultConfig()
+	require.NoError(t, c.validateClicks())
+
+	for _, set := range []func(c *Config){
+		func(c *Config) { c.ClickBatchSize = 0 },
+		func(c *Config) { c.ClickFlushInterval = 0 },
+		func(c *Config) { c.ClickBuffer = -1 },
+	} {
+		c := defaultConfig()
+		set(&c)
+
+		assert.Error(t, c.validateClicks())
+	}
+}
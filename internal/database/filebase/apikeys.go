@@ -0,0 +1,152 @@
+package filebase
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// keysPath returns the path of the API keys file next to the urls file: storage.json -> storage.keys.json
+func keysPath(filePath string) string {
+	ext := filepath.Ext(strings.TrimSpace(filePath))
+
+	return strings.TrimSuffix(strings.TrimSpace(filePath), ext) + ".keys" + ext
+}
+
+func (repo *Repository) AddAPIKey(ctx context.Context, key models.APIKey) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	err := appendFile(repo.keysPath, &key)
+	if err != nil {
+		return err
+	}
+
+	repo.keys = append(repo.keys, key)
+
+	return nil
+}
+
+func (repo *Repository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for _, key := range repo.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+
+	return models.APIKey{}, customerrors.NotFound("the key not found")
+}
+
+func (repo *Repository) GetUserAPIKeys(ctx context.Context, user models.UserID) ([]models.APIKey, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	result := []models.APIKey{}
+
+	for _, key := range repo.keys {
+		if key.User == user {
+			result = append(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+// DeleteAPIKey rewrites the keys file without the key
+func (repo *Repository) DeleteAPIKey(ctx context.Context, user models.UserID, id string) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for i, key := range repo.keys {
+		if key.ID != id || key.User != user {
+			continue
+		}
+
+		keys := append(repo.keys[:i:i], repo.keys[i+1:]...)
+
+		err := repo.writeKeys(keys)
+		if err != nil {
+			return err
+		}
+
+		repo.keys = keys
+
+		return nil
+	}
+
+	return customerrors.NotFound("the key not found")
+}
+
+// TouchAPIKey rewrites the keys file with the new last use of the key
+func (repo *Repository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for i, key := range repo.keys {
+		if key.ID != id {
+			continue
+		}
+
+		keys := append([]models.APIKey(nil), repo.keys...)
+		keys[i].LastUsedAt = &usedAt
+
+		err := repo.writeKeys(keys)
+		if err != nil {
+			return err
+		}
+
+		repo.keys = keys
+
+		return nil
+	}
+
+	return customerrors.NotFound("the key not found")
+}
+
+// writeKeys replaces the keys file, the caller must hold the lock
+func (repo *Repository) writeKeys(keys []models.APIKey) error {
+	lines := make([]interface{}, 0, len(keys))
+	for i := range keys {
+		lines = append(lines, &keys[i])
+	}
+
+	return replaceFile(repo.keysPath, lines...)
+}
+
+// readKeys loads the keys file, a missing file means no keys
+func (repo *Repository) readKeys() error {
+	file, err := os.Open(repo.keysPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var key models.APIKey
+
+		err = json.Unmarshal(scanner.Bytes(), &key)
+		if err != nil {
+			return err
+		}
+
+		repo.keys = append(repo.keys, key)
+	}
+
+	return scanner.Err()
+}
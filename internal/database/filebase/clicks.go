@@ -0,0 +1,96 @@
+package filebase
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// clicksPath returns the path of the redirects file next to the urls file: storage.json -> storage.clicks.json
+func clicksPath(filePath string) string {
+	ext := filepath.Ext(strings.TrimSpace(filePath))
+
+	return strings.TrimSuffix(strings.TrimSpace(filePath), ext) + ".clicks" + ext
+}
+
+func (repo *Repository) AddClicks(ctx context.Context, clicks ...models.Click) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	lines := make([]interface{}, 0, len(clicks))
+	for i := range clicks {
+		lines = append(lines, &clicks[i])
+	}
+
+	err := appendFile(repo.clicksPath, lines...)
+	if err != nil {
+		return err
+	}
+
+	for _, click := range clicks {
+		repo.clicks[click.ShortURL] = append(repo.clicks[click.ShortURL], click)
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetClicks(ctx context.Context, shortURL models.ShortURL) ([]models.Click, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	result := make([]models.Click, len(repo.clicks[shortURL]))
+	copy(result, repo.clicks[shortURL])
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// readClicks loads the redirects file, a missing file means no clicks
+func (repo *Repository) readClicks() error {
+	file, err := os.Open(repo.clicksPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var click models.Click
+
+		err = json.Unmarshal(scanner.Bytes(), &click)
+		if err != nil {
+			return err
+		}
+
+		repo.clicks[click.ShortURL] = append(repo.clicks[click.ShortURL], click)
+	}
+
+	return scanner.Err()
+}
+
+// compactClicks replaces the redirects file with the clicks of the stored urls, the caller must hold the lock
+func (repo *Repository) compactClicks() error {
+	var lines []interface{}
+
+	for _, shortURL := range repo.order {
+		for i := range repo.clicks[shortURL] {
+			lines = append(lines, &repo.clicks[shortURL][i])
+		}
+	}
+
+	return replaceFile(repo.clicksPath, lines...)
+}
@@ -0,0 +1,174 @@
+package filebase
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// deleteJobRow is a line of the delete jobs file
+type deleteJobRow struct {
+	User models.UserID              `json:"user"`
+	Job  handlers.ResponseDeleteJob `json:"job"`
+}
+
+// deleteJobsPath returns the path of the delete jobs file next to the urls file: storage.json -> storage.deletes.json
+func deleteJobsPath(filePath string) string {
+	ext := filepath.Ext(strings.TrimSpace(filePath))
+
+	return strings.TrimSuffix(strings.TrimSpace(filePath), ext) + ".deletes" + ext
+}
+
+func (repo *Repository) AddDeleteJob(ctx context.Context, user models.UserID, job handlers.ResponseDeleteJob) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	job.URLs = append([]handlers.DeleteURLResult(nil), job.URLs...)
+
+	err := appendFile(repo.deleteJobsPath, &deleteJobRow{User: user, Job: job})
+	if err != nil {
+		return err
+	}
+
+	repo.deleteJobs[job.ID] = &deleteJobRow{User: user, Job: job}
+
+	return nil
+}
+
+// UpdateDeleteJob rewrites the delete jobs file with the changed job
+func (repo *Repository) UpdateDeleteJob(ctx context.Context, id string, update func(job *handlers.ResponseDeleteJob)) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	stored, ok := repo.deleteJobs[id]
+	if !ok {
+		return customerrors.NotFound("the job not found")
+	}
+
+	changed := *stored
+	changed.Job.URLs = append([]handlers.DeleteURLResult(nil), stored.Job.URLs...)
+
+	update(&changed.Job)
+
+	repo.deleteJobs[id] = &changed
+
+	err := repo.writeDeleteJobs()
+	if err != nil {
+		repo.deleteJobs[id] = stored
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetDeleteJob(ctx context.Context, id string) (models.UserID, handlers.ResponseDeleteJob, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	stored, ok := repo.deleteJobs[id]
+	if !ok {
+		return "", handlers.ResponseDeleteJob{}, customerrors.NotFound("the job not found")
+	}
+
+	job := stored.Job
+	job.URLs = append([]handlers.DeleteURLResult(nil), stored.Job.URLs...)
+
+	return stored.User, job, nil
+}
+
+func (repo *Repository) RemoveDeleteJob(ctx context.Context, id string) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	stored, ok := repo.deleteJobs[id]
+	if !ok {
+		return nil
+	}
+
+	delete(repo.deleteJobs, id)
+
+	err := repo.writeDeleteJobs()
+	if err != nil {
+		repo.deleteJobs[id] = stored
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) PurgeDeleteJobs(ctx context.Context, completedBefore, createdBefore time.Time) (int, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	purged := map[string]*deleteJobRow{}
+
+	for id, stored := range repo.deleteJobs {
+		completedAt := stored.Job.CompletedAt
+
+		if (completedAt != nil && completedAt.Before(completedBefore)) || (completedAt == nil && stored.Job.CreatedAt.Before(createdBefore)) {
+			purged[id] = stored
+			delete(repo.deleteJobs, id)
+		}
+	}
+
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	err := repo.writeDeleteJobs()
+	if err != nil {
+		for id, stored := range purged {
+			repo.deleteJobs[id] = stored
+		}
+
+		return 0, err
+	}
+
+	return len(purged), nil
+}
+
+// writeDeleteJobs replaces the delete jobs file, the caller must hold the lock
+func (repo *Repository) writeDeleteJobs() error {
+	lines := make([]interface{}, 0, len(repo.deleteJobs))
+	for _, stored := range repo.deleteJobs {
+		lines = append(lines, stored)
+	}
+
+	return replaceFile(repo.deleteJobsPath, lines...)
+}
+
+// readDeleteJobs loads the delete jobs file, a missing file means no jobs
+func (repo *Repository) readDeleteJobs() error {
+	file, err := os.Open(repo.deleteJobsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var stored deleteJobRow
+
+		err = json.Unmarshal(scanner.Bytes(), &stored)
+		if err != nil {
+			return err
+		}
+
+		repo.deleteJobs[stored.Job.ID] = &stored
+	}
+
+	return scanner.Err()
+}
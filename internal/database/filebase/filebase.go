@@ -10,7 +10,9 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
@@ -19,28 +21,100 @@ import (
 type Repository struct {
 	filePath string
 	baseURL  string
-	urls     models.ShortURLs
 	usersURL map[models.UserID][]models.ShortURL
-	mtx      sync.Mutex
+	// records - the latest state of every short url
+	records map[models.ShortURL]*row
+	// origins - short urls of the long url in the order of creation
+	origins map[models.LongURL][]models.ShortURL
+	// order - all short urls in the order of creation
+	order []models.ShortURL
+	// clicksPath - path to the file of the redirects
+	clicksPath string
+	// clicks - redirects by a short url
+	clicks map[models.ShortURL][]models.Click
+	// keysPath - path to the file of the API keys
+	keysPath string
+	// keys - the API keys in the order of creation
+	keys []models.APIKey
+	// deleteJobsPath - path to the file of the delete jobs
+	deleteJobsPath string
+	// deleteJobs - the delete jobs by id
+	deleteJobs map[string]*deleteJobRow
+	mtx        sync.Mutex
 }
 
 type row struct {
-	ShortURL string `json:"short_url"`
-	LongURL  string `json:"long_url"`
-	User     string `json:"user"`
+	ShortURL  string     `json:"short_url"`
+	LongURL   string     `json:"long_url"`
+	User      string     `json:"user"`
+	IsDeleted bool       `json:"is_deleted,omitempty"`
+	IsBlocked bool       `json:"is_blocked,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks int        `json:"max_clicks,omitempty"`
+	Clicks    int        `json:"clicks,omitempty"`
+}
+
+func newRow(longURL models.LongURL, shortURL models.ShortURL, user models.UserID, expiration models.Expiration) row {
+	r := row{
+		LongURL:   longURL,
+		ShortURL:  shortURL,
+		User:      user,
+		MaxClicks: expiration.MaxClicks,
+	}
+
+	if !expiration.ExpiresAt.IsZero() {
+		expiresAt := expiration.ExpiresAt.UTC()
+		r.ExpiresAt = &expiresAt
+	}
+
+	return r
+}
+
+func (r row) expiration() models.Expiration {
+	expiration := models.Expiration{
+		MaxClicks: r.MaxClicks,
+	}
+
+	if r.ExpiresAt != nil {
+		expiration.ExpiresAt = *r.ExpiresAt
+	}
+
+	return expiration
 }
 
 func FileRepository(ctx context.Context, filePath string, baseURL string) *Repository {
 	repo := Repository{
-		urls:     models.ShortURLs{},
-		filePath: filePath,
-		baseURL:  baseURL,
-		usersURL: map[models.UserID][]models.ShortURL{},
+		filePath:       filePath,
+		baseURL:        baseURL,
+		usersURL:       map[models.UserID][]models.ShortURL{},
+		records:        map[models.ShortURL]*row{},
+		origins:        map[models.LongURL][]models.ShortURL{},
+		clicksPath:     clicksPath(filePath),
+		clicks:         map[models.ShortURL][]models.Click{},
+		keysPath:       keysPath(filePath),
+		deleteJobsPath: deleteJobsPath(filePath),
+		deleteJobs:     map[string]*deleteJobRow{},
+	}
+
+	err := repo.readClicks()
+	if err != nil {
+		log.Printf("Error while reading clicks: %v\n", err)
+	}
+
+	err = repo.readKeys()
+	if err != nil {
+		log.Printf("Error while reading API keys: %v\n", err)
+	}
+
+	err = repo.readDeleteJobs()
+	if err != nil {
+		log.Printf("Error while reading delete jobs: %v\n", err)
 	}
 
 	cns, err := newConsumer(filePath)
 	if err != nil {
 		log.Printf("Error with reading file: %v\n", err)
+		return &repo
 	}
 	defer cns.Close()
 
@@ -86,17 +160,22 @@ type consumer struct {
 	decoder *json.Decoder
 }
 
-func (repo *Repository) AddURL(ctx context.Context, longURL, shortURL string, userID models.UserID) error {
+func (repo *Repository) AddURL(ctx context.Context, longURL, shortURL string, userID models.UserID, expiration models.Expiration) error {
 	repo.mtx.Lock()
 	defer repo.mtx.Unlock()
 
-	repo.urls[shortURL] = longURL
-	err := repo.writeRow(longURL, shortURL, repo.filePath, userID)
+	if _, ok := repo.records[shortURL]; ok {
+		return repo.conflictError(shortURL, longURL)
+	}
+
+	r := newRow(longURL, shortURL, userID, expiration)
+
+	err := repo.writeRows(r)
 	if err != nil {
 		return errors.New("unexpected error when writing row")
 	}
 
-	repo.usersURL[userID] = append(repo.usersURL[userID], shortURL)
+	repo.applyRow(r)
 
 	return nil
 }
@@ -105,12 +184,51 @@ func (repo *Repository) GetURL(ctx context.Context, sl models.ShortURL) (models.
 	repo.mtx.Lock()
 	defer repo.mtx.Unlock()
 
-	sl, ok := repo.urls[sl]
-	if !ok {
-		return "", errors.New("url not found")
+	r, err := repo.get(sl, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return r.LongURL, nil
+}
+
+func (repo *Repository) VisitURL(ctx context.Context, sl models.ShortURL) (models.LongURL, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	r, err := repo.get(sl, time.Now())
+	if err != nil {
+		return "", err
 	}
 
-	return sl, nil
+	visited := *r
+	visited.Clicks++
+
+	// only the limited clicks are saved to keep the journal short
+	if visited.MaxClicks > 0 {
+		err = repo.writeRows(visited)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	repo.applyRow(visited)
+
+	return r.LongURL, nil
+}
+
+func (repo *Repository) GetShortURL(ctx context.Context, longURL models.LongURL) (models.ShortURL, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for _, shortURL := range repo.origins[longURL] {
+		r := repo.records[shortURL]
+		if !r.IsDeleted && r.expiration().IsZero() {
+			return shortURL, nil
+		}
+	}
+
+	return "", customerrors.NotFound("the short url not found")
 }
 
 func (repo *Repository) GetUserURLs(ctx context.Context, userID models.UserID) ([]handlers.ResponseGetURL, error) {
@@ -122,9 +240,13 @@ func (repo *Repository) GetUserURLs(ctx context.Context, userID models.UserID) (
 	shortLinks := repo.usersURL[userID]
 
 	for _, v := range shortLinks {
+		if repo.records[v].IsDeleted {
+			continue
+		}
+
 		result = append(result, handlers.ResponseGetURL{
 			ShortURL:    fmt.Sprintf("%s/%s", repo.baseURL, v),
-			OriginalURL: repo.urls[v],
+			OriginalURL: repo.records[v].LongURL,
 		})
 	}
 
@@ -132,19 +254,203 @@ func (repo *Repository) GetUserURLs(ctx context.Context, userID models.UserID) (
 }
 
 func (repo *Repository) DeleteURLs(ctx context.Context, user models.UserID, urls ...string) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	var rows []row
+
+	for _, url := range urls {
+		r, ok := repo.records[url]
+		if !ok || r.User != user || r.IsDeleted {
+			continue
+		}
+
+		deleted := *r
+		deleted.IsDeleted = true
+
+		rows = append(rows, deleted)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	err := repo.writeRows(rows...)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		repo.applyRow(r)
+	}
+
 	return nil
 }
 
+func (repo *Repository) BlockURLs(ctx context.Context, blocked func(longURL models.LongURL) bool) (int, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	var rows []row
+
+	for _, r := range repo.records {
+		isBlocked := blocked(r.LongURL)
+		if isBlocked == r.IsBlocked {
+			continue
+		}
+
+		changed := *r
+		changed.IsBlocked = isBlocked
+
+		rows = append(rows, changed)
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	err := repo.writeRows(rows...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range rows {
+		repo.applyRow(r)
+	}
+
+	return len(rows), nil
+}
+
 func (repo *Repository) Ping(ctx context.Context) error {
-	return errors.New("not supported with filebase repository")
+	p, err := newProducer(repo.filePath)
+	if err != nil {
+		return err
+	}
+
+	return p.Close()
 }
 
 func (repo *Repository) AddURLs(ctx context.Context, user models.UserID, urls ...handlers.RequestGetURLs) ([]handlers.ResponseGetURLs, error) {
-	return nil, nil
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	var result []handlers.ResponseGetURLs
+
+	rows := make([]row, 0, len(urls))
+	batch := map[models.ShortURL]models.LongURL{}
+
+	for _, u := range urls {
+		if _, ok := repo.records[u.ShortURL]; ok {
+			return nil, repo.conflictError(u.ShortURL, u.OriginalURL)
+		}
+
+		if longURL, ok := batch[u.ShortURL]; ok {
+			if longURL == u.OriginalURL {
+				return nil, customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil)
+			}
+
+			return nil, customerrors.Conflict(customerrors.ReasonCollision, "the short url is already taken", nil)
+		}
+
+		batch[u.ShortURL] = u.OriginalURL
+
+		rows = append(rows, newRow(u.OriginalURL, u.ShortURL, user, u.Expiration()))
+		result = append(result, handlers.ResponseGetURLs{
+			CorrelationID: u.CorrelationID,
+			ShortURL:      fmt.Sprintf("%s/%s", repo.baseURL, u.ShortURL),
+		})
+	}
+
+	err := repo.writeRows(rows...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		repo.applyRow(r)
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) SweepURLs(ctx context.Context, now time.Time, archive bool) (int, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	var rows []row
+
+	for _, r := range repo.records {
+		if r.expiration().Expired(now, r.Clicks) && !(archive && r.IsDeleted) {
+			rows = append(rows, *r)
+		}
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	if archive {
+		for i := range rows {
+			rows[i].IsDeleted = true
+		}
+
+		err := repo.writeRows(rows...)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, r := range rows {
+			repo.applyRow(r)
+		}
+
+		return len(rows), nil
+	}
+
+	for _, r := range rows {
+		repo.removeRow(r)
+	}
+
+	err := repo.compact()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rows), repo.compactClicks()
+}
+
+func (repo *Repository) GetOwner(ctx context.Context, shortURL models.ShortURL) (models.UserID, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	r, ok := repo.records[shortURL]
+	if !ok {
+		return "", customerrors.NotFound("the short url not found")
+	}
+
+	return r.User, nil
+}
+
+func (repo *Repository) GetShortURLs(ctx context.Context) ([]models.ShortURL, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	result := make([]models.ShortURL, 0, len(repo.records))
+
+	for shortURL := range repo.records {
+		result = append(result, shortURL)
+	}
+
+	return result, nil
 }
 
 func (repo *Repository) GetStates(ctx context.Context) (handlers.ResponseStates, error) {
-	return handlers.ResponseStates{}, nil
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	return handlers.ResponseStates{
+		Urls:  len(repo.records),
+		Users: len(repo.usersURL),
+	}, nil
 }
 
 func newProducer(filename string) (*producer, error) {
@@ -189,41 +495,168 @@ func (repo *Repository) readRow(reader *bufio.Scanner) (bool, error) {
 	}
 	data := reader.Bytes()
 
-	row := &row{}
+	row := row{}
 
-	err := json.Unmarshal(data, row)
+	err := json.Unmarshal(data, &row)
 
 	if err != nil {
 		return false, err
 	}
-	repo.urls[row.ShortURL] = row.LongURL
-	repo.usersURL[row.User] = append(repo.usersURL[row.User], row.ShortURL)
+
+	repo.applyRow(row)
 
 	return true, nil
 }
 
-func (repo *Repository) writeRow(longURL, shortURL, filePath, userID string) error {
+// get returns the record that can be followed at now, the caller must hold the lock
+func (repo *Repository) get(shortURL models.ShortURL, now time.Time) (*row, error) {
+	r, ok := repo.records[shortURL]
+	if !ok {
+		return nil, customerrors.NotFound("the short url not found")
+	}
+
+	if r.IsDeleted {
+		return nil, customerrors.Gone(customerrors.ReasonDeleted, "the link is deleted")
+	}
+
+	if r.IsBlocked {
+		return nil, customerrors.Gone(customerrors.ReasonBlocked, "the destination is blocked")
+	}
+
+	if r.expiration().Expired(now, r.Clicks) {
+		return nil, customerrors.Gone(customerrors.ReasonExpired, "the link is expired")
+	}
+
+	return r, nil
+}
+
+// applyRow applies a journal row to the in-memory state, every row holds the whole state of the short url.
+// Deletion is final, the rows written before the deletion can not restore the url.
+func (repo *Repository) applyRow(r row) {
+	prev, ok := repo.records[r.ShortURL]
+	if !ok {
+		repo.usersURL[r.User] = append(repo.usersURL[r.User], r.ShortURL)
+		repo.origins[r.LongURL] = append(repo.origins[r.LongURL], r.ShortURL)
+		repo.order = append(repo.order, r.ShortURL)
+	}
+
+	if ok && prev.IsDeleted {
+		r.IsDeleted = true
+	}
+
+	repo.records[r.ShortURL] = &r
+}
+
+// removeRow forgets the short url, the caller must hold the lock
+func (repo *Repository) removeRow(r row) {
+	delete(repo.records, r.ShortURL)
+	delete(repo.clicks, r.ShortURL)
+
+	repo.order = without(repo.order, r.ShortURL)
+
+	repo.usersURL[r.User] = without(repo.usersURL[r.User], r.ShortURL)
+	if len(repo.usersURL[r.User]) == 0 {
+		delete(repo.usersURL, r.User)
+	}
+
+	repo.origins[r.LongURL] = without(repo.origins[r.LongURL], r.ShortURL)
+	if len(repo.origins[r.LongURL]) == 0 {
+		delete(repo.origins, r.LongURL)
+	}
+}
+
+// compact replaces the journal with the current state of the urls, the caller must hold the lock
+func (repo *Repository) compact() error {
+	lines := make([]interface{}, 0, len(repo.order))
+	for _, shortURL := range repo.order {
+		lines = append(lines, repo.records[shortURL])
+	}
+
+	return replaceFile(repo.filePath, lines...)
+}
+
+// conflictError describes an attempt to save longURL under the stored shortURL, the caller must hold the lock
+func (repo *Repository) conflictError(shortURL models.ShortURL, longURL models.LongURL) error {
+	if r := repo.records[shortURL]; r.LongURL == longURL && !r.IsDeleted {
+		return customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil)
+	}
+
+	return customerrors.Conflict(customerrors.ReasonCollision, "the short url is already taken", nil)
+}
+
+func (repo *Repository) writeRows(rows ...row) error {
+	lines := make([]interface{}, 0, len(rows))
+	for i := range rows {
+		lines = append(lines, &rows[i])
+	}
+
+	return appendFile(repo.filePath, lines...)
+}
+
+// appendFile writes the values to the end of the file one per line
+func appendFile(filePath string, lines ...interface{}) error {
 	p, err := newProducer(filePath)
 	if err != nil {
 		return err
 	}
 
-	data, err := json.Marshal(&row{
-		LongURL:  longURL,
-		ShortURL: shortURL,
-		User:     userID,
-	})
+	defer p.Close()
+
+	return encodeLines(p.write, lines...)
+}
+
+// replaceFile atomically replaces the file with the values one per line
+func replaceFile(filePath string, lines ...interface{}) error {
+	tmpPath := filePath + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
 		return err
 	}
 
-	if _, err := p.write.Write(data); err != nil {
+	err = encodeLines(bufio.NewWriter(file), lines...)
+	if err != nil {
+		file.Close()
 		return err
 	}
 
-	if err := p.write.WriteByte('\n'); err != nil {
+	err = file.Close()
+	if err != nil {
 		return err
 	}
 
-	return p.write.Flush()
+	return os.Rename(tmpPath, filePath)
+}
+
+// encodeLines writes the values as JSON one per line and flushes w
+func encodeLines(w *bufio.Writer, lines ...interface{}) error {
+	for _, line := range lines {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// without returns shortURLs without shortURL
+func without(shortURLs []models.ShortURL, shortURL models.ShortURL) []models.ShortURL {
+	result := shortURLs[:0]
+
+	for _, s := range shortURLs {
+		if s != shortURL {
+			result = append(result, s)
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,193 @@
+package filebase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/repotest"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/jobs"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+)
+
+func TestRepository_Reload(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+
+	repo := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	urls, err := repo.AddURLs(ctx, "user", handlers.RequestGetURLs{CorrelationID: "1", OriginalURL: "https://go.dev", ShortURL: "go"},
+		handlers.RequestGetURLs{CorrelationID: "2", OriginalURL: "https://pkg.go.dev", ShortURL: "pkg"})
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+
+	err = repo.AddURL(ctx, "https://go.dev", "another", "user", models.Expiration{})
+	require.NoError(t, err)
+
+	var dbErr *customerrors.Error
+
+	err = repo.AddURL(ctx, "https://go.dev", "another", "user", models.Expiration{})
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "url_exists", dbErr.Reason)
+
+	require.NoError(t, repo.DeleteURLs(ctx, "stranger", "another"))
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "another"))
+
+	changed, err := repo.BlockURLs(ctx, func(longURL string) bool { return longURL == "https://pkg.go.dev" })
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	reloaded := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	_, err = reloaded.GetURL(ctx, "another")
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "deleted", dbErr.Reason)
+
+	_, err = reloaded.GetURL(ctx, "pkg")
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "blocked", dbErr.Reason)
+
+	states, err := reloaded.GetStates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, handlers.ResponseStates{Urls: 3, Users: 1}, states)
+
+	assert.NoError(t, reloaded.Ping(ctx))
+}
+
+func TestRepository_ReloadExpiration(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+
+	repo := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "past", "user", models.Expiration{ExpiresAt: time.Now().Add(-time.Hour)}))
+	require.NoError(t, repo.AddURL(ctx, "https://pkg.go.dev", "clicks", "user", models.Expiration{MaxClicks: 2}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev/doc", "forever", "user", models.Expiration{}))
+
+	_, err := repo.VisitURL(ctx, "clicks")
+	require.NoError(t, err)
+
+	var dbErr *customerrors.Error
+
+	reloaded := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	_, err = reloaded.VisitURL(ctx, "clicks")
+	require.NoError(t, err, "one click is left")
+
+	_, err = reloaded.VisitURL(ctx, "clicks")
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "expired", dbErr.Reason)
+
+	swept, err := reloaded.SweepURLs(ctx, time.Now(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, swept)
+
+	compacted := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	_, err = compacted.GetURL(ctx, "past")
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "not_found", dbErr.Reason)
+
+	states, err := compacted.GetStates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, handlers.ResponseStates{Urls: 1, Users: 1}, states)
+}
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, baseURL string) services.RepositoryInterface {
+		return NewFileRepository(context.Background(), filepath.Join(t.TempDir(), "storage.json"), baseURL)
+	})
+}
+
+func TestJobStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+
+	store, err := NewJobStore(filePath)
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		err = store.AddJob(ctx, jobs.Job{ID: id, Kind: "kind", Payload: json.RawMessage(`{"id":"` + id + `"}`)})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, store.DoneJob(ctx, "2"))
+	require.NoError(t, store.DoneJob(ctx, "unknown"))
+
+	store, err = NewJobStore(filePath)
+	require.NoError(t, err)
+
+	pending, err := store.PendingJobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, "1", pending[0].ID)
+	assert.Equal(t, "3", pending[1].ID)
+	assert.JSONEq(t, `{"id":"3"}`, string(pending[1].Payload))
+
+	data, err := ioutil.ReadFile(jobsPath(filePath))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"), "the done jobs are compacted")
+}
+
+func TestRepository_ReloadAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+
+	repo := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	require.NoError(t, repo.AddAPIKey(ctx, models.APIKey{ID: "1", User: "user", Hash: "a", Scopes: []string{"links:read"}}))
+	require.NoError(t, repo.AddAPIKey(ctx, models.APIKey{ID: "2", User: "user", Hash: "b", Scopes: []string{"links:write"}}))
+	require.NoError(t, repo.DeleteAPIKey(ctx, "user", "1"))
+	require.NoError(t, repo.TouchAPIKey(ctx, "2", time.Now()))
+
+	repo = FileRepository(ctx, filePath, "http://localhost:8080")
+
+	keys, err := repo.GetUserAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "2", keys[0].ID)
+	assert.NotNil(t, keys[0].LastUsedAt)
+}
+
+func TestRepository_ReloadDeleteJobs(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+	createdAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	repo := FileRepository(ctx, filePath, "http://localhost:8080")
+
+	job := handlers.ResponseDeleteJob{
+		ID:        "job",
+		Status:    handlers.DeleteJobPending,
+		URLs:      []handlers.DeleteURLResult{{ShortURL: "a", Outcome: handlers.DeletePending}},
+		CreatedAt: createdAt,
+	}
+
+	require.NoError(t, repo.AddDeleteJob(ctx, "user", job))
+	require.NoError(t, repo.AddDeleteJob(ctx, "user", handlers.ResponseDeleteJob{ID: "removed", CreatedAt: createdAt}))
+	require.NoError(t, repo.RemoveDeleteJob(ctx, "removed"))
+	require.NoError(t, repo.UpdateDeleteJob(ctx, "job", func(job *handlers.ResponseDeleteJob) {
+		job.URLs[0].Outcome = handlers.DeleteDeleted
+	}))
+
+	repo = FileRepository(ctx, filePath, "http://localhost:8080")
+
+	user, reloaded, err := repo.GetDeleteJob(ctx, "job")
+	require.NoError(t, err)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, handlers.DeleteDeleted, reloaded.URLs[0].Outcome)
+
+	_, _, err = repo.GetDeleteJob(ctx, "removed")
+	assert.Equal(t, customerrors.KindNotFound, customerrors.KindOf(err))
+}
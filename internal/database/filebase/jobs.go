@@ -0,0 +1,152 @@
+package filebase
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/jobs"
+)
+
+// jobsPath returns the path of the jobs journal next to the urls file: storage.json -> storage.jobs.json
+func jobsPath(filePath string) string {
+	ext := filepath.Ext(strings.TrimSpace(filePath))
+
+	return strings.TrimSuffix(strings.TrimSpace(filePath), ext) + ".jobs" + ext
+}
+
+// jobEntry is a line of the jobs journal: a new job or the id of a done one
+type jobEntry struct {
+	Job  *jobs.Job `json:"job,omitempty"`
+	Done string    `json:"done,omitempty"`
+}
+
+// JobStore keeps the jobs in an append-only journal, the done jobs are dropped when the journal is opened
+type JobStore struct {
+	mtx      sync.Mutex
+	filePath string
+	pending  map[string]jobs.Job
+	order    []string
+}
+
+// NewJobStore opens the jobs journal next to the urls file
+func NewJobStore(filePath string) (*JobStore, error) {
+	s := &JobStore{
+		filePath: jobsPath(filePath),
+		pending:  make(map[string]jobs.Job),
+	}
+
+	err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]interface{}, 0, len(s.order))
+	for _, id := range s.order {
+		job := s.pending[id]
+		lines = append(lines, jobEntry{Job: &job})
+	}
+
+	err = replaceFile(s.filePath, lines...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *JobStore) read() error {
+	file, err := os.Open(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry jobEntry
+
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return err
+		}
+
+		s.apply(entry)
+	}
+
+	return scanner.Err()
+}
+
+func (s *JobStore) apply(entry jobEntry) {
+	if entry.Job != nil {
+		if _, ok := s.pending[entry.Job.ID]; !ok {
+			s.order = append(s.order, entry.Job.ID)
+		}
+
+		s.pending[entry.Job.ID] = *entry.Job
+	}
+
+	if entry.Done != "" {
+		if _, ok := s.pending[entry.Done]; ok {
+			delete(s.pending, entry.Done)
+			s.order = without(s.order, entry.Done)
+		}
+	}
+}
+
+func (s *JobStore) AddJob(ctx context.Context, job jobs.Job) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	entry := jobEntry{Job: &job}
+
+	err := appendFile(s.filePath, entry)
+	if err != nil {
+		return err
+	}
+
+	s.apply(entry)
+
+	return nil
+}
+
+func (s *JobStore) DoneJob(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.pending[id]; !ok {
+		return nil
+	}
+
+	entry := jobEntry{Done: id}
+
+	err := appendFile(s.filePath, entry)
+	if err != nil {
+		return err
+	}
+
+	s.apply(entry)
+
+	return nil
+}
+
+func (s *JobStore) PendingJobs(ctx context.Context) ([]jobs.Job, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result := make([]jobs.Job, 0, len(s.order))
+	for _, id := range s.order {
+		result = append(result, s.pending[id])
+	}
+
+	return result, nil
+}
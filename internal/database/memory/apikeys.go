@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+func (repo *Repository) AddAPIKey(ctx context.Context, key models.APIKey) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	repo.keys = append(repo.keys, key)
+
+	return nil
+}
+
+func (repo *Repository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	for _, key := range repo.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+
+	return models.APIKey{}, customerrors.NotFound("the key not found")
+}
+
+func (repo *Repository) GetUserAPIKeys(ctx context.Context, user models.UserID) ([]models.APIKey, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	result := []models.APIKey{}
+
+	for _, key := range repo.keys {
+		if key.User == user {
+			result = append(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) DeleteAPIKey(ctx context.Context, user models.UserID, id string) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for i, key := range repo.keys {
+		if key.ID == id && key.User == user {
+			repo.keys = append(repo.keys[:i:i], repo.keys[i+1:]...)
+			return nil
+		}
+	}
+
+	return customerrors.NotFound("the key not found")
+}
+
+func (repo *Repository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for i := range repo.keys {
+		if repo.keys[i].ID == id {
+			repo.keys[i].LastUsedAt = &usedAt
+			return nil
+		}
+	}
+
+	return customerrors.NotFound("the key not found")
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// deleteJob is a delete job with the owner
+type deleteJob struct {
+	user models.UserID
+	job  handlers.ResponseDeleteJob
+}
+
+func (repo *Repository) AddDeleteJob(ctx context.Context, user models.UserID, job handlers.ResponseDeleteJob) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	repo.deleteJobs[job.ID] = &deleteJob{user: user, job: copyDeleteJob(job)}
+
+	return nil
+}
+
+func (repo *Repository) UpdateDeleteJob(ctx context.Context, id string, update func(job *handlers.ResponseDeleteJob)) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	stored, ok := repo.deleteJobs[id]
+	if !ok {
+		return customerrors.NotFound("the job not found")
+	}
+
+	update(&stored.job)
+
+	return nil
+}
+
+func (repo *Repository) GetDeleteJob(ctx context.Context, id string) (models.UserID, handlers.ResponseDeleteJob, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	stored, ok := repo.deleteJobs[id]
+	if !ok {
+		return "", handlers.ResponseDeleteJob{}, customerrors.NotFound("the job not found")
+	}
+
+	return stored.user, copyDeleteJob(stored.job), nil
+}
+
+func (repo *Repository) RemoveDeleteJob(ctx context.Context, id string) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	delete(repo.deleteJobs, id)
+
+	return nil
+}
+
+func (repo *Repository) PurgeDeleteJobs(ctx context.Context, completedBefore, createdBefore time.Time) (int, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	var purged int
+
+	for id, stored := range repo.deleteJobs {
+		completedAt := stored.job.CompletedAt
+
+		if (completedAt != nil && completedAt.Before(completedBefore)) || (completedAt == nil && stored.job.CreatedAt.Before(createdBefore)) {
+			delete(repo.deleteJobs, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// copyDeleteJob returns the job that does not share the urls with job
+func copyDeleteJob(job handlers.ResponseDeleteJob) handlers.ResponseDeleteJob {
+	job.URLs = append([]handlers.DeleteURLResult(nil), job.URLs...)
+
+	return job
+}
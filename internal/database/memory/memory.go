@@ -0,0 +1,354 @@
+// Package memory provides data storage in the process memory
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+)
+
+type Repository struct {
+	baseURL string
+	// urls - stored records by a short url
+	urls map[models.ShortURL]*record
+	// usersURL - short urls of the user in the order of creation
+	usersURL map[models.UserID][]models.ShortURL
+	// origins - short urls of the long url in the order of creation
+	origins map[models.LongURL][]models.ShortURL
+	// clicks - redirects by a short url
+	clicks map[models.ShortURL][]models.Click
+	// keys - the API keys in the order of creation
+	keys []models.APIKey
+	// deleteJobs - the delete jobs by id
+	deleteJobs map[string]*deleteJob
+	mtx        sync.RWMutex
+}
+
+type record struct {
+	LongURL   models.LongURL
+	User      models.UserID
+	IsDeleted bool
+	// IsBlocked - the destination is denied by the policy
+	IsBlocked bool
+	// Expiration - limits of the link lifetime
+	Expiration models.Expiration
+	// Clicks - number of redirects
+	Clicks int
+}
+
+func MemoryRepository(baseURL string) *Repository {
+	return &Repository{
+		baseURL:    baseURL,
+		urls:       map[models.ShortURL]*record{},
+		usersURL:   map[models.UserID][]models.ShortURL{},
+		origins:    map[models.LongURL][]models.ShortURL{},
+		clicks:     map[models.ShortURL][]models.Click{},
+		deleteJobs: map[string]*deleteJob{},
+	}
+}
+
+func NewMemoryRepository(baseURL string) services.RepositoryInterface {
+	return services.RepositoryInterface(MemoryRepository(baseURL))
+}
+
+func (repo *Repository) AddURL(ctx context.Context, longURL models.LongURL, shortURL models.ShortURL, user models.UserID, expiration models.Expiration) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	if r, ok := repo.urls[shortURL]; ok {
+		return conflictError(r, longURL)
+	}
+
+	repo.add(longURL, shortURL, user, expiration)
+
+	return nil
+}
+
+func (repo *Repository) AddURLs(ctx context.Context, user models.UserID, urls ...handlers.RequestGetURLs) ([]handlers.ResponseGetURLs, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	var result []handlers.ResponseGetURLs
+
+	batch := map[models.ShortURL]models.LongURL{}
+
+	for _, u := range urls {
+		if r, ok := repo.urls[u.ShortURL]; ok {
+			return nil, conflictError(r, u.OriginalURL)
+		}
+
+		if longURL, ok := batch[u.ShortURL]; ok {
+			return nil, conflictError(&record{LongURL: longURL}, u.OriginalURL)
+		}
+
+		batch[u.ShortURL] = u.OriginalURL
+	}
+
+	for _, u := range urls {
+		repo.add(u.OriginalURL, u.ShortURL, user, u.Expiration())
+
+		result = append(result, handlers.ResponseGetURLs{
+			CorrelationID: u.CorrelationID,
+			ShortURL:      fmt.Sprintf("%s/%s", repo.baseURL, u.ShortURL),
+		})
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) DeleteURLs(ctx context.Context, user models.UserID, urls ...string) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for _, url := range urls {
+		r, ok := repo.urls[url]
+		if ok && r.User == user {
+			r.IsDeleted = true
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetURL(ctx context.Context, shortURL models.ShortURL) (models.ShortURL, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	r, err := repo.get(shortURL, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return r.LongURL, nil
+}
+
+func (repo *Repository) VisitURL(ctx context.Context, shortURL models.ShortURL) (models.LongURL, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	r, err := repo.get(shortURL, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	r.Clicks++
+
+	return r.LongURL, nil
+}
+
+func (repo *Repository) GetShortURL(ctx context.Context, longURL models.LongURL) (models.ShortURL, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	for _, shortURL := range repo.origins[longURL] {
+		r := repo.urls[shortURL]
+		if !r.IsDeleted && r.Expiration.IsZero() {
+			return shortURL, nil
+		}
+	}
+
+	return "", customerrors.NotFound("the short url not found")
+}
+
+func (repo *Repository) GetUserURLs(ctx context.Context, user models.UserID) ([]handlers.ResponseGetURL, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	var result []handlers.ResponseGetURL
+
+	for _, shortURL := range repo.usersURL[user] {
+		r := repo.urls[shortURL]
+		if r.IsDeleted {
+			continue
+		}
+
+		result = append(result, handlers.ResponseGetURL{
+			ShortURL:    fmt.Sprintf("%s/%s", repo.baseURL, shortURL),
+			OriginalURL: r.LongURL,
+		})
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) BlockURLs(ctx context.Context, blocked func(longURL models.LongURL) bool) (int, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	changed := 0
+
+	for _, r := range repo.urls {
+		if isBlocked := blocked(r.LongURL); isBlocked != r.IsBlocked {
+			r.IsBlocked = isBlocked
+			changed++
+		}
+	}
+
+	return changed, nil
+}
+
+func (repo *Repository) SweepURLs(ctx context.Context, now time.Time, archive bool) (int, error) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	swept := 0
+
+	for shortURL, r := range repo.urls {
+		if !r.Expiration.Expired(now, r.Clicks) || (archive && r.IsDeleted) {
+			continue
+		}
+
+		swept++
+
+		if archive {
+			r.IsDeleted = true
+			continue
+		}
+
+		delete(repo.urls, shortURL)
+		delete(repo.clicks, shortURL)
+		repo.usersURL[r.User] = without(repo.usersURL[r.User], shortURL)
+		repo.origins[r.LongURL] = without(repo.origins[r.LongURL], shortURL)
+
+		if len(repo.usersURL[r.User]) == 0 {
+			delete(repo.usersURL, r.User)
+		}
+
+		if len(repo.origins[r.LongURL]) == 0 {
+			delete(repo.origins, r.LongURL)
+		}
+	}
+
+	return swept, nil
+}
+
+func (repo *Repository) AddClicks(ctx context.Context, clicks ...models.Click) error {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+
+	for _, click := range clicks {
+		repo.clicks[click.ShortURL] = append(repo.clicks[click.ShortURL], click)
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetClicks(ctx context.Context, shortURL models.ShortURL) ([]models.Click, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	return sortedClicks(repo.clicks[shortURL]), nil
+}
+
+func (repo *Repository) GetOwner(ctx context.Context, shortURL models.ShortURL) (models.UserID, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	r, ok := repo.urls[shortURL]
+	if !ok {
+		return "", customerrors.NotFound("the short url not found")
+	}
+
+	return r.User, nil
+}
+
+func (repo *Repository) GetShortURLs(ctx context.Context) ([]models.ShortURL, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	result := make([]models.ShortURL, 0, len(repo.urls))
+
+	for shortURL := range repo.urls {
+		result = append(result, shortURL)
+	}
+
+	return result, nil
+}
+
+func (repo *Repository) GetStates(ctx context.Context) (handlers.ResponseStates, error) {
+	repo.mtx.RLock()
+	defer repo.mtx.RUnlock()
+
+	return handlers.ResponseStates{
+		Urls:  len(repo.urls),
+		Users: len(repo.usersURL),
+	}, nil
+}
+
+func (repo *Repository) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// get returns the record that can be followed at now, the caller must hold the lock
+func (repo *Repository) get(shortURL models.ShortURL, now time.Time) (*record, error) {
+	r, ok := repo.urls[shortURL]
+	if !ok {
+		return nil, customerrors.NotFound("the short url not found")
+	}
+
+	if r.IsDeleted {
+		return nil, customerrors.Gone(customerrors.ReasonDeleted, "the link is deleted")
+	}
+
+	if r.IsBlocked {
+		return nil, customerrors.Gone(customerrors.ReasonBlocked, "the destination is blocked")
+	}
+
+	if r.Expiration.Expired(now, r.Clicks) {
+		return nil, customerrors.Gone(customerrors.ReasonExpired, "the link is expired")
+	}
+
+	return r, nil
+}
+
+// add stores a new record, the caller must hold the write lock
+func (repo *Repository) add(longURL models.LongURL, shortURL models.ShortURL, user models.UserID, expiration models.Expiration) {
+	repo.urls[shortURL] = &record{
+		LongURL:    longURL,
+		User:       user,
+		Expiration: expiration,
+	}
+	repo.usersURL[user] = append(repo.usersURL[user], shortURL)
+	repo.origins[longURL] = append(repo.origins[longURL], shortURL)
+}
+
+// conflictError describes an attempt to save longURL under the short url of the stored record
+func conflictError(r *record, longURL models.LongURL) error {
+	if r.LongURL == longURL && !r.IsDeleted {
+		return customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil)
+	}
+
+	return customerrors.Conflict(customerrors.ReasonCollision, "the short url is already taken", nil)
+}
+
+// without returns shortURLs without shortURL
+func without(shortURLs []models.ShortURL, shortURL models.ShortURL) []models.ShortURL {
+	result := shortURLs[:0]
+
+	for _, s := range shortURLs {
+		if s != shortURL {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// sortedClicks returns a copy of the clicks in the order of time
+func sortedClicks(clicks []models.Click) []models.Click {
+	result := make([]models.Click, len(clicks))
+	copy(result, clicks)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result
+}
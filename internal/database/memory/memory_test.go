@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/repotest"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+)
+
+func TestRepository_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := MemoryRepository("http://localhost:8080")
+
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			user := fmt.Sprintf("user%d", i%5)
+			shortURL := fmt.Sprintf("short%d", i)
+
+			assert.NoError(t, repo.AddURL(ctx, fmt.Sprintf("https://go.dev/%d", i), shortURL, user, models.Expiration{}))
+			_, err := repo.GetURL(ctx, shortURL)
+			assert.NoError(t, err)
+			_, err = repo.GetUserURLs(ctx, user)
+			assert.NoError(t, err)
+			assert.NoError(t, repo.DeleteURLs(ctx, user, shortURL))
+		}(i)
+	}
+
+	wg.Wait()
+
+	states, err := repo.GetStates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, handlers.ResponseStates{Urls: 50, Users: 5}, states)
+
+	var dbErr *customerrors.Error
+
+	_, err = repo.GetURL(ctx, "short0")
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "deleted", dbErr.Reason)
+}
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, baseURL string) services.RepositoryInterface {
+		return NewMemoryRepository(baseURL)
+	})
+}
@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// apiKeyColumns - the selected columns of api_keys in the order of scanAPIKey
+const apiKeyColumns = `id, user_id, name, hash, scopes, created_at, last_used_at`
+
+func (db *PostgresDatabase) AddAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := db.conn.ExecContext(ctx, `INSERT INTO api_keys (id, user_id, name, hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.ID, key.User, key.Name, key.Hash, pq.Array(key.Scopes), key.CreatedAt)
+
+	return err
+}
+
+func (db *PostgresDatabase) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	key, err := scanAPIKey(db.conn.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE hash=$1`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, customerrors.NotFound("the key not found")
+	}
+
+	return key, err
+}
+
+func (db *PostgresDatabase) GetUserAPIKeys(ctx context.Context, user models.UserID) ([]models.APIKey, error) {
+	rows, err := db.conn.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id=$1 ORDER BY created_at, id`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, key)
+	}
+
+	return result, rows.Err()
+}
+
+func (db *PostgresDatabase) DeleteAPIKey(ctx context.Context, user models.UserID, id string) error {
+	res, err := db.conn.ExecContext(ctx, `DELETE FROM api_keys WHERE id=$1 AND user_id=$2`, id, user)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(res)
+}
+
+func (db *PostgresDatabase) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	res, err := db.conn.ExecContext(ctx, `UPDATE api_keys SET last_used_at=$2 WHERE id=$1`, id, usedAt)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(res)
+}
+
+// requireAffected returns the Not found error if the statement changed no rows
+func requireAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return customerrors.NotFound("the key not found")
+	}
+
+	return nil
+}
+
+// scanner is a row of QueryRow or Query
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var key models.APIKey
+
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(&key.ID, &key.User, &key.Name, &key.Hash, pq.Array(&key.Scopes), &key.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	if lastUsedAt.Valid {
+		usedAt := lastUsedAt.Time.UTC()
+		key.LastUsedAt = &usedAt
+	}
+
+	key.CreatedAt = key.CreatedAt.UTC()
+
+	return key, nil
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+const (
+	// clickColumns - number of the inserted columns of url_clicks
+	clickColumns = 5
+	// maxClicksPerInsert keeps the statement below the limit of 65535 parameters
+	maxClicksPerInsert = 1000
+)
+
+// AddClicks saves the redirects with one statement per maxClicksPerInsert clicks
+func (db *PostgresDatabase) AddClicks(ctx context.Context, clicks ...models.Click) error {
+	for len(clicks) > maxClicksPerInsert {
+		err := db.insertClicks(ctx, clicks[:maxClicksPerInsert])
+		if err != nil {
+			return err
+		}
+
+		clicks = clicks[maxClicksPerInsert:]
+	}
+
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	return db.insertClicks(ctx, clicks)
+}
+
+func (db *PostgresDatabase) insertClicks(ctx context.Context, clicks []models.Click) error {
+	values := make([]string, 0, len(clicks))
+	args := make([]interface{}, 0, len(clicks)*clickColumns)
+
+	for i, click := range clicks {
+		n := i * clickColumns
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+		args = append(args, click.ShortURL, click.Time, click.Referrer, click.UserAgent, click.IP)
+	}
+
+	sqlAddClicks := `INSERT INTO url_clicks (short_url, clicked_at, referrer, user_agent, ip) VALUES ` + strings.Join(values, ", ")
+
+	_, err := db.conn.ExecContext(ctx, sqlAddClicks, args...)
+
+	return err
+}
+
+func (db *PostgresDatabase) GetClicks(ctx context.Context, shortURL models.ShortURL) ([]models.Click, error) {
+	sqlGetClicks := `SELECT clicked_at, referrer, user_agent, ip FROM url_clicks WHERE short_url=$1 ORDER BY clicked_at, id`
+
+	rows, err := db.conn.QueryContext(ctx, sqlGetClicks, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.Click{}
+
+	for rows.Next() {
+		click := models.Click{ShortURL: shortURL}
+
+		err = rows.Scan(&click.Time, &click.Referrer, &click.UserAgent, &click.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, click)
+	}
+
+	return result, rows.Err()
+}
@@ -27,18 +27,19 @@ func Conn(driverName, dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
+// SetUpDataBase applies pending migrations, it fails if the schema is newer than the app
 func SetUpDataBase(ctx context.Context, db *sql.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
 
-	sqlCreateDB := `CREATE TABLE IF NOT EXISTS urls (
-								id serial PRIMARY KEY,
-								user_id VARCHAR NOT NULL, 	
-								origin_url VARCHAR NOT NULL, 
-								short_url VARCHAR NOT NULL UNIQUE,
-                                is_deleted BOOLEAN NOT NULL DEFAULT FALSE
-					);`
-	res, err := db.ExecContext(ctx, sqlCreateDB)
+	version, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
 
-	log.Println("Create table", err, res)
+	log.Printf("Database schema version %d", version)
 
 	return nil
 }
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+func (db *PostgresDatabase) AddDeleteJob(ctx context.Context, user models.UserID, job handlers.ResponseDeleteJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.conn.ExecContext(ctx, `INSERT INTO delete_jobs (id, user_id, job, created_at, completed_at) VALUES ($1, $2, $3, $4, $5)`,
+		job.ID, user, string(data), job.CreatedAt, job.CompletedAt)
+
+	return err
+}
+
+// UpdateDeleteJob locks the row of the job, so the instances running the tasks of the job do not lose the outcomes
+func (db *PostgresDatabase) UpdateDeleteJob(ctx context.Context, id string, update func(job *handlers.ResponseDeleteJob)) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var data []byte
+
+	err = tx.QueryRowContext(ctx, `SELECT job FROM delete_jobs WHERE id=$1 FOR UPDATE`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return customerrors.NotFound("the job not found")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var job handlers.ResponseDeleteJob
+
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return err
+	}
+
+	update(&job)
+
+	data, err = json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE delete_jobs SET job=$2, completed_at=$3 WHERE id=$1`, id, string(data), job.CompletedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *PostgresDatabase) GetDeleteJob(ctx context.Context, id string) (models.UserID, handlers.ResponseDeleteJob, error) {
+	var (
+		user models.UserID
+		data []byte
+		job  handlers.ResponseDeleteJob
+	)
+
+	err := db.conn.QueryRowContext(ctx, `SELECT user_id, job FROM delete_jobs WHERE id=$1`, id).Scan(&user, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", job, customerrors.NotFound("the job not found")
+	}
+
+	if err != nil {
+		return "", job, err
+	}
+
+	err = json.Unmarshal(data, &job)
+
+	return user, job, err
+}
+
+func (db *PostgresDatabase) RemoveDeleteJob(ctx context.Context, id string) error {
+	_, err := db.conn.ExecContext(ctx, `DELETE FROM delete_jobs WHERE id=$1`, id)
+
+	return err
+}
+
+func (db *PostgresDatabase) PurgeDeleteJobs(ctx context.Context, completedBefore, createdBefore time.Time) (int, error) {
+	res, err := db.conn.ExecContext(ctx, `DELETE FROM delete_jobs WHERE completed_at < $1 OR (completed_at IS NULL AND created_at < $2)`,
+		completedBefore, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := res.RowsAffected()
+
+	return int(purged), err
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/jobs"
+)
+
+// JobStore keeps the jobs in the jobs table, a done job is deleted
+type JobStore struct {
+	conn *sql.DB
+}
+
+// NewJobStore is the job store constructor, the table is created by the migrations
+func NewJobStore(conn *sql.DB) *JobStore {
+	return &JobStore{conn: conn}
+}
+
+func (s *JobStore) AddJob(ctx context.Context, job jobs.Job) error {
+	_, err := s.conn.ExecContext(ctx, `INSERT INTO jobs (id, kind, payload, request_id, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		job.ID, job.Kind, string(job.Payload), job.RequestID, job.CreatedAt)
+
+	return err
+}
+
+func (s *JobStore) DoneJob(ctx context.Context, id string) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM jobs WHERE id=$1`, id)
+
+	return err
+}
+
+func (s *JobStore) PendingJobs(ctx context.Context) ([]jobs.Job, error) {
+	rows, err := s.conn.QueryContext(ctx, `SELECT id, kind, payload, request_id, created_at FROM jobs ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var result []jobs.Job
+
+	for rows.Next() {
+		var job jobs.Job
+
+		var payload []byte
+
+		err = rows.Scan(&job.ID, &job.Kind, &payload, &job.RequestID, &job.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		job.Payload = payload
+		result = append(result, job)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,281 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// ErrSchemaTooNew means that the database was migrated by a newer version of the app
+var ErrSchemaTooNew = errors.New("the database schema is newer than the app supports")
+
+// migrationsLockID - key of the advisory lock that serializes migrations between app instances
+const migrationsLockID = 7207163
+
+// Migration is a single versioned schema change
+type Migration struct {
+	// Version - sequence number from the file name prefix
+	Version int
+	// Name - the rest of the file name
+	Name string
+	// Up - statements applying the change
+	Up string
+	// Down - statements reverting the change
+	Down string
+}
+
+// Migrations returns the embedded migrations ordered by version.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+func Migrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, f := range files {
+		name := f.Name()
+
+		var direction string
+
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, "."+direction+".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("unexpected migration version in %s", name)
+		}
+
+		body, err := migrationsFS.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, parts[1])
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.Version)
+		}
+
+		result = append(result, *m)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	for i, m := range result {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+
+	return result, nil
+}
+
+// Migrator applies and rolls back the embedded migrations
+type Migrator struct {
+	conn       *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator is the migrator constructor
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		conn:       db,
+		migrations: migrations,
+	}, nil
+}
+
+// Latest returns the version of the newest known migration
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version returns the version of the last applied migration, 0 for an empty database
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	err := m.createVersionTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return version(ctx, m.conn)
+}
+
+// Check returns ErrSchemaTooNew if the database has migrations unknown to the app
+func (m *Migrator) Check(ctx context.Context) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current > m.Latest() {
+		return fmt.Errorf("%w: database version %d, app version %d", ErrSchemaTooNew, current, m.Latest())
+	}
+
+	return nil
+}
+
+// Up applies all pending migrations and returns the resulting version
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	err := m.Check(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, migration := range m.migrations {
+		err = m.apply(ctx, migration, true)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return m.Version(ctx)
+}
+
+// Down rolls back the given number of the applied migrations and returns the resulting version
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	err := m.Check(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := m.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := current; i > 0 && i > current-steps; i-- {
+		err = m.apply(ctx, m.migrations[i-1], false)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return m.Version(ctx)
+}
+
+func (m *Migrator) createVersionTable(ctx context.Context) error {
+	sqlCreateTable := `CREATE TABLE IF NOT EXISTS schema_migrations (
+								version INTEGER PRIMARY KEY,
+								name VARCHAR NOT NULL,
+								applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+					);`
+
+	_, err := m.conn.ExecContext(ctx, sqlCreateTable)
+
+	return err
+}
+
+// apply runs a migration in its own transaction, it is skipped if it was already applied (up) or reverted (down)
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) error {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1);`, migrationsLockID)
+	if err != nil {
+		return err
+	}
+
+	current, err := version(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if (up && current >= migration.Version) || (!up && current != migration.Version) {
+		return nil
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, migration.Up)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`, migration.Version, migration.Name)
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, migration.Down)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version=$1;`, migration.Version)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if up {
+		log.Printf("Migration %d_%s applied", migration.Version, migration.Name)
+	} else {
+		log.Printf("Migration %d_%s rolled back", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func version(ctx context.Context, q queryRower) (int, error) {
+	var current int
+
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`).Scan(&current)
+
+	return current, err
+}
@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+
+	conn, err := Conn("postgres", dsn)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	migrator, err := NewMigrator(conn)
+	require.NoError(t, err)
+
+	version, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), version)
+
+	version, err = migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest()-1, version)
+
+	version, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), version)
+
+	_, err = conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, 'future');`, migrator.Latest()+1)
+	require.NoError(t, err)
+
+	defer func() {
+		_, _ = conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version=$1;`, migrator.Latest()+1)
+	}()
+
+	err = migrator.Check(ctx)
+	assert.True(t, errors.Is(err, ErrSchemaTooNew))
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS urls (
+    id serial PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    origin_url VARCHAR NOT NULL,
+    short_url VARCHAR NOT NULL UNIQUE,
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE
+);
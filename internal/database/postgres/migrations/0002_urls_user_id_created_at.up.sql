@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS urls_origin_url_idx;
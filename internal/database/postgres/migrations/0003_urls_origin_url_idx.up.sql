@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS urls_origin_url_idx ON urls (origin_url);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS urls_expires_at_idx;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS clicks;
+ALTER TABLE urls DROP COLUMN IF EXISTS max_clicks;
+ALTER TABLE urls DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,5 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
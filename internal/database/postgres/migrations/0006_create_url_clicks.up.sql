@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS url_clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer VARCHAR NOT NULL DEFAULT '',
+    user_agent VARCHAR NOT NULL DEFAULT '',
+    ip VARCHAR NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS url_clicks_short_url_clicked_at_idx ON url_clicks (short_url, clicked_at);
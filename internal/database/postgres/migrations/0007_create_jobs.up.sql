@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id VARCHAR PRIMARY KEY,
+    kind VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    request_id VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS jobs_created_at_idx ON jobs (created_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS delete_jobs (
+    id VARCHAR PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    job JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS delete_jobs_created_at_idx ON delete_jobs (created_at);
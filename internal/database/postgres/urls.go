@@ -6,14 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
 
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
-	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
 )
 
 type PostgresDatabase struct {
@@ -24,6 +25,15 @@ type PostgresDatabase struct {
 type GetURLData struct {
 	OriginalURL string
 	IsDeleted   bool
+	IsBlocked   bool
+	ExpiresAt   sql.NullTime
+	MaxClicks   int
+	Clicks      int
+}
+
+// nullTime converts the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
 
 func DatabaseRepository(baseURL string, db *sql.DB) *PostgresDatabase {
@@ -37,23 +47,41 @@ func NewDatabaseRepository(baseURL string, db *sql.DB) services.RepositoryInterf
 	return services.RepositoryInterface(DatabaseRepository(baseURL, db))
 }
 
-func (db *PostgresDatabase) AddURL(ctx context.Context, longURL models.LongURL, shortURL models.ShortURL, user models.UserID) error {
-	sqlAddRow := `INSERT INTO urls (user_id, origin_url, short_url)
-				  VALUES ($1, $2, $3)`
+func (db *PostgresDatabase) AddURL(ctx context.Context, longURL models.LongURL, shortURL models.ShortURL, user models.UserID, expiration models.Expiration) error {
+	sqlAddRow := `INSERT INTO urls (user_id, origin_url, short_url, expires_at, max_clicks)
+				  VALUES ($1, $2, $3, $4, $5)`
 
-	_, err := db.conn.ExecContext(ctx, sqlAddRow, user, longURL, shortURL)
+	_, err := db.conn.ExecContext(ctx, sqlAddRow, user, longURL, shortURL, nullTime(expiration.ExpiresAt), expiration.MaxClicks)
 
 	var pgErr *pq.Error
 
 	if errors.As(err, &pgErr) {
 		if pgErr.Code == pgerrcode.UniqueViolation {
-			return handlers.NewErrorWithDB(err, "UniqConstraint")
+			return db.conflictError(ctx, err, shortURL, longURL)
 		}
 	}
 
 	return err
 }
 
+// conflictError describes a unique violation on short_url: the same url was already saved or the identifier is taken
+func (db *PostgresDatabase) conflictError(ctx context.Context, err error, shortURL models.ShortURL, longURL models.LongURL) error {
+	sqlGetURLRow := `SELECT origin_url, is_deleted FROM urls WHERE short_url=$1 LIMIT 1`
+
+	result := GetURLData{}
+
+	scanErr := db.conn.QueryRowContext(ctx, sqlGetURLRow, shortURL).Scan(&result.OriginalURL, &result.IsDeleted)
+	if scanErr != nil {
+		return scanErr
+	}
+
+	if result.OriginalURL == longURL && !result.IsDeleted {
+		return customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", err)
+	}
+
+	return customerrors.Conflict(customerrors.ReasonCollision, "the short url is already taken", err)
+}
+
 func (db *PostgresDatabase) AddURLs(ctx context.Context, user models.UserID, urls ...handlers.RequestGetURLs) ([]handlers.ResponseGetURLs, error) {
 	var result []handlers.ResponseGetURLs
 
@@ -62,7 +90,7 @@ func (db *PostgresDatabase) AddURLs(ctx context.Context, user models.UserID, url
 		return nil, err
 	}
 
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO urls (user_id, origin_url, short_url) VALUES ($1, $2, $3)`)
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO urls (user_id, origin_url, short_url, expires_at, max_clicks) VALUES ($1, $2, $3, $4, $5)`)
 	if err != nil {
 		return nil, err
 	}
@@ -78,14 +106,33 @@ func (db *PostgresDatabase) AddURLs(ctx context.Context, user models.UserID, url
 		}
 	}(stmt)
 
+	batch := map[models.ShortURL]models.LongURL{}
+
 	for _, u := range urls {
-		shortURL := shortener.ShorterURL(u.OriginalURL)
-		if _, err = stmt.ExecContext(ctx, user, u.OriginalURL, shortURL); err != nil {
+		if longURL, ok := batch[u.ShortURL]; ok {
+			if longURL == u.OriginalURL {
+				return nil, customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil)
+			}
+
+			return nil, customerrors.Conflict(customerrors.ReasonCollision, "the short url is already taken", nil)
+		}
+
+		batch[u.ShortURL] = u.OriginalURL
+
+		expiration := u.Expiration()
+
+		if _, err = stmt.ExecContext(ctx, user, u.OriginalURL, u.ShortURL, nullTime(expiration.ExpiresAt), expiration.MaxClicks); err != nil {
+			var pgErr *pq.Error
+
+			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+				return nil, db.conflictError(ctx, err, u.ShortURL, u.OriginalURL)
+			}
+
 			return nil, err
 		}
 		result = append(result, handlers.ResponseGetURLs{
 			CorrelationID: u.CorrelationID,
-			ShortURL:      fmt.Sprintf("%s/%s", db.baseURL, shortURL),
+			ShortURL:      fmt.Sprintf("%s/%s", db.baseURL, u.ShortURL),
 		})
 	}
 
@@ -165,38 +212,83 @@ func (db *PostgresDatabase) isOwner(ctx context.Context, url string, user string
 }
 
 func (db *PostgresDatabase) GetURL(ctx context.Context, shortURL models.ShortURL) (models.ShortURL, error) {
-	sqlGetURLRow := `SELECT origin_url, is_deleted FROM urls WHERE short_url=$1 LIMIT 1`
+	sqlGetURLRow := `SELECT origin_url, is_deleted, is_blocked, expires_at, max_clicks, clicks FROM urls WHERE short_url=$1 LIMIT 1`
 
 	row := db.conn.QueryRowContext(ctx, sqlGetURLRow, shortURL)
 
 	result := GetURLData{}
 
-	err := row.Scan(&result.OriginalURL, &result.IsDeleted)
+	err := row.Scan(&result.OriginalURL, &result.IsDeleted, &result.IsBlocked, &result.ExpiresAt, &result.MaxClicks, &result.Clicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", customerrors.NotFound("the short url not found")
+	}
 	if err != nil {
 		return "", err
 	}
 
 	if result.OriginalURL == "" {
-		return "", handlers.NewErrorWithDB(errors.New("not found"), "Not found")
+		return "", customerrors.NotFound("the short url not found")
 	}
 	if result.IsDeleted {
-		return "", handlers.NewErrorWithDB(errors.New("deleted"), "deleted")
+		return "", customerrors.Gone(customerrors.ReasonDeleted, "the link is deleted")
+	}
+	if result.IsBlocked {
+		return "", customerrors.Gone(customerrors.ReasonBlocked, "the destination is blocked")
+	}
+
+	expiration := models.Expiration{ExpiresAt: result.ExpiresAt.Time, MaxClicks: result.MaxClicks}
+	if expiration.Expired(time.Now(), result.Clicks) {
+		return "", customerrors.Gone(customerrors.ReasonExpired, "the link is expired")
 	}
 
 	return result.OriginalURL, nil
 }
 
+func (db *PostgresDatabase) VisitURL(ctx context.Context, shortURL models.ShortURL) (models.LongURL, error) {
+	sqlVisitURL := `UPDATE urls SET clicks = clicks + 1
+					WHERE short_url=$1 AND NOT is_deleted AND NOT is_blocked
+					AND (expires_at IS NULL OR expires_at > $2) AND (max_clicks = 0 OR clicks < max_clicks)
+					RETURNING origin_url`
+
+	var result models.LongURL
+
+	err := db.conn.QueryRowContext(ctx, sqlVisitURL, shortURL, time.Now()).Scan(&result)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return result, err
+	}
+
+	// the link can not be followed, GetURL tells why
+	_, err = db.GetURL(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+
+	return "", customerrors.Gone(customerrors.ReasonExpired, "the link is expired")
+}
+
+func (db *PostgresDatabase) GetShortURL(ctx context.Context, longURL models.LongURL) (models.ShortURL, error) {
+	sqlGetShortURL := `SELECT short_url FROM urls
+					   WHERE origin_url=$1 AND NOT is_deleted AND expires_at IS NULL AND max_clicks = 0
+					   ORDER BY id LIMIT 1`
+
+	var result models.ShortURL
+
+	err := db.conn.QueryRowContext(ctx, sqlGetShortURL, longURL).Scan(&result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", customerrors.NotFound("the short url not found")
+	}
+
+	return result, err
+}
+
 func (db *PostgresDatabase) GetUserURLs(ctx context.Context, user models.UserID) ([]handlers.ResponseGetURL, error) {
 	var result []handlers.ResponseGetURL
 
-	sqlGetUserURL := `SELECT origin_url, short_url FROM urls WHERE user_id=$1;`
+	sqlGetUserURL := `SELECT origin_url, short_url FROM urls WHERE user_id=$1 AND NOT is_deleted ORDER BY id;`
 	rows, err := db.conn.QueryContext(ctx, sqlGetUserURL, user)
 	if err != nil {
 		return result, err
 	}
-	if rows.Err() != nil {
-		return result, rows.Err()
-	}
 	defer rows.Close()
 
 	for rows.Next() {
@@ -209,7 +301,120 @@ func (db *PostgresDatabase) GetUserURLs(ctx context.Context, user models.UserID)
 		result = append(result, u)
 	}
 
-	return result, nil
+	return result, rows.Err()
+}
+
+func (db *PostgresDatabase) BlockURLs(ctx context.Context, blocked func(longURL models.LongURL) bool) (int, error) {
+	rows, err := db.conn.QueryContext(ctx, `SELECT id, origin_url, is_blocked FROM urls ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	changes := map[int64]bool{}
+
+	for rows.Next() {
+		var (
+			id        int64
+			longURL   models.LongURL
+			isBlocked bool
+		)
+
+		err = rows.Scan(&id, &longURL, &isBlocked)
+		if err != nil {
+			return 0, err
+		}
+
+		if blocked(longURL) != isBlocked {
+			changes[id] = !isBlocked
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	for id, isBlocked := range changes {
+		if _, err = tx.ExecContext(ctx, `UPDATE urls SET is_blocked=$2 WHERE id=$1`, id, isBlocked); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(changes), tx.Commit()
+}
+
+func (db *PostgresDatabase) SweepURLs(ctx context.Context, now time.Time, archive bool) (int, error) {
+	sqlSweepURLs := `WITH swept AS (
+						DELETE FROM urls
+						WHERE (expires_at IS NOT NULL AND expires_at <= $1) OR (max_clicks > 0 AND clicks >= max_clicks)
+						RETURNING short_url
+					 ), removed AS (
+						DELETE FROM url_clicks WHERE short_url IN (SELECT short_url FROM swept)
+					 )
+					 SELECT COUNT(*) FROM swept`
+
+	if archive {
+		sqlSweepURLs = `WITH swept AS (
+							UPDATE urls SET is_deleted=true
+							WHERE NOT is_deleted AND ((expires_at IS NOT NULL AND expires_at <= $1) OR (max_clicks > 0 AND clicks >= max_clicks))
+							RETURNING short_url
+						)
+						SELECT COUNT(*) FROM swept`
+	}
+
+	var swept int
+
+	err := db.conn.QueryRowContext(ctx, sqlSweepURLs, now).Scan(&swept)
+
+	return swept, err
+}
+
+func (db *PostgresDatabase) GetOwner(ctx context.Context, shortURL models.ShortURL) (models.UserID, error) {
+	var result models.UserID
+
+	err := db.conn.QueryRowContext(ctx, `SELECT user_id FROM urls WHERE short_url=$1`, shortURL).Scan(&result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", customerrors.NotFound("the short url not found")
+	}
+
+	return result, err
+}
+
+func (db *PostgresDatabase) GetShortURLs(ctx context.Context) ([]models.ShortURL, error) {
+	rows, err := db.conn.QueryContext(ctx, `SELECT short_url FROM urls`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var result []models.ShortURL
+
+	for rows.Next() {
+		var shortURL models.ShortURL
+
+		err = rows.Scan(&shortURL)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, shortURL)
+	}
+
+	return result, rows.Err()
 }
 
 func (db *PostgresDatabase) GetStates(ctx context.Context) (handlers.ResponseStates, error) {
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/repotest"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/jobs"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+)
+
+// TestPostgresDatabase_Conformance runs only when TEST_DATABASE_DSN points to a disposable database
+func TestPostgresDatabase_Conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	repotest.Run(t, func(t *testing.T, baseURL string) services.RepositoryInterface {
+		ctx := context.Background()
+
+		conn, err := Conn("postgres", dsn)
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		require.NoError(t, SetUpDataBase(ctx, conn))
+
+		_, err = conn.ExecContext(ctx, `TRUNCATE urls, url_clicks, delete_jobs;`)
+		require.NoError(t, err)
+
+		return NewDatabaseRepository(baseURL, conn)
+	})
+}
+
+// TestJobStore runs only when TEST_DATABASE_DSN points to a disposable database
+func TestJobStore(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+
+	conn, err := Conn("postgres", dsn)
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	require.NoError(t, SetUpDataBase(ctx, conn))
+
+	_, err = conn.ExecContext(ctx, `TRUNCATE jobs;`)
+	require.NoError(t, err)
+
+	store := NewJobStore(conn)
+	created := time.Now().UTC()
+
+	require.NoError(t, store.AddJob(ctx, jobs.Job{ID: "1", Kind: "kind", Payload: json.RawMessage(`{"id":1}`), CreatedAt: created}))
+	require.NoError(t, store.AddJob(ctx, jobs.Job{ID: "2", Kind: "kind", Payload: json.RawMessage(`{"id":2}`), RequestID: "req", CreatedAt: created.Add(time.Second)}))
+	require.NoError(t, store.DoneJob(ctx, "1"))
+	require.NoError(t, store.DoneJob(ctx, "unknown"))
+
+	pending, err := store.PendingJobs(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "2", pending[0].ID)
+	require.Equal(t, "req", pending[0].RequestID)
+	require.JSONEq(t, `{"id":2}`, string(pending[0].Payload))
+}
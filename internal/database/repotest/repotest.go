@@ -0,0 +1,576 @@
+// Package repotest provides a conformance test suite for the storage backends
+package repotest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+)
+
+// BaseURL - base address passed to the repository under test
+const BaseURL = "http://localhost:8080"
+
+// Factory returns an empty repository, every test case gets its own instance
+type Factory func(t *testing.T, baseURL string) services.RepositoryInterface
+
+// Run checks that the repository returned by factory behaves according to the services.RepositoryInterface contract
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo services.RepositoryInterface)
+	}{
+		{name: "AddURL and GetURL", test: testAddURL},
+		{name: "AddURL conflict", test: testAddURLConflict},
+		{name: "AddURL collision", test: testAddURLCollision},
+		{name: "GetURL not found", test: testGetURLNotFound},
+		{name: "GetShortURL", test: testGetShortURL},
+		{name: "AddURL same url", test: testAddURLSameURL},
+		{name: "AddURLs", test: testAddURLs},
+		{name: "AddURLs conflict", test: testAddURLsConflict},
+		{name: "AddURLs collision", test: testAddURLsCollision},
+		{name: "DeleteURLs", test: testDeleteURLs},
+		{name: "GetUserURLs", test: testGetUserURLs},
+		{name: "GetStates", test: testGetStates},
+		{name: "BlockURLs", test: testBlockURLs},
+		{name: "Expiration", test: testExpiration},
+		{name: "SweepURLs purge", test: testSweepURLsPurge},
+		{name: "SweepURLs archive", test: testSweepURLsArchive},
+		{name: "Clicks", test: testClicks},
+		{name: "GetOwner", test: testGetOwner},
+		{name: "GetShortURLs", test: testGetShortURLs},
+		{name: "APIKeys", test: testAPIKeys},
+		{name: "DeleteJobs", test: testDeleteJobs},
+		{name: "Ping", test: testPing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t, BaseURL))
+		})
+	}
+}
+
+func requireDBError(t *testing.T, err error, reason string) {
+	t.Helper()
+
+	var dbErr *customerrors.Error
+
+	require.Error(t, err)
+	require.True(t, errors.As(err, &dbErr), "unexpected error type: %v", err)
+	assert.Equal(t, reason, dbErr.Reason)
+}
+
+func testAddURL(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "short", "user", models.Expiration{}))
+
+	longURL, err := repo.GetURL(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", longURL)
+}
+
+func testAddURLConflict(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "short", "user", models.Expiration{}))
+
+	err := repo.AddURL(ctx, "https://go.dev", "short", "another", models.Expiration{})
+	requireDBError(t, err, "url_exists")
+}
+
+func testAddURLCollision(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "short", "user", models.Expiration{}))
+
+	err := repo.AddURL(ctx, "https://pkg.go.dev", "short", "user", models.Expiration{})
+	requireDBError(t, err, "collision")
+
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "short"))
+
+	err = repo.AddURL(ctx, "https://go.dev", "short", "user", models.Expiration{})
+	requireDBError(t, err, "collision")
+}
+
+func testGetURLNotFound(t *testing.T, repo services.RepositoryInterface) {
+	_, err := repo.GetURL(context.Background(), "missing")
+	requireDBError(t, err, "not_found")
+}
+
+func testGetShortURL(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	_, err := repo.GetShortURL(ctx, "https://go.dev")
+	requireDBError(t, err, "not_found")
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "second", "another", models.Expiration{}))
+
+	shortURL, err := repo.GetShortURL(ctx, "https://go.dev")
+	require.NoError(t, err)
+	assert.Equal(t, "first", shortURL)
+
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "first"))
+
+	shortURL, err = repo.GetShortURL(ctx, "https://go.dev")
+	require.NoError(t, err)
+	assert.Equal(t, "second", shortURL)
+}
+
+// testAddURLSameURL - the concurrent creates of the same url with the random or sequence ids may both be saved,
+// the backends keep both and GetShortURL returns the oldest one so the later creates agree on it
+func testAddURLSameURL(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "second", "user", models.Expiration{}))
+
+	for i := 0; i < 3; i++ {
+		shortURL, err := repo.GetShortURL(ctx, "https://go.dev")
+		require.NoError(t, err)
+		assert.Equal(t, "first", shortURL)
+	}
+
+	urls, err := repo.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	longURL, err := repo.GetURL(ctx, "second")
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", longURL)
+}
+
+func testAddURLs(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	urls, err := repo.AddURLs(ctx, "user",
+		handlers.RequestGetURLs{CorrelationID: "first", OriginalURL: "https://go.dev", ShortURL: "go"},
+		handlers.RequestGetURLs{CorrelationID: "second", OriginalURL: "https://pkg.go.dev", ShortURL: "pkg"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []handlers.ResponseGetURLs{
+		{CorrelationID: "first", ShortURL: BaseURL + "/go"},
+		{CorrelationID: "second", ShortURL: BaseURL + "/pkg"},
+	}, urls)
+
+	longURL, err := repo.GetURL(ctx, "go")
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", longURL)
+
+	longURL, err = repo.GetURL(ctx, "pkg")
+	require.NoError(t, err)
+	assert.Equal(t, "https://pkg.go.dev", longURL)
+}
+
+func testAddURLsConflict(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "go", "user", models.Expiration{}))
+
+	_, err := repo.AddURLs(ctx, "user",
+		handlers.RequestGetURLs{CorrelationID: "1", OriginalURL: "https://pkg.go.dev", ShortURL: "pkg"},
+		handlers.RequestGetURLs{CorrelationID: "2", OriginalURL: "https://go.dev", ShortURL: "go"},
+	)
+	requireDBError(t, err, "url_exists")
+
+	_, err = repo.GetURL(ctx, "pkg")
+	requireDBError(t, err, "not_found")
+}
+
+func testAddURLsCollision(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	_, err := repo.AddURLs(ctx, "user",
+		handlers.RequestGetURLs{CorrelationID: "1", OriginalURL: "https://pkg.go.dev", ShortURL: "short"},
+		handlers.RequestGetURLs{CorrelationID: "2", OriginalURL: "https://go.dev", ShortURL: "short"},
+	)
+	requireDBError(t, err, "collision")
+
+	_, err = repo.GetURL(ctx, "short")
+	requireDBError(t, err, "not_found")
+}
+
+func testDeleteURLs(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://pkg.go.dev", "second", "user", models.Expiration{}))
+
+	require.NoError(t, repo.DeleteURLs(ctx, "another", "first", "second"))
+
+	_, err := repo.GetURL(ctx, "first")
+	require.NoError(t, err, "only the owner can delete the url")
+
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "first", "missing"))
+
+	_, err = repo.GetURL(ctx, "first")
+	requireDBError(t, err, "deleted")
+
+	_, err = repo.GetURL(ctx, "second")
+	require.NoError(t, err)
+}
+
+func testGetUserURLs(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	urls, err := repo.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://pkg.go.dev", "second", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev/doc", "third", "another", models.Expiration{}))
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "second"))
+
+	urls, err = repo.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []handlers.ResponseGetURL{
+		{ShortURL: BaseURL + "/first", OriginalURL: "https://go.dev"},
+	}, urls)
+}
+
+func testGetStates(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	states, err := repo.GetStates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, handlers.ResponseStates{}, states)
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://pkg.go.dev", "second", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev/doc", "third", "another", models.Expiration{}))
+
+	states, err = repo.GetStates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, handlers.ResponseStates{Urls: 3, Users: 2}, states)
+}
+
+func testBlockURLs(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://evil.example/a", "second", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://evil.example/b", "third", "user", models.Expiration{}))
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "third"))
+
+	isEvil := func(longURL models.LongURL) bool {
+		return strings.HasPrefix(longURL, "https://evil.example/")
+	}
+
+	changed, err := repo.BlockURLs(ctx, isEvil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	_, err = repo.GetURL(ctx, "first")
+	require.NoError(t, err)
+
+	_, err = repo.GetURL(ctx, "second")
+	requireDBError(t, err, "blocked")
+
+	_, err = repo.GetURL(ctx, "third")
+	requireDBError(t, err, "deleted")
+
+	changed, err = repo.BlockURLs(ctx, isEvil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed, "the links are already blocked")
+
+	changed, err = repo.BlockURLs(ctx, func(longURL models.LongURL) bool { return false })
+	require.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	longURL, err := repo.GetURL(ctx, "second")
+	require.NoError(t, err)
+	assert.Equal(t, "https://evil.example/a", longURL)
+}
+
+func testExpiration(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+	now := time.Now()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "past", "user", models.Expiration{ExpiresAt: now.Add(-time.Hour)}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "future", "user", models.Expiration{ExpiresAt: now.Add(time.Hour)}))
+	_, err := repo.AddURLs(ctx, "user", handlers.RequestGetURLs{CorrelationID: "1", OriginalURL: "https://go.dev", ShortURL: "clicks", MaxClicks: 2})
+	require.NoError(t, err)
+
+	_, err = repo.GetURL(ctx, "past")
+	requireDBError(t, err, "expired")
+
+	_, err = repo.VisitURL(ctx, "past")
+	requireDBError(t, err, "expired")
+
+	longURL, err := repo.VisitURL(ctx, "future")
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", longURL)
+
+	for i := 0; i < 2; i++ {
+		_, err = repo.GetURL(ctx, "clicks")
+		require.NoError(t, err, "GetURL must not count the clicks")
+
+		_, err = repo.VisitURL(ctx, "clicks")
+		require.NoError(t, err)
+	}
+
+	_, err = repo.VisitURL(ctx, "clicks")
+	requireDBError(t, err, "expired")
+
+	_, err = repo.GetURL(ctx, "clicks")
+	requireDBError(t, err, "expired")
+
+	_, err = repo.VisitURL(ctx, "missing")
+	requireDBError(t, err, "not_found")
+
+	_, err = repo.GetShortURL(ctx, "https://go.dev")
+	requireDBError(t, err, "not_found")
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "forever", "user", models.Expiration{}))
+
+	shortURL, err := repo.GetShortURL(ctx, "https://go.dev")
+	require.NoError(t, err)
+	assert.Equal(t, "forever", shortURL)
+}
+
+// addExpired saves a link expired by time, a link out of clicks and a permanent link
+func addExpired(t *testing.T, repo services.RepositoryInterface) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "past", "user", models.Expiration{ExpiresAt: time.Now().Add(-time.Hour)}))
+	require.NoError(t, repo.AddURL(ctx, "https://pkg.go.dev", "clicks", "user", models.Expiration{MaxClicks: 1}))
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev/doc", "forever", "user", models.Expiration{}))
+
+	_, err := repo.VisitURL(ctx, "clicks")
+	require.NoError(t, err)
+}
+
+func testSweepURLsPurge(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	addExpired(t, repo)
+	require.NoError(t, repo.AddClicks(ctx, models.Click{ShortURL: "past", Time: time.Now().Add(-2 * time.Hour)}))
+
+	swept, err := repo.SweepURLs(ctx, time.Now(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, swept)
+
+	clicks, err := repo.GetClicks(ctx, "past")
+	require.NoError(t, err)
+	assert.Empty(t, clicks, "the clicks are purged with the link")
+
+	_, err = repo.GetURL(ctx, "past")
+	requireDBError(t, err, "not_found")
+
+	_, err = repo.GetURL(ctx, "clicks")
+	requireDBError(t, err, "not_found")
+
+	_, err = repo.GetURL(ctx, "forever")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev/blog", "past", "another", models.Expiration{}), "the purged identifier is free")
+
+	urls, err := repo.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []handlers.ResponseGetURL{
+		{ShortURL: BaseURL + "/forever", OriginalURL: "https://go.dev/doc"},
+	}, urls)
+}
+
+func testSweepURLsArchive(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	addExpired(t, repo)
+
+	swept, err := repo.SweepURLs(ctx, time.Now(), true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, swept)
+
+	_, err = repo.GetURL(ctx, "past")
+	requireDBError(t, err, "deleted")
+
+	_, err = repo.GetURL(ctx, "forever")
+	require.NoError(t, err)
+
+	swept, err = repo.SweepURLs(ctx, time.Now(), true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, swept, "the archived links are not swept again")
+
+	states, err := repo.GetStates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, states.Urls)
+}
+
+func testClicks(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+
+	clicks, err := repo.GetClicks(ctx, "first")
+	require.NoError(t, err)
+	assert.Empty(t, clicks)
+
+	require.NoError(t, repo.AddClicks(ctx,
+		models.Click{ShortURL: "first", Time: now.Add(time.Minute), Referrer: "https://pkg.go.dev", UserAgent: "curl/7.79.1", IP: "203.0.113.7"},
+		models.Click{ShortURL: "first", Time: now, Referrer: "https://go.dev/blog"},
+		models.Click{ShortURL: "second", Time: now},
+	))
+	require.NoError(t, repo.AddClicks(ctx))
+
+	clicks, err = repo.GetClicks(ctx, "first")
+	require.NoError(t, err)
+	require.Len(t, clicks, 2)
+
+	assert.True(t, now.Equal(clicks[0].Time), "the clicks must be ordered by time")
+	assert.Equal(t, "https://go.dev/blog", clicks[0].Referrer)
+
+	assert.Equal(t, "first", clicks[1].ShortURL)
+	assert.True(t, now.Add(time.Minute).Equal(clicks[1].Time))
+	assert.Equal(t, "https://pkg.go.dev", clicks[1].Referrer)
+	assert.Equal(t, "curl/7.79.1", clicks[1].UserAgent)
+	assert.Equal(t, "203.0.113.7", clicks[1].IP)
+}
+
+func testGetOwner(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	_, err := repo.GetOwner(ctx, "first")
+	requireDBError(t, err, "not_found")
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "first"))
+
+	owner, err := repo.GetOwner(ctx, "first")
+	require.NoError(t, err, "the owner of a deleted url is known")
+	assert.Equal(t, "user", owner)
+}
+
+func testGetShortURLs(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+
+	shortURLs, err := repo.GetShortURLs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, shortURLs)
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "first", "user", models.Expiration{}))
+	require.NoError(t, repo.AddURL(ctx, "https://pkg.go.dev", "second", "user", models.Expiration{}))
+	require.NoError(t, repo.DeleteURLs(ctx, "user", "first"))
+
+	shortURLs, err = repo.GetShortURLs(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []models.ShortURL{"first", "second"}, shortURLs, "the deleted urls keep their identifiers")
+}
+
+func testAPIKeys(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+	createdAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := repo.GetAPIKey(ctx, "hash")
+	requireDBError(t, err, "not_found")
+
+	first := models.APIKey{ID: "first", User: "user", Name: "ci", Hash: "hash", Scopes: []string{"links:read"}, CreatedAt: createdAt}
+	second := models.APIKey{ID: "second", User: "user", Hash: "other", Scopes: []string{"links:read", "stats:read"}, CreatedAt: createdAt.Add(time.Second)}
+
+	require.NoError(t, repo.AddAPIKey(ctx, first))
+	require.NoError(t, repo.AddAPIKey(ctx, second))
+	require.NoError(t, repo.AddAPIKey(ctx, models.APIKey{ID: "third", User: "other", Hash: "third", Scopes: []string{"links:read"}, CreatedAt: createdAt}))
+
+	key, err := repo.GetAPIKey(ctx, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, first, key)
+
+	usedAt := createdAt.Add(time.Minute)
+	require.NoError(t, repo.TouchAPIKey(ctx, "first", usedAt))
+
+	keys, err := repo.GetUserAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "first", keys[0].ID)
+	require.NotNil(t, keys[0].LastUsedAt)
+	assert.True(t, usedAt.Equal(*keys[0].LastUsedAt))
+	assert.Equal(t, []string{"links:read", "stats:read"}, keys[1].Scopes)
+
+	requireDBError(t, repo.DeleteAPIKey(ctx, "other", "first"), "not_found")
+	require.NoError(t, repo.DeleteAPIKey(ctx, "user", "first"))
+
+	_, err = repo.GetAPIKey(ctx, "hash")
+	requireDBError(t, err, "not_found")
+
+	requireDBError(t, repo.TouchAPIKey(ctx, "first", usedAt), "not_found")
+}
+
+func testDeleteJobs(t *testing.T, repo services.RepositoryInterface) {
+	ctx := context.Background()
+	createdAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, _, err := repo.GetDeleteJob(ctx, "first")
+	requireDBError(t, err, "not_found")
+
+	err = repo.UpdateDeleteJob(ctx, "first", func(job *handlers.ResponseDeleteJob) {})
+	requireDBError(t, err, "not_found")
+
+	first := handlers.ResponseDeleteJob{
+		ID:        "first",
+		Status:    handlers.DeleteJobPending,
+		URLs:      []handlers.DeleteURLResult{{ShortURL: "a", Outcome: handlers.DeletePending}, {ShortURL: "b", Outcome: handlers.DeletePending}},
+		CreatedAt: createdAt,
+	}
+
+	require.NoError(t, repo.AddDeleteJob(ctx, "user", first))
+	require.NoError(t, repo.AddDeleteJob(ctx, "user", handlers.ResponseDeleteJob{ID: "pending", Status: handlers.DeleteJobPending, CreatedAt: createdAt}))
+	require.NoError(t, repo.AddDeleteJob(ctx, "user", handlers.ResponseDeleteJob{ID: "recent", Status: handlers.DeleteJobPending, CreatedAt: createdAt.Add(2 * time.Hour)}))
+
+	completedAt := createdAt.Add(time.Minute)
+
+	require.NoError(t, repo.UpdateDeleteJob(ctx, "first", func(job *handlers.ResponseDeleteJob) {
+		job.URLs[0].Outcome = handlers.DeleteDeleted
+		job.URLs[1].Outcome = handlers.DeleteNotOwner
+		job.Status = handlers.DeleteJobDone
+		job.CompletedAt = &completedAt
+	}))
+
+	user, job, err := repo.GetDeleteJob(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, handlers.DeleteJobDone, job.Status)
+	assert.True(t, createdAt.Equal(job.CreatedAt))
+	require.NotNil(t, job.CompletedAt)
+	assert.True(t, completedAt.Equal(*job.CompletedAt))
+	assert.Equal(t, []handlers.DeleteURLResult{{ShortURL: "a", Outcome: handlers.DeleteDeleted}, {ShortURL: "b", Outcome: handlers.DeleteNotOwner}}, job.URLs)
+
+	job.URLs[0].Outcome = handlers.DeleteFailed
+
+	_, job, err = repo.GetDeleteJob(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, handlers.DeleteDeleted, job.URLs[0].Outcome, "the returned job must not share the stored urls")
+
+	purged, err := repo.PurgeDeleteJobs(ctx, completedAt.Add(time.Second), createdAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged, "the completed job and the stale pending one are purged")
+
+	_, _, err = repo.GetDeleteJob(ctx, "pending")
+	requireDBError(t, err, "not_found")
+
+	_, _, err = repo.GetDeleteJob(ctx, "recent")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.RemoveDeleteJob(ctx, "recent"))
+	require.NoError(t, repo.RemoveDeleteJob(ctx, "recent"), "removing an unknown job is not an error")
+
+	_, _, err = repo.GetDeleteJob(ctx, "recent")
+	requireDBError(t, err, "not_found")
+}
+
+func testPing(t *testing.T, repo services.RepositoryInterface) {
+	assert.NoError(t, repo.Ping(context.Background()))
+}
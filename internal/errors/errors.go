@@ -2,28 +2,187 @@
 package errors
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 )
 
-type CustomError struct {
-	Err        error
-	StatusCode int
-}
+// Kind classifies the failures the same way for the HTTP and gRPC APIs
+type Kind int
+
+const (
+	// KindInternal - an unexpected failure, the default for unclassified errors
+	KindInternal Kind = iota
+	// KindNotFound - the requested entity does not exist
+	KindNotFound
+	// KindGone - the entity existed but is deleted, expired or blocked
+	KindGone
+	// KindConflict - the entity already exists, e.g. the url or the alias
+	KindConflict
+	// KindForbidden - the entity belongs to another user or the caller is not trusted
+	KindForbidden
+	// KindInvalidInput - the request is malformed or a value is not allowed
+	KindInvalidInput
+	// KindUnavailable - the service can not take the request now, it may be retried later
+	KindUnavailable
+)
 
-func (err *CustomError) Error() string {
-	return fmt.Sprintf("%v", err.Err)
+// The reasons the callers tell apart within a kind
+const (
+	// ReasonNotFound - the default reason of KindNotFound
+	ReasonNotFound = "not_found"
+	// ReasonDeleted - the link is deleted by its owner
+	ReasonDeleted = "deleted"
+	// ReasonBlocked - the destination of the link is blocked by the policy
+	ReasonBlocked = "blocked"
+	// ReasonExpired - the link is expired by time or by the number of clicks
+	ReasonExpired = "expired"
+	// ReasonURLExists - the same url is already saved, the existing short url is returned with the error
+	ReasonURLExists = "url_exists"
+	// ReasonCollision - the short url is taken by another url
+	ReasonCollision = "collision"
+	// ReasonAliasTaken - the alias is taken by another url
+	ReasonAliasTaken = "alias_taken"
+	// ReasonNotOwner - the entity belongs to another user
+	ReasonNotOwner = "not_owner"
+	// ReasonUntrusted - the internal methods are called outside the trusted subnet
+	ReasonUntrusted = "untrusted_network"
+)
+
+var kindNames = map[Kind]string{
+	KindInternal:     "INTERNAL",
+	KindNotFound:     "NOT_FOUND",
+	KindGone:         "GONE",
+	KindConflict:     "CONFLICT",
+	KindForbidden:    "FORBIDDEN",
+	KindInvalidInput: "INVALID_INPUT",
+	KindUnavailable:  "UNAVAILABLE",
 }
 
-func (err *CustomError) Unwrap() error {
-	return err.Err
+// String returns the machine-readable name of the kind, e.g. "NOT_FOUND"
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+
+	return kindNames[KindInternal]
 }
 
-func ParseError(err error) int {
-	switch e := err.(type) {
-	case *CustomError:
-		return e.StatusCode
+// HTTPStatus returns the HTTP status code of the kind
+func (k Kind) HTTPStatus() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindGone:
+		return http.StatusGone
+	case KindConflict:
+		return http.StatusConflict
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindInvalidInput:
+		return http.StatusBadRequest
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
 	}
 }
+
+// Error is a failure of the domain produced by the repositories and the services,
+// its kind decides the HTTP status and the gRPC code
+type Error struct {
+	Kind Kind
+	// Reason - machine-readable cause within the kind, e.g. "deleted" or "scheme_not_allowed"
+	Reason string
+	// Field - name of the invalid field, only for KindInvalidInput
+	Field string
+	// Message - human-readable description
+	Message string
+	// Err - the underlying error, optional
+	Err error
+}
+
+func (err *Error) Error() string {
+	if err.Field != "" {
+		return err.Field + ": " + err.Message
+	}
+
+	return err.Message
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// New returns the error of the kind
+func New(kind Kind, reason, message string) error {
+	return &Error{
+		Kind:    kind,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// NotFound returns the KindNotFound error
+func NotFound(message string) error {
+	return New(KindNotFound, ReasonNotFound, message)
+}
+
+// Gone returns the KindGone error, the reason tells why the entity is not available anymore
+func Gone(reason, message string) error {
+	return New(KindGone, reason, message)
+}
+
+// Conflict returns the KindConflict error wrapping the cause, e.g. the error of the database, it may be nil
+func Conflict(reason, message string, cause error) error {
+	return &Error{
+		Kind:    KindConflict,
+		Reason:  reason,
+		Message: message,
+		Err:     cause,
+	}
+}
+
+// Forbidden returns the KindForbidden error
+func Forbidden(message string) error {
+	return New(KindForbidden, ReasonNotOwner, message)
+}
+
+// InvalidInput returns the KindInvalidInput error of the field
+func InvalidInput(field, reason, message string) error {
+	return &Error{
+		Kind:    KindInvalidInput,
+		Reason:  reason,
+		Field:   field,
+		Message: message,
+	}
+}
+
+// Unavailable returns the KindUnavailable error
+func Unavailable(reason, message string) error {
+	return New(KindUnavailable, reason, message)
+}
+
+// As returns the first Error in the chain
+func As(err error) (*Error, bool) {
+	var domainErr *Error
+
+	ok := errors.As(err, &domainErr)
+
+	return domainErr, ok
+}
+
+// KindOf returns the kind of the first Error in the chain, KindInternal if there is none
+func KindOf(err error) Kind {
+	if domainErr, ok := As(err); ok {
+		return domainErr.Kind
+	}
+
+	return KindInternal
+}
+
+// HasReason reports whether the first Error in the chain has the reason
+func HasReason(err error, reason string) bool {
+	domainErr, ok := As(err)
+
+	return ok && domainErr.Reason == reason
+}
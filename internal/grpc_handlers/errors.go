@@ -0,0 +1,85 @@
+package grpchandlers
+
+import (
+	"context"
+	stderrors "errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+// errorDomain - the domain of the ErrorInfo details
+const errorDomain = "shortener"
+
+// kindCodes maps the kinds of the domain errors to the gRPC codes
+var kindCodes = map[errors.Kind]codes.Code{
+	errors.KindNotFound:     codes.NotFound,
+	errors.KindGone:         codes.FailedPrecondition,
+	errors.KindConflict:     codes.AlreadyExists,
+	errors.KindForbidden:    codes.PermissionDenied,
+	errors.KindInvalidInput: codes.InvalidArgument,
+	errors.KindUnavailable:  codes.Unavailable,
+}
+
+// statusError converts the error of the service into the gRPC status with the ErrorInfo details of its kind,
+// the reason of the domain error and the metadata are added to them, e.g. the existing short url of a conflict.
+// The errors of a field also get the BadRequest details, the internal errors are logged and their messages
+// are not returned to the caller.
+func statusError(ctx context.Context, err error, metadata map[string]string) error {
+	if stderrors.Is(err, workers.ErrQueueFull) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	if stderrors.Is(err, workers.ErrDraining) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	kind := errors.KindOf(err)
+
+	code, ok := kindCodes[kind]
+	if !ok {
+		logger.FromContext(ctx).Error("unexpected error of the service", "error", err)
+
+		return status.Error(codes.Internal, "internal server error")
+	}
+
+	domainErr, _ := errors.As(err)
+
+	if domainErr.Reason != "" {
+		if metadata == nil {
+			metadata = make(map[string]string, 1)
+		}
+
+		metadata["reason"] = domainErr.Reason
+	}
+
+	st, detailsErr := status.New(code, err.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason:   kind.String(),
+		Domain:   errorDomain,
+		Metadata: metadata,
+	})
+	if detailsErr != nil {
+		return status.Error(code, err.Error())
+	}
+
+	if domainErr.Field != "" {
+		withViolation, detailsErr := st.WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{
+					Field:       domainErr.Field,
+					Description: domainErr.Reason + ": " + domainErr.Message,
+				},
+			},
+		})
+		if detailsErr == nil {
+			st = withViolation
+		}
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,180 @@
+package grpchandlers
+
+import (
+	"context"
+	stderrors "errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// LoggingInterceptor puts the request id and the logger carrying it into the context and logs the call.
+// The id is taken from the x-request-id metadata or generated, it is returned in the header metadata.
+func LoggingInterceptor(l *logger.Logger) grpc.UnaryServerInterceptor {
+	key := strings.ToLower(logger.RequestIDHeader)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		var requestID string
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(key); len(values) > 0 {
+				requestID = values[0]
+			}
+		}
+
+		requestID = logger.AcceptRequestID(requestID)
+		requestLogger := l.With("request_id", requestID)
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(key, requestID))
+
+		ctx = logger.NewContext(logger.WithRequestID(ctx, requestID), requestLogger)
+
+		resp, err := handler(ctx, req)
+
+		keyvals := []interface{}{
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start),
+		}
+
+		if err != nil {
+			keyvals = append(keyvals, "error", err)
+		}
+
+		requestLogger.Info("grpc request", keyvals...)
+
+		return resp, err
+	}
+}
+
+// AuthInterceptor puts the user of the "authorization: Bearer" metadata into the context, the handlers prefer it
+// to the user id of the request. An invalid token, or any token if verifier is nil, gets Unauthenticated.
+// The calls without the metadata pass as is.
+func AuthInterceptor(verifier *auth.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok || len(md.Get("authorization")) == 0 {
+			return handler(ctx, req)
+		}
+
+		token, ok := auth.BearerToken(md.Get("authorization")[0])
+		if !ok || verifier == nil {
+			return nil, status.Error(codes.Unauthenticated, "bearer token expected")
+		}
+
+		userID, err := verifier.Verify(token)
+		if err != nil {
+			logger.FromContext(ctx).Debug("bearer token refused", "error", err)
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		return handler(auth.NewContext(ctx, userID), req)
+	}
+}
+
+// methodScopes - the scopes required from the API keys by the methods acting for a user,
+// the methods not listed need neither a scope nor a user
+var methodScopes = map[string]string{
+	"/urls.URL/CreateShortURL": auth.ScopeLinksWrite,
+	"/urls.URL/CreateBatch":    auth.ScopeLinksWrite,
+	"/urls.URL/GetUserURLs":    auth.ScopeLinksRead,
+	"/urls.URL/DeleteBatch":    auth.ScopeLinksDelete,
+	"/urls.URL/GetDeleteJob":   auth.ScopeLinksRead,
+	"/urls.URL/GetURLStats":    auth.ScopeStatsRead,
+}
+
+// APIKeyInterceptor puts the user of the x-api-key metadata into the context like AuthInterceptor
+// and refuses the methods outside the scopes of the key with PermissionDenied. An unknown key gets Unauthenticated,
+// the calls without the metadata or authenticated by a bearer token pass as is.
+func APIKeyInterceptor(keys auth.APIKeyAuthenticator) grpc.UnaryServerInterceptor {
+	header := strings.ToLower(auth.APIKeyHeader)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok || len(md.Get(header)) == 0 {
+			return handler(ctx, req)
+		}
+
+		if _, ok := auth.FromContext(ctx); ok {
+			return handler(ctx, req)
+		}
+
+		key, err := keys.AuthenticateAPIKey(ctx, md.Get(header)[0])
+		if stderrors.Is(err, auth.ErrInvalidAPIKey) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		ctx = auth.WithScopes(auth.NewContext(ctx, key.User), key.Scopes)
+
+		if scope, ok := methodScopes[info.FullMethod]; ok && !auth.HasScope(ctx, scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "the API key lacks the %s scope", scope)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// UserTokenMetadata - the metadata key of the user token, the value of the user_id cookie of the HTTP API
+const UserTokenMetadata = "user-token"
+
+// UserInterceptor identifies the caller of the methods acting for a user by the user-token metadata sealed with the keyring,
+// the callers authenticated by a bearer token or an API key pass as is. A caller without a token gets a new user,
+// the new, renewed or re-sealed token is returned in the user-token header metadata. An invalid or expired token gets Unauthenticated.
+func UserInterceptor(keyring *encryptor.Keyring, ttl time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := methodScopes[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+
+		if _, ok := auth.FromContext(ctx); ok {
+			return handler(ctx, req)
+		}
+
+		now := time.Now()
+
+		var values []string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			values = md.Get(UserTokenMetadata)
+		}
+
+		userID := uuid.NewString()
+		issue := true
+
+		if len(values) > 0 {
+			token, stale, err := keyring.Open(values[0], now)
+			if err != nil {
+				return nil, status.Error(codes.Unauthenticated, "invalid user token: "+err.Error())
+			}
+
+			userID = token.UserID
+			issue = encryptor.NeedsRenewal(token, stale, now, ttl)
+		}
+
+		if issue {
+			value, err := keyring.Issue(userID, now, ttl)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			_ = grpc.SetHeader(ctx, metadata.Pairs(UserTokenMetadata, value))
+		}
+
+		return handler(auth.NewContext(ctx, userID), req)
+	}
+}
@@ -4,11 +4,19 @@ package grpchandlers
 import (
 	"context"
 	"net"
-	"net/http"
 	"strconv"
+	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/pb"
 )
 
@@ -23,46 +31,101 @@ type URLServer struct {
 	service handlers.URLServiceInterface
 }
 
+// requestUser returns the caller authenticated by the interceptors, the user id of the request is optional
+// and must match the caller
+func requestUser(ctx context.Context, userID models.UserID) (models.UserID, error) {
+	authenticated, ok := auth.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "the caller is not authenticated")
+	}
+
+	if userID != "" && userID != authenticated {
+		return "", status.Error(codes.PermissionDenied, "the user_id of the request does not match the caller")
+	}
+
+	return authenticated, nil
+}
+
 func (us *URLServer) RetrieveShortURL(ctx context.Context, in *pb.RetrieveShortURLRequest) (*pb.RetrieveShortURLResponse, error) {
 	longURL, err := us.service.GetURL(ctx, in.ShortUrlId)
 	if err != nil {
-		statusCode := errors.ParseError(err)
-		switch statusCode {
-		case http.StatusGone:
-			return &pb.RetrieveShortURLResponse{
-				Status: "gone",
-			}, nil
-		case http.StatusNotFound:
-			return &pb.RetrieveShortURLResponse{
-				Status: "not found",
-			}, nil
-		default:
-			return &pb.RetrieveShortURLResponse{
-				Status: "internal server error",
-			}, nil
-		}
+		return nil, statusError(ctx, err, nil)
 	}
 
+	us.service.RecordClick(click(ctx, in.ShortUrlId))
+
 	return &pb.RetrieveShortURLResponse{
 		RedirectUrl: longURL,
 		Status:      "ok",
 	}, nil
 }
 
+// click describes the redirect by the peer address and the request metadata
+func click(ctx context.Context, shortURL string) models.Click {
+	result := models.Click{
+		ShortURL: shortURL,
+		Time:     time.Now(),
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			result.IP = host
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			result.UserAgent = values[0]
+		}
+
+		if values := md.Get("referer"); len(values) > 0 {
+			result.Referrer = values[0]
+		}
+	}
+
+	return result
+}
+
+// expiresAt converts the optional timestamp
+func expiresAt(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	t := ts.AsTime()
+
+	return &t
+}
+
+// expiration converts the optional limits of the link
+func expiration(ts *timestamppb.Timestamp, maxClicks int32) models.Expiration {
+	result := models.Expiration{
+		MaxClicks: int(maxClicks),
+	}
+
+	if ts != nil {
+		result.ExpiresAt = ts.AsTime()
+	}
+
+	return result
+}
+
 func (us *URLServer) CreateShortURL(ctx context.Context, in *pb.CreateShortURLRequest) (*pb.CreateShortURLResponse, error) {
-	responseURL, err := us.service.CreateURL(ctx, in.OriginalId, in.UserId)
+	user, err := requestUser(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	responseURL, err := us.service.CreateURL(ctx, in.OriginalId, in.Alias, expiration(in.ExpiresAt, in.MaxClicks), user)
 	if err != nil {
-		statusCode := errors.ParseError(err)
-		switch statusCode {
-		case http.StatusConflict:
-			return &pb.CreateShortURLResponse{
-				Status: "conflict",
-			}, nil
-		default:
-			return &pb.CreateShortURLResponse{
-				Status: "internal server error",
-			}, nil
+		var metadata map[string]string
+
+		// the same url is already saved, the client gets its short url
+		if responseURL != "" {
+			metadata = map[string]string{"short_url": responseURL}
 		}
+
+		return nil, statusError(ctx, err, metadata)
 	}
 	return &pb.CreateShortURLResponse{
 		Status:      "ok",
@@ -71,25 +134,20 @@ func (us *URLServer) CreateShortURL(ctx context.Context, in *pb.CreateShortURLRe
 }
 
 func (us *URLServer) GetUserURLs(ctx context.Context, in *pb.GetUserURLsRequest) (*pb.GetUserURLsResponse, error) {
-	urls, err := us.service.GetUserURLs(ctx, in.UserId)
+	user, err := requestUser(ctx, in.UserId)
 	if err != nil {
-		statusCode := errors.ParseError(err)
-		switch statusCode {
-		case http.StatusNoContent:
-			return &pb.GetUserURLsResponse{
-				Status: "no content",
-			}, nil
-		default:
-			return &pb.GetUserURLsResponse{
-				Status: "internal server error",
-			}, nil
-		}
+		return nil, err
+	}
+
+	urls, err := us.service.GetUserURLs(ctx, user)
+	if err != nil {
+		return nil, statusError(ctx, err, nil)
 	}
 	var result []*pb.GetUserURLsResponse_URL
 	for i := 0; i < len(urls); i++ {
 		result = append(result, &pb.GetUserURLsResponse_URL{
-			OriginalUrl: urls[0].OriginalURL,
-			ShortUrl:    urls[0].ShortURL,
+			OriginalUrl: urls[i].OriginalURL,
+			ShortUrl:    urls[i].ShortURL,
 		})
 	}
 	return &pb.GetUserURLsResponse{
@@ -99,18 +157,24 @@ func (us *URLServer) GetUserURLs(ctx context.Context, in *pb.GetUserURLsRequest)
 }
 
 func (us *URLServer) CreateBatch(ctx context.Context, in *pb.CreateBatchRequest) (*pb.CreateBatchResponse, error) {
+	user, err := requestUser(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
 	var data []handlers.RequestGetURLs
 	for i := 0; i < len(in.Urls); i++ {
 		data = append(data, handlers.RequestGetURLs{
 			CorrelationID: strconv.Itoa(int(in.Urls[i].CorrelationId)),
 			OriginalURL:   in.Urls[i].OriginalUrl,
+			Alias:         in.Urls[i].Alias,
+			ExpiresAt:     expiresAt(in.Urls[i].ExpiresAt),
+			MaxClicks:     int(in.Urls[i].MaxClicks),
 		})
 	}
-	urls, err := us.service.CreateBatch(ctx, data, in.UserId)
+	urls, err := us.service.CreateBatch(ctx, data, user)
 	if err != nil {
-		return &pb.CreateBatchResponse{
-			Status: "internal server error",
-		}, nil
+		return nil, statusError(ctx, err, nil)
 	}
 	var response []*pb.CreateBatchResponse_URL
 	for i := 0; i < len(urls); i++ {
@@ -127,23 +191,63 @@ func (us *URLServer) CreateBatch(ctx context.Context, in *pb.CreateBatchRequest)
 }
 
 func (us *URLServer) DeleteBatch(ctx context.Context, in *pb.DeleteBatchRequest) (*pb.DeleteBatchResponse, error) {
-	us.service.DeleteBatch(in.Urls, in.UserId)
+	user, err := requestUser(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, err := us.service.DeleteBatch(ctx, in.Urls, user)
+	if err != nil {
+		return nil, statusError(ctx, err, nil)
+	}
 	return &pb.DeleteBatchResponse{
 		Status: "accepted",
+		JobId:  jobID,
 	}, nil
 }
 
+func (us *URLServer) GetDeleteJob(ctx context.Context, in *pb.GetDeleteJobRequest) (*pb.GetDeleteJobResponse, error) {
+	user, err := requestUser(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := us.service.GetDeleteJob(ctx, in.JobId, user)
+	if err != nil {
+		return nil, statusError(ctx, err, nil)
+	}
+
+	urls := make([]*pb.GetDeleteJobResponse_URL, 0, len(job.URLs))
+	for _, result := range job.URLs {
+		urls = append(urls, &pb.GetDeleteJobResponse_URL{
+			ShortUrl: result.ShortURL,
+			Outcome:  result.Outcome,
+		})
+	}
+
+	response := &pb.GetDeleteJobResponse{
+		Status:    "ok",
+		Id:        job.ID,
+		State:     job.Status,
+		Urls:      urls,
+		CreatedAt: timestamppb.New(job.CreatedAt),
+		Error:     job.Error,
+	}
+
+	if job.CompletedAt != nil {
+		response.CompletedAt = timestamppb.New(*job.CompletedAt)
+	}
+
+	return response, nil
+}
+
 func (us *URLServer) GetStates(ctx context.Context, in *pb.GetStatesRequest) (*pb.GetStatesResponse, error) {
 	hasPermission, response, err := us.service.GetStates(ctx, net.IP(in.IpAddress))
 	if !hasPermission {
-		return &pb.GetStatesResponse{
-			Status: "forbidden",
-		}, nil
+		return nil, statusError(ctx, errors.New(errors.KindForbidden, errors.ReasonUntrusted, "the address is not in the trusted subnet"), nil)
 	}
 	if err != nil {
-		return &pb.GetStatesResponse{
-			Status: "bad request",
-		}, nil
+		return nil, statusError(ctx, err, nil)
 	}
 	return &pb.GetStatesResponse{
 		Status: "ok",
@@ -151,3 +255,45 @@ func (us *URLServer) GetStates(ctx context.Context, in *pb.GetStatesRequest) (*p
 		Urls:   int32(response.Urls),
 	}, nil
 }
+
+func (us *URLServer) GetURLStats(ctx context.Context, in *pb.GetURLStatsRequest) (*pb.GetURLStatsResponse, error) {
+	user, err := requestUser(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := us.service.GetURLStats(ctx, in.ShortUrlId, user, in.Bucket)
+	if err != nil {
+		return nil, statusError(ctx, err, nil)
+	}
+
+	series := make([]*pb.GetURLStatsResponse_Bucket, 0, len(stats.Series))
+	for _, bucket := range stats.Series {
+		series = append(series, &pb.GetURLStatsResponse_Bucket{
+			Time:   timestamppb.New(bucket.Time),
+			Clicks: int32(bucket.Clicks),
+		})
+	}
+
+	return &pb.GetURLStatsResponse{
+		Status:        "ok",
+		TotalClicks:   int32(stats.TotalClicks),
+		UniqueClicks:  int32(stats.UniqueClicks),
+		Bucket:        stats.Bucket,
+		Series:        series,
+		TopReferrers:  counts(stats.TopReferrers),
+		TopUserAgents: counts(stats.TopUserAgents),
+	}, nil
+}
+
+// counts converts the top values of the statistics
+func counts(values []handlers.StatsCount) []*pb.GetURLStatsResponse_Count {
+	result := make([]*pb.GetURLStatsResponse_Count, 0, len(values))
+	for _, value := range values {
+		result = append(result, &pb.GetURLStatsResponse_Count{
+			Value:  value.Value,
+			Clicks: int32(value.Clicks),
+		})
+	}
+	return result
+}
@@ -0,0 +1,367 @@
+package grpchandlers
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/memory"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/pb"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+const baseURL = "http://localhost:8080"
+
+func newTestServer(t *testing.T) *URLServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	wp := workers.New(ctx, 1, 10)
+
+	go wp.Run(ctx)
+
+	t.Cleanup(wp.Stop)
+
+	_, subnet, err := net.ParseCIDR("127.0.0.1/24")
+	require.NoError(t, err)
+
+	generator := shortener.NewHashGenerator(shortener.DefaultLength, shortener.DefaultAlphabet)
+	validator := services.NewURLValidator([]string{"http", "https"}, 2048, true, false)
+
+	service := services.New(memory.NewMemoryRepository(baseURL), baseURL, wp, subnet, generator, validator, nil, nil, nil)
+
+	return NewGRPCHandler(service)
+}
+
+func TestURLServer_CreateAndRetrieve(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), "user")
+	srv := newTestServer(t)
+
+	created, err := srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{UserId: "user", OriginalId: "https://go.dev"})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", created.Status)
+
+	id := created.ResponseUrl[len(baseURL)+1:]
+
+	retrieved, err := srv.RetrieveShortURL(ctx, &pb.RetrieveShortURLRequest{ShortUrlId: id})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", retrieved.Status)
+	assert.Equal(t, "https://go.dev", retrieved.RedirectUrl)
+
+	_, err = srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{OriginalId: "https://go.dev"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	info := errorInfo(t, err)
+	assert.Equal(t, "CONFLICT", info.Reason)
+	assert.Equal(t, "shortener", info.Domain)
+	assert.Equal(t, map[string]string{"reason": "url_exists", "short_url": created.ResponseUrl}, info.Metadata, "the existing short url must be returned")
+
+	_, err = srv.RetrieveShortURL(ctx, &pb.RetrieveShortURLRequest{ShortUrlId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// errorInfo returns the ErrorInfo details of the status error
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+
+	require.Fail(t, "no ErrorInfo details", err)
+
+	return nil
+}
+
+func TestURLServer_BatchAndUserURLs(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), "user")
+	srv := newTestServer(t)
+
+	batch, err := srv.CreateBatch(ctx, &pb.CreateBatchRequest{
+		UserId: "user",
+		Urls: []*pb.CreateBatchRequest_URL{
+			{CorrelationId: 1, OriginalUrl: "https://go.dev"},
+			{CorrelationId: 2, OriginalUrl: "https://pkg.go.dev"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", batch.Status)
+	require.Len(t, batch.Urls, 2)
+	assert.Equal(t, int32(2), batch.Urls[1].CorrelationId)
+
+	urls, err := srv.GetUserURLs(ctx, &pb.GetUserURLsRequest{UserId: "user"})
+	require.NoError(t, err)
+	require.Len(t, urls.Urls, 2)
+	assert.Equal(t, "https://go.dev", urls.Urls[0].OriginalUrl)
+	assert.Equal(t, "https://pkg.go.dev", urls.Urls[1].OriginalUrl)
+}
+
+func TestURLServer_CreateAlias(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), "user")
+	srv := newTestServer(t)
+
+	created, err := srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{UserId: "user", OriginalId: "https://go.dev", Alias: "spring-sale"})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", created.Status)
+	assert.Equal(t, baseURL+"/spring-sale", created.ResponseUrl)
+
+	_, err = srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{UserId: "user", OriginalId: "https://pkg.go.dev", Alias: "spring-sale"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Equal(t, map[string]string{"reason": "alias_taken"}, errorInfo(t, err).Metadata, "the alias of another url is not returned")
+
+	_, err = srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{UserId: "user", OriginalId: "https://pkg.go.dev", Alias: "api"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestURLServer_InvalidURL(t *testing.T) {
+	srv := newTestServer(t)
+
+	_, err := srv.CreateShortURL(auth.NewContext(context.Background(), "user"), &pb.CreateShortURLRequest{OriginalId: "/relative/path"})
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "INVALID_INPUT", errorInfo(t, err).Reason)
+	require.Len(t, st.Details(), 2)
+
+	badRequest, ok := st.Details()[1].(*errdetails.BadRequest)
+	require.True(t, ok)
+	assert.Equal(t, "url", badRequest.FieldViolations[0].Field)
+}
+
+func TestURLServer_GetDeleteJob(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), "user")
+	srv := newTestServer(t)
+
+	created, err := srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{UserId: "user", OriginalId: "https://go.dev"})
+	require.NoError(t, err)
+
+	id := created.ResponseUrl[len(baseURL)+1:]
+
+	deleted, err := srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{UserId: "user", Urls: []string{id}})
+	require.NoError(t, err)
+	require.NotEmpty(t, deleted.JobId)
+
+	var job *pb.GetDeleteJobResponse
+
+	assert.Eventually(t, func() bool {
+		job, err = srv.GetDeleteJob(ctx, &pb.GetDeleteJobRequest{UserId: "user", JobId: deleted.JobId})
+		return err == nil && job.State == "done"
+	}, time.Second, 10*time.Millisecond)
+
+	require.Len(t, job.Urls, 1)
+	assert.Equal(t, "deleted", job.Urls[0].Outcome)
+	assert.NotNil(t, job.CompletedAt)
+
+	_, err = srv.GetDeleteJob(auth.NewContext(context.Background(), "another"), &pb.GetDeleteJobRequest{JobId: deleted.JobId})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = srv.RetrieveShortURL(ctx, &pb.RetrieveShortURLRequest{ShortUrlId: id})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "the deleted link must be reported")
+	assert.Equal(t, "GONE", errorInfo(t, err).Reason)
+	assert.Equal(t, "deleted", errorInfo(t, err).Metadata["reason"])
+}
+
+func TestURLServer_DeleteBatchQueueFull(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), "user")
+
+	// the pool is not running, so its single place is taken by the first task
+	wp := workers.New(ctx, 1, 1)
+	service := services.New(memory.NewMemoryRepository(baseURL), baseURL, wp, nil, shortener.NewHashGenerator(shortener.DefaultLength, shortener.DefaultAlphabet), nil, nil, nil, nil)
+	srv := NewGRPCHandler(service)
+
+	deleted, err := srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{UserId: "user", Urls: []string{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, "accepted", deleted.Status)
+
+	_, err = srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{UserId: "user", Urls: []string{"b"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestURLServer_GetURLStats(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), "user")
+	srv := newTestServer(t)
+
+	created, err := srv.CreateShortURL(ctx, &pb.CreateShortURLRequest{UserId: "user", OriginalId: "https://go.dev"})
+	require.NoError(t, err)
+
+	id := created.ResponseUrl[len(baseURL)+1:]
+
+	stats, err := srv.GetURLStats(ctx, &pb.GetURLStatsRequest{UserId: "user", ShortUrlId: id})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", stats.Status)
+	assert.Equal(t, "day", stats.Bucket)
+	assert.Equal(t, int32(0), stats.TotalClicks)
+
+	_, err = srv.GetURLStats(auth.NewContext(context.Background(), "another"), &pb.GetURLStatsRequest{ShortUrlId: id})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = srv.GetURLStats(ctx, &pb.GetURLStatsRequest{UserId: "user", ShortUrlId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = srv.GetURLStats(ctx, &pb.GetURLStatsRequest{UserId: "user", ShortUrlId: id, Bucket: "week"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLoggingInterceptor(t *testing.T) {
+	interceptor := LoggingInterceptor(logger.Nop())
+	info := &grpc.UnaryServerInfo{FullMethod: "/urls.URL/DeleteBatch"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "abc"))
+
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		assert.Equal(t, "abc", logger.RequestID(ctx))
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		assert.NotEmpty(t, logger.RequestID(ctx), "the request id must be generated")
+		return nil, nil
+	})
+	require.NoError(t, err)
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	secret := []byte(strings.Repeat("s", auth.MinSecretLength))
+
+	verifier, err := auth.NewVerifier([][]byte{secret}, nil, "", "")
+	require.NoError(t, err)
+
+	token, err := auth.SignHS256(secret, auth.Claims{Subject: "ci", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	srv := newTestServer(t)
+	interceptor := AuthInterceptor(verifier)
+	info := &grpc.UnaryServerInfo{FullMethod: "/urls.URL/GetUserURLs"}
+
+	call := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.GetUserURLs(ctx, req.(*pb.GetUserURLsRequest))
+		})
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+
+	_, err = srv.CreateShortURL(auth.NewContext(context.Background(), "ci"), &pb.CreateShortURLRequest{OriginalId: "https://go.dev"})
+	require.NoError(t, err)
+
+	resp, err := call(ctx, &pb.GetUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, resp.(*pb.GetUserURLsResponse).Urls, 1, "the user of the token must be used")
+
+	_, err = call(ctx, &pb.GetUserURLsRequest{UserId: "other"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "the user id of another user must be refused")
+
+	_, err = call(metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer x")), &pb.GetUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = call(context.Background(), &pb.GetUserURLsRequest{UserId: "other"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "the user id of the request is not trusted")
+}
+
+func TestAPIKeyInterceptor(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+
+	key, err := srv.service.CreateAPIKey(ctx, "user", "ci", []string{auth.ScopeLinksRead})
+	require.NoError(t, err)
+
+	interceptor := APIKeyInterceptor(srv.service)
+
+	call := func(method, value string) (models.UserID, error) {
+		var user models.UserID
+
+		ctx := metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", value))
+
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			user, _ = auth.FromContext(ctx)
+			return nil, nil
+		})
+
+		return user, err
+	}
+
+	user, err := call("/urls.URL/GetUserURLs", key.Key)
+	require.NoError(t, err)
+	assert.Equal(t, "user", user)
+
+	_, err = call("/urls.URL/DeleteBatch", key.Key)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = call("/urls.URL/GetUserURLs", "unknown")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUserInterceptor(t *testing.T) {
+	keyring, err := encryptor.NewKeyring(bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err)
+
+	srv := newTestServer(t)
+	lis := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(UserInterceptor(keyring, time.Hour)))
+	pb.RegisterURLServer(server, srv)
+
+	go server.Serve(lis)
+
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	client := pb.NewURLClient(conn)
+	ctx := context.Background()
+
+	var header metadata.MD
+
+	_, err = client.CreateShortURL(ctx, &pb.CreateShortURLRequest{OriginalId: "https://go.dev"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(UserTokenMetadata), 1, "a new user gets a token")
+
+	token := header.Get(UserTokenMetadata)[0]
+	withToken := metadata.AppendToOutgoingContext(ctx, UserTokenMetadata, token)
+
+	header = nil
+
+	urls, err := client.GetUserURLs(withToken, &pb.GetUserURLsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Len(t, urls.Urls, 1, "the token identifies the user")
+	assert.Empty(t, header.Get(UserTokenMetadata), "a fresh token is not renewed")
+
+	opened, _, err := keyring.Open(token, time.Now())
+	require.NoError(t, err)
+
+	_, err = client.GetUserURLs(withToken, &pb.GetUserURLsRequest{UserId: "another"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.GetUserURLs(withToken, &pb.GetUserURLsRequest{UserId: opened.UserID})
+	assert.NoError(t, err, "the matching user id is accepted")
+
+	_, err = client.GetUserURLs(metadata.AppendToOutgoingContext(ctx, UserTokenMetadata, "forged"), &pb.GetUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetUserURLs(ctx, &pb.GetUserURLsRequest{UserId: opened.UserID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "the user id of the request does not authenticate")
+}
@@ -38,6 +38,7 @@ func BenchmarkHandlers_RetrieveShortURL(b *testing.B) {
 
 		for i := 0; i < b.N; i++ {
 			repoMock.EXPECT().GetURL(gomock.Any(), "Vq7zU8E5b7sLZo3qY82UKYRvQ-A=").Return("https://go.dev", nil).AnyTimes()
+			repoMock.EXPECT().RecordClick(gomock.Any()).AnyTimes()
 
 			r.ServeHTTP(w, req)
 		}
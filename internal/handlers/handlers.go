@@ -5,15 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers/middlewares"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
 )
@@ -31,20 +32,38 @@ import (
 
 // URLServiceInterface contains the main methods of getting data from the storage
 type URLServiceInterface interface {
-	// CreateURL - saving a single url to the repository
-	CreateURL(ctx context.Context, longURL models.LongURL, user models.UserID) (string, error)
-	// GetURL - get a single long url by a short url
+	// CreateURL - saving a single url to the repository, the alias and the expiration are optional
+	CreateURL(ctx context.Context, longURL models.LongURL, alias string, expiration models.Expiration, user models.UserID) (string, error)
+	// GetURL - get a single long url by a short url counting the click
 	GetURL(ctx context.Context, shortURL models.ShortURL) (models.ShortURL, error)
+	// RecordClick - passing a redirect to the analytics without blocking
+	RecordClick(click models.Click)
 	// GetUserURLs - get a list urls
 	GetUserURLs(ctx context.Context, user models.UserID) ([]ResponseGetURL, error)
-	// DeleteBatch - deleting a bunch of URLs
-	DeleteBatch(urls []string, userID models.UserID)
+	// DeleteBatch - deleting a bunch of URLs in the background, ctx passes the request id to the workers, the id of the delete job is returned
+	DeleteBatch(ctx context.Context, urls []string, userID models.UserID) (string, error)
+	// GetDeleteJob - get the state of a delete job of the user
+	GetDeleteJob(ctx context.Context, id string, userID models.UserID) (ResponseDeleteJob, error)
 	// Ping - method for checking the operation of the storage
 	Ping(ctx context.Context) error
 	// CreateBatch - adding a bunch of URLs
 	CreateBatch(ctx context.Context, urls []RequestGetURLs, userID models.UserID) ([]ResponseGetURLs, error)
 	// GetStates - get a state about count of urls and users
 	GetStates(ctx context.Context, ip net.IP) (bool, ResponseStates, error)
+	// GetDeadLetters - get the background tasks failed after all the attempts
+	GetDeadLetters(ctx context.Context, ip net.IP) (bool, []workers.DeadLetter)
+	// ResizeWorkers - change the number of the background workers at runtime
+	ResizeWorkers(ctx context.Context, ip net.IP, workers int) (bool, ResponseWorkers, error)
+	// GetURLStats - get the click statistics of a url owned by the user
+	GetURLStats(ctx context.Context, shortURL models.ShortURL, user models.UserID, bucket string) (ResponseStats, error)
+	// CreateAPIKey - mint a key of the user with the scopes
+	CreateAPIKey(ctx context.Context, user models.UserID, name string, scopes []string) (ResponseAPIKey, error)
+	// GetAPIKeys - get the keys of the user without the secrets
+	GetAPIKeys(ctx context.Context, user models.UserID) ([]ResponseAPIKey, error)
+	// DeleteAPIKey - revoke a key of the user
+	DeleteAPIKey(ctx context.Context, user models.UserID, id string) error
+	// AuthenticateAPIKey - get the key by its secret recording the use
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
 }
 
 type Handlers struct {
@@ -55,6 +74,17 @@ type Handlers struct {
 
 type URL struct {
 	URL string `json:"url"`
+	// Alias - optional custom short url identifier
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt - optional moment the link stops working
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxClicks - optional number of redirects allowed
+	MaxClicks int `json:"max_clicks,omitempty"`
+}
+
+// Expiration returns the requested lifetime of the link
+func (u URL) Expiration() models.Expiration {
+	return newExpiration(u.ExpiresAt, u.MaxClicks)
 }
 
 type ResponseGetURL struct {
@@ -65,6 +95,31 @@ type ResponseGetURL struct {
 type RequestGetURLs struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	// Alias - optional custom short url identifier
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt - optional moment the link stops working
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxClicks - optional number of redirects allowed
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// ShortURL - identifier assigned by the service before saving
+	ShortURL string `json:"-"`
+}
+
+// Expiration returns the requested lifetime of the link
+func (u RequestGetURLs) Expiration() models.Expiration {
+	return newExpiration(u.ExpiresAt, u.MaxClicks)
+}
+
+func newExpiration(expiresAt *time.Time, maxClicks int) models.Expiration {
+	expiration := models.Expiration{
+		MaxClicks: maxClicks,
+	}
+
+	if expiresAt != nil {
+		expiration.ExpiresAt = *expiresAt
+	}
+
+	return expiration
 }
 
 type ResponseGetURLs struct {
@@ -77,26 +132,100 @@ type ResponseStates struct {
 	Users int `json:"users"`
 }
 
-type ErrorWithDB struct {
-	Err   error
-	Title string
+// ResponseDeleteBatch - the id of the accepted delete job
+type ResponseDeleteBatch struct {
+	JobID string `json:"job_id"`
 }
 
-func (err *ErrorWithDB) Error() string {
-	return fmt.Sprintf("%v", err.Err)
+// States of a delete job.
+const (
+	DeleteJobPending = "pending"
+	DeleteJobDone    = "done"
+	DeleteJobFailed  = "failed"
+)
+
+// Outcomes of a url of a delete job.
+const (
+	DeletePending  = "pending"
+	DeleteDeleted  = "deleted"
+	DeleteNotOwner = "not_owner"
+	DeleteNotFound = "not_found"
+	DeleteFailed   = "failed"
+)
+
+// ResponseDeleteJob - the state of a delete job
+type ResponseDeleteJob struct {
+	ID     string            `json:"id"`
+	Status string            `json:"status"`
+	URLs   []DeleteURLResult `json:"urls"`
+	// CreatedAt - the moment the delete was accepted
+	CreatedAt time.Time `json:"created_at"`
+	// CompletedAt - the moment the last url got its outcome
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Error - the last error of the job, the failed urls may be retried
+	Error string `json:"error,omitempty"`
 }
 
-func (err *ErrorWithDB) Unwrap() error {
-	return err.Err
+// DeleteURLResult - the outcome of a url of a delete job
+type DeleteURLResult struct {
+	ShortURL string `json:"short_url"`
+	Outcome  string `json:"outcome"`
 }
 
-func NewErrorWithDB(err error, title string) error {
-	return &ErrorWithDB{
-		Err:   err,
-		Title: title,
-	}
+// RequestWorkers - the new size of the worker pool
+type RequestWorkers struct {
+	Workers int `json:"workers"`
+}
+
+// ResponseWorkers - the state of the worker pool
+type ResponseWorkers struct {
+	Workers  int   `json:"workers"`
+	Queued   int   `json:"queued"`
+	InFlight int64 `json:"in_flight"`
 }
 
+// RequestAPIKey - the key to mint
+type RequestAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// ResponseAPIKey - an API key of the user, the secret is returned only when the key is minted
+type ResponseAPIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ResponseStats - click statistics of a single short url
+type ResponseStats struct {
+	ShortURL      string        `json:"short_url"`
+	TotalClicks   int           `json:"total_clicks"`
+	UniqueClicks  int           `json:"unique_clicks"`
+	Bucket        string        `json:"bucket"`
+	Series        []StatsBucket `json:"series"`
+	TopReferrers  []StatsCount  `json:"top_referrers"`
+	TopUserAgents []StatsCount  `json:"top_user_agents"`
+}
+
+// StatsBucket - number of clicks starting at the time within a single bucket
+type StatsBucket struct {
+	Time   time.Time `json:"time"`
+	Clicks int       `json:"clicks"`
+}
+
+// StatsCount - number of clicks sharing the value
+type StatsCount struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+// retryAfter - seconds the clients wait before retrying the requests refused by the busy worker pool
+const retryAfter = "1"
+
 // New is the handlers constructor
 func New(service URLServiceInterface, baseURL string, wp *workers.WorkerPool) *Handlers {
 	return &Handlers{
@@ -114,35 +243,45 @@ func New(service URLServiceInterface, baseURL string, wp *workers.WorkerPool) *H
 // @Produce json
 // @Param id path string true "ShortURL"
 // @Success 307 {string} string RetrieveShortURLResponse
-// @Failure 400 {string} string "the parameter is missing"
-// @Failure 410 {string} string "the parameter was deleted"
-// @Failure 404 {string} string "the parameter not found"
+// @Failure 400 {object} Problem "the parameter is missing"
+// @Failure 410 {object} Problem "the parameter was deleted, expired or its destination is blocked"
+// @Failure 404 {object} Problem "the parameter not found"
 // @Router /{id} [get]
 func (h *Handlers) RetrieveShortURL(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		http.Error(w, "the parameter is missing", http.StatusBadRequest)
+		writeError(w, r, customerrors.InvalidInput("id", "empty", "the parameter is missing"))
 		return
 	}
 
 	url, err := h.service.GetURL(r.Context(), id)
 	if err != nil {
-		var dbErr *ErrorWithDB
-
-		if errors.As(err, &dbErr) && dbErr.Title == "deleted" {
-			w.WriteHeader(http.StatusGone)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
+	h.service.RecordClick(models.Click{
+		ShortURL:  id,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+
 	w.Header().Add("Location", url)
 
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
+// clientIP returns the address resolved by ClientIPMiddleware or the remote address
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(middlewares.ClientIPCtxName).(string); ok {
+		return ip
+	}
+
+	return middlewares.ClientIP(r, nil)
+}
+
 // CreateShortURL godoc
 // @Summary method to save a single url
 // @Description method to get a single long url by a short url
@@ -151,8 +290,8 @@ func (h *Handlers) RetrieveShortURL(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param url_data body string true "Contains a string with an url"
 // @Success 201 {string} string "short url"
-// @Failure 400 {string} string "the body cannot be an empty"
-// @Failure 409 {string} string "the same URL already exists"
+// @Failure 400 {object} Problem "the url is invalid"
+// @Failure 409 {object} Problem "the same URL already exists"
 // @Failure 500 {string} string "unexpected error when writing the response body"
 // @Router / [post]
 func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
@@ -166,7 +305,7 @@ func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(body) == 0 {
-		http.Error(w, "the body cannot be an empty", http.StatusBadRequest)
+		writeError(w, r, customerrors.InvalidInput("body", "empty", "the body cannot be an empty"))
 		return
 	}
 
@@ -180,11 +319,9 @@ func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
 
 	longURL := models.LongURL(body)
 
-	shortURL, err := h.service.CreateURL(r.Context(), longURL, userID)
+	shortURL, err := h.service.CreateURL(r.Context(), longURL, "", models.Expiration{}, userID)
 	if err != nil {
-		var dbErr *ErrorWithDB
-
-		if errors.As(err, &dbErr) && dbErr.Title == "UniqConstraint" {
+		if customerrors.HasReason(err, customerrors.ReasonURLExists) {
 			w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 
 			w.WriteHeader(http.StatusConflict)
@@ -197,7 +334,7 @@ func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -216,10 +353,10 @@ func (h *Handlers) CreateShortURL(w http.ResponseWriter, r *http.Request) {
 // @ID shortenURL
 // @Accept  json
 // @Produce json
-// @Param url_data body map[string]string{} true "Contains a JSON with an url"
+// @Param url_data body URL true "Contains a JSON with an url and an optional alias"
 // @Success 201 {string} string "short url"
-// @Failure 400 {string} string "the URL property is missing"
-// @Failure 409 {string} string "the same URL already exists"
+// @Failure 400 {object} Problem "the URL property is missing, the url or the alias is invalid"
+// @Failure 409 {object} Problem "the same URL already exists or the alias is taken"
 // @Failure 500 {string} string "an unexpected error when unmarshaling JSON"
 // @Router /api/shorten [post]
 func (h *Handlers) ShortenURL(w http.ResponseWriter, r *http.Request) {
@@ -237,12 +374,12 @@ func (h *Handlers) ShortenURL(w http.ResponseWriter, r *http.Request) {
 
 	err := json.Unmarshal(body, &url)
 	if err != nil {
-		http.Error(w, "an unexpected error when unmarshaling JSON", http.StatusInternalServerError)
+		writeError(w, r, malformedBody(err))
 		return
 	}
 
 	if url.URL == "" {
-		http.Error(w, "the URL property is missing", http.StatusBadRequest)
+		writeError(w, r, customerrors.InvalidInput("url", "empty", "the URL property is missing"))
 		return
 	}
 
@@ -254,10 +391,9 @@ func (h *Handlers) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		userID = userIDCtx.(string)
 	}
 
-	shortURL, err := h.service.CreateURL(r.Context(), url.URL, userID)
+	shortURL, err := h.service.CreateURL(r.Context(), url.URL, url.Alias, url.Expiration(), userID)
 	if err != nil {
-		var dbErr *ErrorWithDB
-		if errors.As(err, &dbErr) && dbErr.Title == "UniqConstraint" {
+		if customerrors.HasReason(err, customerrors.ReasonURLExists) {
 			result["result"] = shortURL
 
 			w.Header().Add("Content-Type", "application/json; charset=utf-8")
@@ -278,7 +414,7 @@ func (h *Handlers) ShortenURL(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -321,7 +457,7 @@ func (h *Handlers) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 
 	urls, err := h.service.GetUserURLs(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -344,6 +480,51 @@ func (h *Handlers) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetURLStats godoc
+// @Summary method to get click statistics of a url
+// @Description method to get click statistics of a url owned by the user
+// @ID getURLStats
+// @Produce json
+// @Param id path string true "Short url"
+// @Param bucket query string false "Series bucket: hour or day"
+// @Success 200 {object} ResponseStats
+// @Failure 400 {object} Problem "invalid bucket"
+// @Failure 403 {object} Problem "the url belongs to another user"
+// @Failure 404 {object} Problem "not found"
+// @Failure 500 {string} string "500 Internal Server Error"
+// @Router /api/user/urls/{id}/stats [get]
+func (h *Handlers) GetURLStats(w http.ResponseWriter, r *http.Request) {
+	userIDCtx := r.Context().Value(middlewares.UserIDCtxName)
+
+	userID := "default"
+
+	if userIDCtx != nil {
+		userID = userIDCtx.(string)
+	}
+
+	stats, err := h.service.GetURLStats(r.Context(), chi.URLParam(r, "id"), userID, r.URL.Query().Get("bucket"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // DeleteBatch godoc
 // @Summary
 // @Description
@@ -351,8 +532,9 @@ func (h *Handlers) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 // @Accept  json
 // @Produce json
 // @Param url_data body []string true "Contains urls"
-// @Success 202
+// @Success 202 {object} ResponseDeleteBatch
 // @Failure 500 {string} string "500 Internal Server Error"
+// @Failure 503 {object} Problem "the worker pool is busy, retry after the Retry-After seconds"
 // @Router /api/user/urls [delete]
 func (h *Handlers) DeleteBatch(w http.ResponseWriter, r *http.Request) {
 	userIDCtx := r.Context().Value(middlewares.UserIDCtxName)
@@ -374,14 +556,74 @@ func (h *Handlers) DeleteBatch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = json.Unmarshal(body, &data)
+	if err != nil {
+		writeError(w, r, malformedBody(err))
+		return
+	}
+
+	jobID, err := h.service.DeleteBatch(r.Context(), data, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err = json.Marshal(ResponseDeleteBatch{JobID: jobID})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.service.DeleteBatch(data, userID)
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/api/user/jobs/"+jobID)
 
 	w.WriteHeader(http.StatusAccepted)
+
+	_, err = w.Write(body)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("unexpected error when writing the response body", "error", err)
+	}
+}
+
+// GetDeleteJob godoc
+// @Summary
+// @Description the state of a delete job with the outcome of every url
+// @ID getDeleteJob
+// @Produce json
+// @Param id path string true "Job id"
+// @Success 200 {object} ResponseDeleteJob
+// @Failure 404 {object} Problem "the job not found"
+// @Failure 500 {string} string "500 Internal Server Error"
+// @Router /api/user/jobs/{id} [get]
+func (h *Handlers) GetDeleteJob(w http.ResponseWriter, r *http.Request) {
+	userIDCtx := r.Context().Value(middlewares.UserIDCtxName)
+
+	userID := "default"
+
+	if userIDCtx != nil {
+		userID = userIDCtx.(string)
+	}
+
+	job, err := h.service.GetDeleteJob(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 // CreateBatch godoc
@@ -392,6 +634,8 @@ func (h *Handlers) DeleteBatch(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param url_data body []RequestGetURLs true "Contains urls"
 // @Success 201 {array} ResponseGetURLs
+// @Failure 400 {object} Problem "the url or the alias is invalid"
+// @Failure 409 {object} Problem "the alias is taken"
 // @Failure 500 {string} string "500 Internal Server Error"
 func (h *Handlers) CreateBatch(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
@@ -414,14 +658,13 @@ func (h *Handlers) CreateBatch(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, malformedBody(err))
 		return
 	}
 
 	urls, err := h.service.CreateBatch(r.Context(), data, userID)
 	if err != nil {
-		log.Println("err.Error(): ", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -453,12 +696,12 @@ func (h *Handlers) CreateBatch(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) GetStates(w http.ResponseWriter, r *http.Request) {
 	hasPermission, states, err := h.service.GetStates(r.Context(), net.ParseIP(r.Header.Get("X-Real-IP")))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	if !hasPermission {
-		w.WriteHeader(http.StatusForbidden)
+		writeError(w, r, errUntrusted)
 		return
 	}
 
@@ -476,6 +719,230 @@ func (h *Handlers) GetStates(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetDeadLetters godoc
+// @Summary
+// @Description the background tasks failed after all the attempts, the oldest first
+// @ID getDeadLetters
+// @Produce json
+// @Success 200 {array} workers.DeadLetter
+// @Failure 403 {object} Problem "403 Forbidden"
+func (h *Handlers) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	hasPermission, letters := h.service.GetDeadLetters(r.Context(), net.ParseIP(clientIP(r)))
+	if !hasPermission {
+		writeError(w, r, errUntrusted)
+		return
+	}
+
+	if letters == nil {
+		letters = []workers.DeadLetter{}
+	}
+
+	body, err := json.Marshal(letters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ResizeWorkers godoc
+// @Summary
+// @Description change the number of the background workers, the removed workers finish their current tasks
+// @ID resizeWorkers
+// @Accept  json
+// @Produce json
+// @Param workers body RequestWorkers true "The new number of workers"
+// @Success 200 {object} ResponseWorkers
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem "403 Forbidden"
+// @Failure 500 {string} string "500 Internal Server Error"
+// @Router /api/internal/workers [put]
+func (h *Handlers) ResizeWorkers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var data RequestWorkers
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		writeError(w, r, malformedBody(err))
+		return
+	}
+
+	hasPermission, state, err := h.service.ResizeWorkers(r.Context(), net.ParseIP(clientIP(r)), data.Workers)
+	if !hasPermission {
+		writeError(w, r, errUntrusted)
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err = json.Marshal(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateAPIKey godoc
+// @Summary
+// @Description mint an API key with the scopes, the secret is returned only once
+// @ID createAPIKey
+// @Accept  json
+// @Produce json
+// @Param key body RequestAPIKey true "The name and the scopes of the key"
+// @Success 201 {object} ResponseAPIKey
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem "the API keys can not manage the keys"
+// @Failure 500 {string} string "500 Internal Server Error"
+// @Router /api/user/keys [post]
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userIDCtx := r.Context().Value(middlewares.UserIDCtxName)
+
+	userID := "default"
+
+	if userIDCtx != nil {
+		userID = userIDCtx.(string)
+	}
+
+	defer r.Body.Close()
+
+	var data RequestAPIKey
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		writeError(w, r, malformedBody(err))
+		return
+	}
+
+	key, err := h.service.CreateAPIKey(r.Context(), userID, data.Name, data.Scopes)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err = json.Marshal(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	w.WriteHeader(http.StatusCreated)
+
+	_, err = w.Write(body)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("unexpected error when writing the response body", "error", err)
+	}
+}
+
+// GetAPIKeys godoc
+// @Summary
+// @Description the API keys of the user without the secrets
+// @ID getAPIKeys
+// @Produce json
+// @Success 200 {array} ResponseAPIKey
+// @Failure 403 {object} Problem "the API keys can not manage the keys"
+// @Failure 500 {string} string "500 Internal Server Error"
+// @Router /api/user/keys [get]
+func (h *Handlers) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userIDCtx := r.Context().Value(middlewares.UserIDCtxName)
+
+	userID := "default"
+
+	if userIDCtx != nil {
+		userID = userIDCtx.(string)
+	}
+
+	keys, err := h.service.GetAPIKeys(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := json.Marshal(keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("unexpected error when writing the response body", "error", err)
+	}
+}
+
+// DeleteAPIKey godoc
+// @Summary
+// @Description revoke an API key of the user
+// @ID deleteAPIKey
+// @Param id path string true "Key id"
+// @Success 204
+// @Failure 403 {object} Problem "the API keys can not manage the keys"
+// @Failure 404 {object} Problem "the key not found"
+// @Failure 500 {string} string "500 Internal Server Error"
+// @Router /api/user/keys/{id} [delete]
+func (h *Handlers) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	userIDCtx := r.Context().Value(middlewares.UserIDCtxName)
+
+	userID := "default"
+
+	if userIDCtx != nil {
+		userID = userIDCtx.(string)
+	}
+
+	err := h.service.DeleteAPIKey(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AuthenticateAPIKey passes the API keys of the requests to the service for middlewares.APIKeyMiddleware
+func (h *Handlers) AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	return h.service.AuthenticateAPIKey(ctx, key)
+}
+
 func (h *Handlers) PingDB(w http.ResponseWriter, r *http.Request) {
 	err := h.service.Ping(r.Context())
 	if err != nil {
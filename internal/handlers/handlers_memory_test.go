@@ -0,0 +1,258 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/analytics"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/configs"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/memory"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers/middlewares"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/router"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+func serve(t *testing.T, h http.Handler, method, target, body string) (int, string) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), middlewares.UserIDCtxName, "userID")))
+
+	response := w.Result()
+
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	return response.StatusCode, string(data)
+}
+
+func TestHandlers_MemoryRepository(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := configs.New()
+
+	wp := workers.New(ctx, cfg.Workers, cfg.WorkersBuffer)
+
+	go wp.Run(ctx)
+
+	defer wp.Stop()
+
+	_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
+	require.NoError(t, err)
+
+	generator := shortener.NewHashGenerator(cfg.ShortIDLength, cfg.ShortIDAlphabet)
+	validator := services.NewURLValidator(cfg.AllowedSchemes, cfg.MaxURLLength, cfg.StripPort, cfg.StripFragment)
+
+	repo := memory.NewMemoryRepository(cfg.BaseURL)
+	collector := analytics.New(repo, wp, 1, time.Hour, 10)
+
+	go collector.Run(ctx)
+
+	service := services.New(repo, cfg.BaseURL, wp, subnet, generator, validator, nil, collector, nil)
+	r := router.New(handlers.New(service, cfg.BaseURL, wp), cfg, nil, nil)
+
+	code, shortURL := serve(t, r, http.MethodPost, "/", "https://go.dev")
+	require.Equal(t, http.StatusCreated, code)
+
+	code, conflictURL := serve(t, r, http.MethodPost, "/", "https://go.dev")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, shortURL, conflictURL)
+
+	id := strings.TrimPrefix(shortURL, cfg.BaseURL)
+
+	code, _ = serve(t, r, http.MethodGet, id, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, code)
+
+	assert.Eventually(t, func() bool {
+		clicks, err := repo.GetClicks(ctx, strings.TrimPrefix(id, "/"))
+		return err == nil && len(clicks) == 1 && clicks[0].IP == "192.0.2.1"
+	}, time.Second, 10*time.Millisecond, "the redirect must be recorded")
+
+	code, body := serve(t, r, http.MethodGet, "/api/user/urls"+id+"/stats?bucket=hour", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	var stats handlers.ResponseStats
+
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	assert.Equal(t, 1, stats.TotalClicks)
+	assert.Equal(t, 1, stats.UniqueClicks)
+	assert.Equal(t, "hour", stats.Bucket)
+	assert.Len(t, stats.Series, 1)
+
+	code, _ = serve(t, r, http.MethodGet, "/api/user/urls/missing/stats", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body = serve(t, r, http.MethodDelete, "/api/user/urls", `["`+strings.TrimPrefix(id, "/")+`","missing"]`)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	var accepted handlers.ResponseDeleteBatch
+
+	require.NoError(t, json.Unmarshal([]byte(body), &accepted))
+
+	assert.Eventually(t, func() bool {
+		code, _ = serve(t, r, http.MethodGet, id, "")
+		return code == http.StatusGone
+	}, time.Second, 10*time.Millisecond)
+
+	var job handlers.ResponseDeleteJob
+
+	assert.Eventually(t, func() bool {
+		code, body = serve(t, r, http.MethodGet, "/api/user/jobs/"+accepted.JobID, "")
+		return code == http.StatusOK && json.Unmarshal([]byte(body), &job) == nil && job.Status == handlers.DeleteJobDone
+	}, time.Second, 10*time.Millisecond, "the delete job must be finished")
+
+	assert.Equal(t, []handlers.DeleteURLResult{
+		{ShortURL: strings.TrimPrefix(id, "/"), Outcome: handlers.DeleteDeleted},
+		{ShortURL: "missing", Outcome: handlers.DeleteNotFound},
+	}, job.URLs)
+
+	code, body = serve(t, r, http.MethodPost, "/api/shorten", `{"url":"javascript:alert(1)"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.JSONEq(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"the scheme \"javascript\" is not allowed","instance":"/api/shorten","kind":"INVALID_INPUT","reason":"scheme_not_allowed","field":"url"}`, body)
+}
+
+func TestHandlers_Backpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := configs.New()
+
+	// the pool is not running yet, so its single place is taken by the first task
+	wp := workers.New(ctx, 1, 1)
+
+	defer wp.Stop()
+
+	_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
+	require.NoError(t, err)
+
+	generator := shortener.NewHashGenerator(cfg.ShortIDLength, cfg.ShortIDAlphabet)
+	repo := memory.NewMemoryRepository(cfg.BaseURL)
+	service := services.New(repo, cfg.BaseURL, wp, subnet, generator, nil, nil, nil, nil)
+	r := router.New(handlers.New(service, cfg.BaseURL, wp), cfg, nil, nil)
+
+	code, _ := serve(t, r, http.MethodDelete, "/api/user/urls", `["a"]`)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["b"]`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "the handler must not wait for the workers")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	go wp.Run(ctx)
+
+	resize := func(ip, body string) (int, string) {
+		req := httptest.NewRequest(http.MethodPut, "/api/internal/workers", strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		// the header of the client is ignored without the trusted proxies
+		req.Header.Set("X-Real-IP", "127.0.0.1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w.Code, w.Body.String()
+	}
+
+	code, body := resize("127.0.0.1", `{"workers":4}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"workers":4`)
+	assert.Equal(t, 4, wp.Size())
+
+	code, _ = resize("127.0.0.1", `{"workers":0}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = resize("10.0.0.1", `{"workers":2}`)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, 4, wp.Size())
+}
+
+func TestHandlers_APIKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := configs.New()
+
+	wp := workers.New(ctx, 1, 10)
+
+	go wp.Run(ctx)
+
+	defer wp.Stop()
+
+	_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
+	require.NoError(t, err)
+
+	generator := shortener.NewHashGenerator(cfg.ShortIDLength, cfg.ShortIDAlphabet)
+	service := services.New(memory.NewMemoryRepository(cfg.BaseURL), cfg.BaseURL, wp, subnet, generator, nil, nil, nil, nil)
+	r := router.New(handlers.New(service, cfg.BaseURL, wp), cfg, nil, nil)
+
+	code, body := serve(t, r, http.MethodPost, "/api/user/keys", `{"name":"ci","scopes":["links:read"]}`)
+	require.Equal(t, http.StatusCreated, code)
+
+	var key handlers.ResponseAPIKey
+	require.NoError(t, json.Unmarshal([]byte(body), &key))
+	require.NotEmpty(t, key.Key)
+
+	code, _ = serve(t, r, http.MethodPost, "/api/user/keys", `{"scopes":["links:admin"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = serve(t, r, http.MethodPost, "/", "https://go.dev")
+	require.Equal(t, http.StatusCreated, code)
+
+	withKey := func(method, target, body, value string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", value)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, withKey(http.MethodGet, "/api/user/urls", "", key.Key), "the key acts for its user")
+	assert.Equal(t, http.StatusForbidden, withKey(http.MethodPost, "/api/shorten", `{"url":"https://go.dev/doc"}`, key.Key), "links:write is not granted")
+	assert.Equal(t, http.StatusForbidden, withKey(http.MethodGet, "/api/user/keys", "", key.Key), "the keys can not manage the keys")
+	assert.Equal(t, http.StatusUnauthorized, withKey(http.MethodGet, "/api/user/urls", "", key.Key+"x"))
+
+	code, body = serve(t, r, http.MethodDelete, "/api/user/urls", `["missing"]`)
+	require.Equal(t, http.StatusAccepted, code)
+
+	var deleted struct {
+		JobID string `json:"job_id"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &deleted))
+
+	assert.Equal(t, http.StatusOK, withKey(http.MethodGet, "/api/user/jobs/"+deleted.JobID, "", key.Key), "links:read is enough to follow a delete")
+	assert.Equal(t, http.StatusForbidden, withKey(http.MethodDelete, "/api/user/urls", `["missing"]`, key.Key), "links:delete is not granted")
+
+	code, body = serve(t, r, http.MethodGet, "/api/user/keys", "")
+	require.Equal(t, http.StatusOK, code)
+
+	var keys []handlers.ResponseAPIKey
+	require.NoError(t, json.Unmarshal([]byte(body), &keys))
+	require.Len(t, keys, 1)
+	assert.Empty(t, keys[0].Key, "the secret is not listed")
+	assert.NotNil(t, keys[0].LastUsedAt)
+
+	code, _ = serve(t, r, http.MethodDelete, "/api/user/keys/"+key.ID, "")
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, _ = serve(t, r, http.MethodDelete, "/api/user/keys/"+key.ID, "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	assert.Equal(t, http.StatusUnauthorized, withKey(http.MethodGet, "/api/user/urls", "", key.Key), "the revoked key is refused")
+}
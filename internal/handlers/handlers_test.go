@@ -11,14 +11,17 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/golang/mock/gomock"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers/middlewares"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/configs"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
 )
 
@@ -35,8 +38,12 @@ func router(h *Handlers) *chi.Mux {
 		router.Post("/api/shorten", h.ShortenURL)
 		router.Get("/api/user/urls", h.GetUserURLs)
 		router.Delete("/api/user/urls", h.DeleteBatch)
+		router.Get("/api/user/urls/{id}/stats", h.GetURLStats)
+		router.Get("/api/user/jobs/{id}", h.GetDeleteJob)
 		router.Post("/api/shorten/batch", h.CreateBatch)
 		router.Get("/api/internal/states", h.GetStates)
+		router.Get("/api/internal/dead-letters", h.GetDeadLetters)
+		router.Put("/api/internal/workers", h.ResizeWorkers)
 	})
 
 	return router
@@ -77,8 +84,8 @@ func TestCreateShortURL(t *testing.T) {
 			mockURL:   "http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			want: want{
 				code:        http.StatusBadRequest,
-				contentType: "text/plain; charset=utf-8",
-				response:    "the body cannot be an empty\n",
+				contentType: "application/problem+json",
+				response:    `{"type":"about:blank","title":"Bad Request","status":400,"detail":"the body cannot be an empty","instance":"/","kind":"INVALID_INPUT","reason":"empty","field":"body"}`,
 			},
 		},
 		{
@@ -89,15 +96,15 @@ func TestCreateShortURL(t *testing.T) {
 			mockURL:   "http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			want: want{
 				code:        http.StatusInternalServerError,
-				contentType: "text/plain; charset=utf-8",
-				response:    "",
+				contentType: "application/problem+json",
+				response:    `{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/","kind":"INTERNAL"}`,
 			},
 		},
 		{
 			name:      "the url already exists in the database",
 			query:     "/",
 			body:      "https://go.dev",
-			mockError: NewErrorWithDB(errors.New("UniqConstraint"), "UniqConstraint"),
+			mockError: customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil),
 			mockURL:   "http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			want: want{
 				code:        http.StatusConflict,
@@ -125,7 +132,7 @@ func TestCreateShortURL(t *testing.T) {
 
 			r := router(h)
 
-			repoMock.EXPECT().CreateURL(gomock.Any(), tt.body, "userID").Return(tt.mockURL, tt.mockError).AnyTimes()
+			repoMock.EXPECT().CreateURL(gomock.Any(), tt.body, "", models.Expiration{}, "userID").Return(tt.mockURL, tt.mockError).AnyTimes()
 
 			r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), middlewares.UserIDCtxName, "userID")))
 
@@ -167,7 +174,7 @@ func TestRetrieveShortURL(t *testing.T) {
 		{
 			name:      "deleted",
 			query:     "/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
-			mockError: NewErrorWithDB(errors.New("deleted"), "deleted"),
+			mockError: customerrors.Gone(customerrors.ReasonDeleted, "the link is deleted"),
 			mockID:    "Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			mockURL:   "https://go.dev",
 			want: want{
@@ -175,15 +182,45 @@ func TestRetrieveShortURL(t *testing.T) {
 			},
 		},
 		{
-			name:      "deleted",
+			name:      "expired",
+			query:     "/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
+			mockError: customerrors.Gone(customerrors.ReasonExpired, "the link is expired"),
+			mockID:    "Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
+			mockURL:   "https://go.dev",
+			want: want{
+				code: http.StatusGone,
+			},
+		},
+		{
+			name:      "blocked",
 			query:     "/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
-			mockError: errors.New(""),
+			mockError: customerrors.Gone(customerrors.ReasonBlocked, "the destination is blocked"),
+			mockID:    "Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
+			mockURL:   "https://go.dev",
+			want: want{
+				code: http.StatusGone,
+			},
+		},
+		{
+			name:      "not found",
+			query:     "/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
+			mockError: customerrors.NotFound("the short url not found"),
 			mockID:    "Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			mockURL:   "https://go.dev",
 			want: want{
 				code: http.StatusNotFound,
 			},
 		},
+		{
+			name:      "unexpected error",
+			query:     "/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
+			mockError: errors.New("connection refused"),
+			mockID:    "Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
+			mockURL:   "https://go.dev",
+			want: want{
+				code: http.StatusInternalServerError,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -207,6 +244,13 @@ func TestRetrieveShortURL(t *testing.T) {
 
 			repoMock.EXPECT().GetURL(gomock.Any(), tt.mockID).Return(tt.mockURL, tt.mockError).AnyTimes()
 
+			clicks := 0
+			if tt.mockError == nil {
+				clicks = 1
+			}
+
+			repoMock.EXPECT().RecordClick(gomock.Any()).Times(clicks)
+
 			r.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.want.code, w.Code)
@@ -246,8 +290,8 @@ func TestShortenURL(t *testing.T) {
 			mockError: nil,
 			mockURL:   "http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			want: want{
-				code:     http.StatusInternalServerError,
-				response: "an unexpected error when unmarshaling JSON\n",
+				code:     http.StatusBadRequest,
+				response: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"unexpected end of JSON input","instance":"/api/shorten","kind":"INVALID_INPUT","reason":"malformed","field":"body"}`,
 			},
 		},
 		{
@@ -258,20 +302,30 @@ func TestShortenURL(t *testing.T) {
 			mockURL:   "http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			want: want{
 				code:     http.StatusBadRequest,
-				response: "the URL property is missing\n",
+				response: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"the URL property is missing","instance":"/api/shorten","kind":"INVALID_INPUT","reason":"empty","field":"url"}`,
 			},
 		},
 		{
-			name:      "UniqConstraint",
+			name:      "URLExists",
 			query:     "/api/shorten",
 			body:      `{"url":"https://go.dev"}`,
-			mockError: NewErrorWithDB(errors.New("UniqConstraint"), "UniqConstraint"),
+			mockError: customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil),
 			mockURL:   "http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=",
 			want: want{
 				code:     http.StatusConflict,
 				response: "{\"result\":\"http://localhost:8080/Vq7zU8E5b7sLZo3qY82UKYRvQ-A=\"}",
 			},
 		},
+		{
+			name:      "the alias is taken",
+			query:     "/api/shorten",
+			body:      `{"url":"https://go.dev","alias":"spring-sale"}`,
+			mockError: customerrors.Conflict(customerrors.ReasonAliasTaken, "the alias is already taken", nil),
+			want: want{
+				code:     http.StatusConflict,
+				response: `{"type":"about:blank","title":"Conflict","status":409,"detail":"the alias is already taken","instance":"/api/shorten","kind":"CONFLICT","reason":"alias_taken"}`,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -296,7 +350,7 @@ func TestShortenURL(t *testing.T) {
 
 			_ = json.Unmarshal(bytes.NewBufferString(tt.body).Bytes(), &url)
 
-			repoMock.EXPECT().CreateURL(gomock.Any(), url.URL, "userID").Return(tt.mockURL, tt.mockError).AnyTimes()
+			repoMock.EXPECT().CreateURL(gomock.Any(), url.URL, url.Alias, url.Expiration(), "userID").Return(tt.mockURL, tt.mockError).AnyTimes()
 
 			r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), middlewares.UserIDCtxName, "userID")))
 
@@ -381,6 +435,94 @@ func TestGetUserURLs(t *testing.T) {
 	}
 }
 
+func TestGetURLStats(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		bucket    string
+		mockStats ResponseStats
+		mockError error
+		want      want
+	}{
+		{
+			name:      "positive test",
+			query:     "/api/user/urls/abc/stats?bucket=hour",
+			bucket:    "hour",
+			mockStats: ResponseStats{ShortURL: "abc", TotalClicks: 2, UniqueClicks: 1, Bucket: "hour", Series: []StatsBucket{}, TopReferrers: []StatsCount{{Value: "direct", Clicks: 2}}, TopUserAgents: []StatsCount{}},
+			want: want{
+				code:     http.StatusOK,
+				response: `{"short_url":"abc","total_clicks":2,"unique_clicks":1,"bucket":"hour","series":[],"top_referrers":[{"value":"direct","clicks":2}],"top_user_agents":[]}`,
+			},
+		},
+		{
+			name:      "another user",
+			query:     "/api/user/urls/abc/stats",
+			mockError: customerrors.Forbidden("the url belongs to another user"),
+			want: want{
+				code:     http.StatusForbidden,
+				response: `{"type":"about:blank","title":"Forbidden","status":403,"detail":"the url belongs to another user","instance":"/api/user/urls/abc/stats","kind":"FORBIDDEN","reason":"not_owner"}`,
+			},
+		},
+		{
+			name:      "invalid bucket",
+			query:     "/api/user/urls/abc/stats?bucket=week",
+			bucket:    "week",
+			mockError: customerrors.InvalidInput("bucket", "invalid", "the bucket must be \"hour\" or \"day\""),
+			want: want{
+				code:     http.StatusBadRequest,
+				response: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"the bucket must be \"hour\" or \"day\"","instance":"/api/user/urls/abc/stats","kind":"INVALID_INPUT","reason":"invalid","field":"bucket"}`,
+			},
+		},
+		{
+			name:      "not found",
+			query:     "/api/user/urls/abc/stats",
+			mockError: customerrors.NotFound("the short url not found"),
+			want: want{
+				code:     http.StatusNotFound,
+				response: `{"type":"about:blank","title":"Not Found","status":404,"detail":"the short url not found","instance":"/api/user/urls/abc/stats","kind":"NOT_FOUND","reason":"not_found"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.query, nil)
+			w := httptest.NewRecorder()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			cfg := configs.New()
+
+			wp := workers.New(context.Background(), cfg.Workers, cfg.WorkersBuffer)
+
+			repoMock := NewMockURLServiceInterface(ctrl)
+
+			h := New(repoMock, cfg.BaseURL, wp)
+
+			r := router(h)
+
+			repoMock.EXPECT().GetURLStats(gomock.Any(), "abc", "userID", tt.bucket).Return(tt.mockStats, tt.mockError)
+
+			r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), middlewares.UserIDCtxName, "userID")))
+
+			response := w.Result()
+
+			defer response.Body.Close()
+
+			body, _ := ioutil.ReadAll(response.Body)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
+
 func TestDeleteBatch(t *testing.T) {
 	type want struct {
 		code     int
@@ -402,7 +544,8 @@ func TestDeleteBatch(t *testing.T) {
 			mockError: nil,
 			mockURLs:  []string{"", ""},
 			want: want{
-				code: http.StatusAccepted,
+				code:     http.StatusAccepted,
+				response: `{"job_id":"job"}`,
 			},
 		},
 		{
@@ -411,9 +554,20 @@ func TestDeleteBatch(t *testing.T) {
 			body:      `["]`,
 			mockError: nil,
 			mockURLs:  []string{"", ""},
+			want: want{
+				code:     http.StatusBadRequest,
+				response: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"unexpected end of JSON input","instance":"/api/user/urls","kind":"INVALID_INPUT","reason":"malformed","field":"body"}`,
+			},
+		},
+		{
+			name:      "the jobs are not saved",
+			query:     "/api/user/urls",
+			body:      `["a"]`,
+			mockError: errors.New("journal is not writable"),
+			mockURLs:  []string{"a"},
 			want: want{
 				code:     http.StatusInternalServerError,
-				response: "unexpected end of JSON input\n",
+				response: `{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/api/user/urls","kind":"INTERNAL"}`,
 			},
 		},
 	}
@@ -436,7 +590,7 @@ func TestDeleteBatch(t *testing.T) {
 
 			r := router(h)
 
-			repoMock.EXPECT().DeleteBatch(tt.mockURLs, "userID").AnyTimes()
+			repoMock.EXPECT().DeleteBatch(gomock.Any(), tt.mockURLs, "userID").Return("job", tt.mockError).AnyTimes()
 
 			r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), middlewares.UserIDCtxName, "userID")))
 
@@ -576,3 +730,149 @@ func TestHandlers_GetStates(t *testing.T) {
 		})
 	}
 }
+
+func TestGetDeadLetters(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+	}
+
+	failedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		hasPermission bool
+		mockLetters   []workers.DeadLetter
+		want          want
+	}{
+		{
+			name:          "positive test",
+			hasPermission: true,
+			mockLetters:   []workers.DeadLetter{{ID: 1, Name: "delete_urls", Attempts: 3, Error: "connection refused", RequestID: "abc", FailedAt: failedAt}},
+			want: want{
+				code:     http.StatusOK,
+				response: `[{"id":1,"name":"delete_urls","attempts":3,"error":"connection refused","request_id":"abc","failed_at":"2022-03-01T12:00:00Z"}]`,
+			},
+		},
+		{
+			name:          "no dead letters",
+			hasPermission: true,
+			want: want{
+				code:     http.StatusOK,
+				response: `[]`,
+			},
+		},
+		{
+			name: "untrusted address",
+			want: want{
+				code:     http.StatusForbidden,
+				response: `{"type":"about:blank","title":"Forbidden","status":403,"detail":"the address is not in the trusted subnet","instance":"/api/internal/dead-letters","kind":"FORBIDDEN","reason":"untrusted_network"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/api/internal/dead-letters", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			// the header of the client is ignored without the trusted proxies
+			req.Header.Set("X-Real-IP", "10.0.0.1")
+			w := httptest.NewRecorder()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repoMock := NewMockURLServiceInterface(ctrl)
+
+			h := New(repoMock, "http://localhost:8080", nil)
+
+			r := router(h)
+
+			repoMock.EXPECT().GetDeadLetters(gomock.Any(), net.ParseIP("127.0.0.1")).Return(tt.hasPermission, tt.mockLetters)
+
+			r.ServeHTTP(w, req)
+
+			response := w.Result()
+
+			defer response.Body.Close()
+
+			body, _ := ioutil.ReadAll(response.Body)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
+
+func TestGetDeleteJob(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+	}
+
+	createdAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		query     string
+		mockID    string
+		mockJob   ResponseDeleteJob
+		mockError error
+		want      want
+	}{
+		{
+			name:   "positive test",
+			query:  "/api/user/jobs/job",
+			mockID: "job",
+			mockJob: ResponseDeleteJob{
+				ID:        "job",
+				Status:    DeleteJobPending,
+				URLs:      []DeleteURLResult{{ShortURL: "a", Outcome: DeleteNotOwner}, {ShortURL: "b", Outcome: DeletePending}},
+				CreatedAt: createdAt,
+			},
+			want: want{
+				code:     http.StatusOK,
+				response: `{"id":"job","status":"pending","urls":[{"short_url":"a","outcome":"not_owner"},{"short_url":"b","outcome":"pending"}],"created_at":"2022-03-01T12:00:00Z"}`,
+			},
+		},
+		{
+			name:      "not found",
+			query:     "/api/user/jobs/missing",
+			mockID:    "missing",
+			mockError: customerrors.NotFound("the job not found"),
+			want: want{
+				code:     http.StatusNotFound,
+				response: `{"type":"about:blank","title":"Not Found","status":404,"detail":"the job not found","instance":"/api/user/jobs/missing","kind":"NOT_FOUND","reason":"not_found"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.query, nil)
+			w := httptest.NewRecorder()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repoMock := NewMockURLServiceInterface(ctrl)
+
+			h := New(repoMock, "http://localhost:8080", nil)
+
+			r := router(h)
+
+			repoMock.EXPECT().GetDeleteJob(gomock.Any(), tt.mockID, "userID").Return(tt.mockJob, tt.mockError)
+
+			r.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), middlewares.UserIDCtxName, "userID")))
+
+			response := w.Result()
+
+			defer response.Body.Close()
+
+			body, _ := ioutil.ReadAll(response.Body)
+
+			assert.Equal(t, tt.want.code, w.Code)
+			assert.Equal(t, tt.want.response, string(body))
+		})
+	}
+}
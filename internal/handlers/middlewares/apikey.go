@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// APIKeyMiddleware identifies the user by the X-API-Key header and limits the request to the scopes of the key.
+// An unknown key gets 401, the requests without the header or authenticated by a bearer token pass as is.
+// CookieMiddleware neither reads nor issues the cookie for the requests with the header.
+func APIKeyMiddleware(keys auth.APIKeyAuthenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(auth.APIKeyHeader)
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if _, ok := auth.FromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := keys.AuthenticateAPIKey(r.Context(), value)
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if err != nil {
+				logger.FromContext(r.Context()).Error("unable to authenticate the API key", "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDCtxName, key.User)
+			ctx = auth.WithScopes(auth.NewContext(ctx, key.User), key.Scopes)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope refuses the requests of the API keys without the scope with 403
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.HasScope(r.Context(), scope) {
+				http.Error(w, "the API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyAPIKeys refuses the requests of the API keys with 403, the keys can not mint other keys
+func DenyAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.IsAPIKey(r.Context()) {
+			http.Error(w, "the API keys can not manage the keys", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
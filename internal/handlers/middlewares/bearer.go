@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// BearerMiddleware identifies the user by the Authorization: Bearer token, it goes before CookieMiddleware
+// and the cookie is not read or issued for such requests. An invalid token, or any token if verifier is nil, gets 401.
+// The requests without the header pass on to the cookie.
+func BearerMiddleware(verifier *auth.Verifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := auth.BearerToken(header)
+			if !ok || verifier == nil {
+				unauthorized(w)
+				return
+			}
+
+			userID, err := verifier.Verify(token)
+			if err != nil {
+				logger.FromContext(r.Context()).Debug("bearer token refused", "error", err)
+				unauthorized(w)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDCtxName, userID)
+
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(ctx, userID)))
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
+)
+
+func TestBearerMiddleware(t *testing.T) {
+	secret := []byte(strings.Repeat("s", auth.MinSecretLength))
+
+	verifier, err := auth.NewVerifier([][]byte{secret}, nil, "", "")
+	require.NoError(t, err)
+
+	keyring, err := encryptor.NewKeyring(bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err)
+
+	var seen string
+
+	h := Conveyor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(UserIDCtxName).(string)
+	}), CookieMiddleware(keyring, helpers.CookieSettings{TTL: time.Hour}), BearerMiddleware(verifier))
+
+	token, err := auth.SignHS256(secret, auth.Claims{Subject: "ci", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantUser   string
+		wantCookie bool
+	}{
+		{name: "token", header: "Bearer " + token, wantStatus: http.StatusOK, wantUser: "ci"},
+		{name: "invalid token", header: "Bearer " + token + "x", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", header: "Basic abc", wantStatus: http.StatusUnauthorized},
+		{name: "cookie", wantStatus: http.StatusOK, wantCookie: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = ""
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantCookie, len(res.Cookies()) > 0, "the cookie is issued only without the token")
+
+			if tt.wantUser != "" {
+				assert.Equal(t, tt.wantUser, seen)
+			}
+		})
+	}
+}
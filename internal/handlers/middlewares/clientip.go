@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const ClientIPCtxName ContextType = "ctxClientIP"
+
+// ClientIPMiddleware puts the client address into the request context.
+// The X-Forwarded-For and X-Real-IP headers are taken into account only if the request comes from a trusted proxy.
+func ClientIPMiddleware(trustedProxies []*net.IPNet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r, trustedProxies)
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClientIPCtxName, ip)))
+		})
+	}
+}
+
+// ClientIP returns the address of the client, the proxies from trustedProxies are skipped
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !trusted(net.ParseIP(host), trustedProxies) {
+		return host
+	}
+
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		// the rightmost addresses are appended by the nearest proxies
+		forwarded := strings.Split(forwardedFor, ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+			if ip == nil {
+				break
+			}
+
+			host = ip.String()
+
+			if !trusted(ip, trustedProxies) {
+				break
+			}
+		}
+
+		return host
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return host
+}
+
+func trusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies := []*net.IPNet{proxies}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		want         string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "untrusted proxy headers are ignored", remoteAddr: "203.0.113.7:1234", forwardedFor: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1", want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: "192.0.2.5, 198.51.100.1, 10.0.0.2", want: "198.51.100.1"},
+		{name: "all proxies trusted", remoteAddr: "10.0.0.1:1234", forwardedFor: "10.0.0.3, 10.0.0.2", want: "10.0.0.3"},
+		{name: "real ip", remoteAddr: "10.0.0.1:1234", realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "malformed header", remoteAddr: "10.0.0.1:1234", forwardedFor: "unknown", want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			assert.Equal(t, tt.want, ClientIP(r, trustedProxies))
+		})
+	}
+}
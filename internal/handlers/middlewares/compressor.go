@@ -4,9 +4,10 @@ package middlewares
 import (
 	"compress/gzip"
 	"io"
-	"log"
 	"net/http"
 	"strings"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
 )
 
 type gzipWriter struct {
@@ -20,7 +21,7 @@ func GzipMiddleware(next http.Handler) http.Handler {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
 			reader, err := gzip.NewReader(r.Body)
 			if err != nil {
-				log.Println("decompress error", err)
+				logger.FromContext(r.Context()).Warn("decompress error", "error", err)
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -35,7 +36,7 @@ func GzipMiddleware(next http.Handler) http.Handler {
 
 		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 		if err != nil {
-			log.Println("compress error", err)
+			logger.FromContext(r.Context()).Warn("compress error", "error", err)
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -3,11 +3,14 @@ package middlewares
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gofrs/uuid"
 
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
 )
 
 const CookieUserIDName = "user_id"
@@ -16,21 +19,45 @@ type ContextType string
 
 const UserIDCtxName ContextType = "ctxUserId"
 
-func CookieMiddleware(key []byte) func(next http.Handler) http.Handler {
+// CookieMiddleware identifies the user by the cookie sealed with the keyring, a new user gets a new cookie.
+// The requests authenticated by BearerMiddleware or carrying an API key are passed as is.
+// The expired and forged cookies are ignored, as are the unsealed cookies of the previous versions, so their users get
+// a new identity. The cookies sealed with a previous key are issued again, as are the cookies with less than
+// half of settings.TTL left, so the active users stay signed in.
+func CookieMiddleware(keyring *encryptor.Keyring, settings helpers.CookieSettings) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookieUserID, _ := r.Cookie(CookieUserIDName)
-			encryptor, err := encryptor.New(key)
-
-			if err != nil {
+			if _, ok := auth.FromContext(r.Context()); ok || r.Header.Get(auth.APIKeyHeader) != "" {
+				next.ServeHTTP(w, r)
 				return
 			}
 
+			now := time.Now()
+
+			issue := func(userID string) error {
+				value, err := keyring.Issue(userID, now, settings.TTL)
+				if err != nil {
+					return err
+				}
+
+				http.SetCookie(w, settings.Cookie(CookieUserIDName, value))
+
+				return nil
+			}
+
+			cookieUserID, _ := r.Cookie(CookieUserIDName)
+
 			if cookieUserID != nil {
-				userID, err := encryptor.Decode(cookieUserID.Value)
+				token, stale, err := keyring.Open(cookieUserID.Value, now)
 
 				if err == nil {
-					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserIDCtxName, userID)))
+					if encryptor.NeedsRenewal(token, stale, now, settings.TTL) {
+						if err := issue(token.UserID); err != nil {
+							logger.FromContext(r.Context()).Error("unable to renew the user cookie", "error", err)
+						}
+					}
+
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserIDCtxName, token.UserID)))
 					return
 				}
 			}
@@ -40,10 +67,12 @@ func CookieMiddleware(key []byte) func(next http.Handler) http.Handler {
 				return
 			}
 
-			encoded := encryptor.Encode(userID.Bytes())
-			cookie := helpers.CreateCookie(CookieUserIDName, encoded)
+			if err := issue(userID.String()); err != nil {
+				logger.FromContext(r.Context()).Error("unable to issue the user cookie", "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
 
-			http.SetCookie(w, cookie)
 			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserIDCtxName, userID.String())))
 		})
 	}
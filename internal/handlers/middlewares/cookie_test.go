@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
+)
+
+func TestCookieMiddleware(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 16)
+
+	legacy, err := encryptor.New(oldKey)
+	require.NoError(t, err)
+
+	old, err := encryptor.NewKeyring(oldKey)
+	require.NoError(t, err)
+
+	keyring, err := encryptor.NewKeyring(bytes.Repeat([]byte{2}, 16), oldKey)
+	require.NoError(t, err)
+
+	settings := helpers.CookieSettings{
+		Path:     "/",
+		TTL:      time.Hour,
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	userID, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	var seen string
+
+	h := CookieMiddleware(keyring, settings)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(UserIDCtxName).(string)
+	}))
+
+	serve := func(value string) *http.Cookie {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			r.AddCookie(&http.Cookie{Name: CookieUserIDName, Value: value})
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == CookieUserIDName {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	seal := func(k *encryptor.Keyring, issuedAt time.Time, ttl time.Duration) string {
+		value, err := k.Seal(encryptor.Token{UserID: userID.String(), IssuedAt: issuedAt, ExpiresAt: issuedAt.Add(ttl)})
+		require.NoError(t, err)
+
+		return value
+	}
+
+	now := time.Now()
+
+	reissued := serve(seal(old, now, time.Hour))
+	assert.Equal(t, userID.String(), seen, "the user of the previous key must be kept")
+	require.NotNil(t, reissued, "the cookie must be issued again with the active key")
+	assert.True(t, reissued.Secure)
+	assert.True(t, reissued.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, reissued.SameSite)
+	assert.Equal(t, 3600, reissued.MaxAge)
+
+	assert.Nil(t, serve(reissued.Value), "the fresh cookie of the active key must not be issued again")
+	assert.Equal(t, userID.String(), seen)
+
+	renewed := serve(seal(keyring, now.Add(-40*time.Minute), time.Hour))
+	assert.Equal(t, userID.String(), seen, "the user of the aging cookie must be kept")
+	require.NotNil(t, renewed, "the cookie with less than half of the lifetime left must be renewed")
+
+	issued := serve(legacy.Encode(userID.Bytes()))
+	require.NotNil(t, issued)
+	assert.NotEqual(t, userID.String(), seen, "an unsealed cookie gets a new user")
+
+	issued = serve(seal(keyring, now.Add(-2*time.Hour), time.Hour))
+	require.NotNil(t, issued)
+	assert.NotEqual(t, userID.String(), seen, "an expired cookie gets a new user")
+
+	issued = serve("garbage")
+	require.NotNil(t, issued)
+	assert.NotEqual(t, userID.String(), seen, "an invalid cookie gets a new user")
+}
@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// RequestLoggerMiddleware puts the request id and the logger carrying it into the request context and logs the request.
+// The id is taken from the X-Request-ID header or generated, it is returned in the same header.
+func RequestLoggerMiddleware(l *logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := logger.AcceptRequestID(r.Header.Get(logger.RequestIDHeader))
+			requestLogger := l.With("request_id", requestID)
+
+			w.Header().Set(logger.RequestIDHeader, requestID)
+
+			ctx := logger.NewContext(logger.WithRequestID(r.Context(), requestID), requestLogger)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			clientIP, _ := ctx.Value(ClientIPCtxName).(string)
+			if clientIP == "" {
+				clientIP = ClientIP(r, nil)
+			}
+
+			requestLogger.Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"duration", time.Since(start),
+				"client_ip", clientIP,
+			)
+		})
+	}
+}
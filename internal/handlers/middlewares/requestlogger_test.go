@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+func TestRequestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, err := logger.New(&buf, logger.FormatJSON, logger.LevelInfo)
+	require.NoError(t, err)
+
+	var requestID string
+
+	h := RequestLoggerMiddleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = logger.RequestID(r.Context())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, "abc-123", requestID)
+	assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+
+	var record map[string]interface{}
+
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "abc-123", record["request_id"])
+	assert.Equal(t, "DELETE", record["method"])
+	assert.Equal(t, "/api/user/urls", record["path"])
+	assert.Equal(t, float64(http.StatusAccepted), record["status"])
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"), "the request id must be generated")
+	assert.Equal(t, requestID, w.Header().Get("X-Request-ID"))
+}
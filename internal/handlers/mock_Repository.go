@@ -8,6 +8,7 @@ import (
 	"github.com/golang/mock/gomock"
 
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
 )
 
 // MockURLServiceInterface is a mock of Repository interface.
@@ -33,6 +34,36 @@ func (m *MockURLServiceInterface) EXPECT() *MockURLServiceInterfaceMockRecorder
 	return m.recorder
 }
 
+// AuthenticateAPIKey mocks base method.
+func (m *MockURLServiceInterface) AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthenticateAPIKey", ctx, key)
+	ret0, _ := ret[0].(models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuthenticateAPIKey indicates an expected call of AuthenticateAPIKey.
+func (mr *MockURLServiceInterfaceMockRecorder) AuthenticateAPIKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthenticateAPIKey", reflect.TypeOf((*MockURLServiceInterface)(nil).AuthenticateAPIKey), ctx, key)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockURLServiceInterface) CreateAPIKey(ctx context.Context, user models.UserID, name string, scopes []string) (ResponseAPIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", ctx, user, name, scopes)
+	ret0, _ := ret[0].(ResponseAPIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockURLServiceInterfaceMockRecorder) CreateAPIKey(ctx, user, name, scopes interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockURLServiceInterface)(nil).CreateAPIKey), ctx, user, name, scopes)
+}
+
 // CreateBatch mocks base method.
 func (m *MockURLServiceInterface) CreateBatch(ctx context.Context, urls []RequestGetURLs, userID models.UserID) ([]ResponseGetURLs, error) {
 	m.ctrl.T.Helper()
@@ -49,34 +80,74 @@ func (mr *MockURLServiceInterfaceMockRecorder) CreateBatch(ctx, urls, userID int
 }
 
 // CreateURL mocks base method.
-func (m *MockURLServiceInterface) CreateURL(ctx context.Context, longURL models.LongURL, user models.UserID) (string, error) {
+func (m *MockURLServiceInterface) CreateURL(ctx context.Context, longURL models.LongURL, alias string, expiration models.Expiration, user models.UserID) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateURL", ctx, longURL, user)
+	ret := m.ctrl.Call(m, "CreateURL", ctx, longURL, alias, expiration, user)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateURL indicates an expected call of AddURL.
-func (mr *MockURLServiceInterfaceMockRecorder) CreateURL(ctx, longURL, user interface{}) *gomock.Call {
+func (mr *MockURLServiceInterfaceMockRecorder) CreateURL(ctx, longURL, alias, expiration, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateURL", reflect.TypeOf((*MockURLServiceInterface)(nil).CreateURL), ctx, longURL, alias, expiration, user)
+}
+
+// DeleteAPIKey mocks base method.
+func (m *MockURLServiceInterface) DeleteAPIKey(ctx context.Context, user models.UserID, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAPIKey", ctx, user, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAPIKey indicates an expected call of DeleteAPIKey.
+func (mr *MockURLServiceInterfaceMockRecorder) DeleteAPIKey(ctx, user, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockURLServiceInterface)(nil).DeleteAPIKey), ctx, user, id)
+}
+
+// GetAPIKeys mocks base method.
+func (m *MockURLServiceInterface) GetAPIKeys(ctx context.Context, user models.UserID) ([]ResponseAPIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeys", ctx, user)
+	ret0, _ := ret[0].([]ResponseAPIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeys indicates an expected call of GetAPIKeys.
+func (mr *MockURLServiceInterfaceMockRecorder) GetAPIKeys(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeys", reflect.TypeOf((*MockURLServiceInterface)(nil).GetAPIKeys), ctx, user)
+}
+
+// RecordClick mocks base method.
+func (m *MockURLServiceInterface) RecordClick(click models.Click) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordClick", click)
+}
+
+// RecordClick indicates an expected call of RecordClick.
+func (mr *MockURLServiceInterfaceMockRecorder) RecordClick(click interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateURL", reflect.TypeOf((*MockURLServiceInterface)(nil).CreateURL), ctx, longURL, user)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordClick", reflect.TypeOf((*MockURLServiceInterface)(nil).RecordClick), click)
 }
 
 // DeleteBatch mocks base method.
-func (m *MockURLServiceInterface) DeleteBatch(urls []string, user models.UserID) {
+func (m *MockURLServiceInterface) DeleteBatch(ctx context.Context, urls []string, user models.UserID) (string, error) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{user}
-	for _, a := range urls {
-		varargs = append(varargs, a)
-	}
+	ret := m.ctrl.Call(m, "DeleteBatch", ctx, urls, user)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// DeleteBatch indicates an expected call of DeleteMultipleURLs.
-func (mr *MockURLServiceInterfaceMockRecorder) DeleteBatch(ctx, user interface{}, urls ...interface{}) *gomock.Call {
+// DeleteBatch indicates an expected call of DeleteBatch.
+func (mr *MockURLServiceInterfaceMockRecorder) DeleteBatch(ctx, urls, user interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{ctx, user}, urls...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBatch", reflect.TypeOf((*MockURLServiceInterface)(nil).DeleteBatch), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBatch", reflect.TypeOf((*MockURLServiceInterface)(nil).DeleteBatch), ctx, urls, user)
 }
 
 // GetURL mocks base method.
@@ -112,6 +183,67 @@ func (mr *MockURLServiceInterfaceMockRecorder) GetStates(ctx interface{}, ip net
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStates", reflect.TypeOf((*MockURLServiceInterface)(nil).GetStates), ctx, ip)
 }
 
+// GetDeadLetters mocks base method.
+func (m *MockURLServiceInterface) GetDeadLetters(ctx context.Context, ip net.IP) (bool, []workers.DeadLetter) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeadLetters", ctx, ip)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].([]workers.DeadLetter)
+	return ret0, ret1
+}
+
+// GetDeadLetters indicates an expected call of GetDeadLetters.
+func (mr *MockURLServiceInterfaceMockRecorder) GetDeadLetters(ctx, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeadLetters", reflect.TypeOf((*MockURLServiceInterface)(nil).GetDeadLetters), ctx, ip)
+}
+
+// ResizeWorkers mocks base method.
+func (m *MockURLServiceInterface) ResizeWorkers(ctx context.Context, ip net.IP, workers int) (bool, ResponseWorkers, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResizeWorkers", ctx, ip, workers)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(ResponseWorkers)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ResizeWorkers indicates an expected call of ResizeWorkers.
+func (mr *MockURLServiceInterfaceMockRecorder) ResizeWorkers(ctx, ip, workers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResizeWorkers", reflect.TypeOf((*MockURLServiceInterface)(nil).ResizeWorkers), ctx, ip, workers)
+}
+
+// GetDeleteJob mocks base method.
+func (m *MockURLServiceInterface) GetDeleteJob(ctx context.Context, id string, user models.UserID) (ResponseDeleteJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeleteJob", ctx, id, user)
+	ret0, _ := ret[0].(ResponseDeleteJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeleteJob indicates an expected call of GetDeleteJob.
+func (mr *MockURLServiceInterfaceMockRecorder) GetDeleteJob(ctx, id, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeleteJob", reflect.TypeOf((*MockURLServiceInterface)(nil).GetDeleteJob), ctx, id, user)
+}
+
+// GetURLStats mocks base method.
+func (m *MockURLServiceInterface) GetURLStats(ctx context.Context, shortURL models.ShortURL, user models.UserID, bucket string) (ResponseStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetURLStats", ctx, shortURL, user, bucket)
+	ret0, _ := ret[0].(ResponseStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetURLStats indicates an expected call of GetURLStats.
+func (mr *MockURLServiceInterfaceMockRecorder) GetURLStats(ctx, shortURL, user, bucket interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLStats", reflect.TypeOf((*MockURLServiceInterface)(nil).GetURLStats), ctx, shortURL, user, bucket)
+}
+
 // GetUserURLs mocks base method.
 func (m *MockURLServiceInterface) GetUserURLs(ctx context.Context, user models.UserID) ([]ResponseGetURL, error) {
 	m.ctrl.T.Helper()
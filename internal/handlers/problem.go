@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// problemContentType - the media type of the error responses
+const problemContentType = "application/problem+json"
+
+// errUntrusted is returned to the internal endpoints outside the trusted subnet
+var errUntrusted = customerrors.New(customerrors.KindForbidden, customerrors.ReasonUntrusted, "the address is not in the trusted subnet")
+
+// malformedBody describes the request body that can not be unmarshaled
+func malformedBody(err error) error {
+	return customerrors.InvalidInput("body", "malformed", err.Error())
+}
+
+// Problem is the RFC 7807 body of the error responses
+type Problem struct {
+	// Type - "about:blank", the title is the HTTP status text
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Kind - the kind of the error, e.g. "NOT_FOUND", the same as the reason of the gRPC ErrorInfo
+	Kind string `json:"kind"`
+	// Reason - machine-readable cause within the kind, e.g. "deleted"
+	Reason string `json:"reason,omitempty"`
+	// Field - the invalid field of the request
+	Field string `json:"field,omitempty"`
+}
+
+// writeError writes the error as a Problem with the status of its kind.
+// The internal errors are logged and their messages are not returned to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	kind := customerrors.KindOf(err)
+
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(kind.HTTPStatus()),
+		Status:   kind.HTTPStatus(),
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+		Kind:     kind.String(),
+	}
+
+	if domainErr, ok := customerrors.As(err); ok {
+		problem.Detail = domainErr.Message
+		problem.Reason = domainErr.Reason
+		problem.Field = domainErr.Field
+	}
+
+	switch kind {
+	case customerrors.KindInternal:
+		logger.FromContext(r.Context()).Error("unexpected error of the service", "error", err)
+
+		problem.Detail = ""
+	case customerrors.KindUnavailable:
+		w.Header().Set("Retry-After", retryAfter)
+	}
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		http.Error(w, "an unexpected error when marshaling JSON", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+
+	_, err = w.Write(body)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("unexpected error when writing the response body", "error", err)
+	}
+}
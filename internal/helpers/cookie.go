@@ -3,6 +3,7 @@ package helpers
 
 import (
 	"net/http"
+	"time"
 )
 
 // CreateCookie a represents an HTTP cookie as sent in the Set-Cookie header of an
@@ -13,3 +14,34 @@ func CreateCookie(name, value string) *http.Cookie {
 		Path:  "/",
 	}
 }
+
+// CookieSettings are the attributes of the issued cookies
+type CookieSettings struct {
+	Path   string
+	Domain string
+	// TTL - lifetime of the cookie, a session cookie if zero
+	TTL      time.Duration
+	Secure   bool
+	HTTPOnly bool
+	SameSite http.SameSite
+}
+
+// Cookie creates the cookie with the settings
+func (s CookieSettings) Cookie(name, value string) *http.Cookie {
+	cookie := CreateCookie(name, value)
+
+	if s.Path != "" {
+		cookie.Path = s.Path
+	}
+
+	cookie.Domain = s.Domain
+	cookie.Secure = s.Secure
+	cookie.HttpOnly = s.HTTPOnly
+	cookie.SameSite = s.SameSite
+
+	if s.TTL > 0 {
+		cookie.MaxAge = int(s.TTL / time.Second)
+	}
+
+	return cookie
+}
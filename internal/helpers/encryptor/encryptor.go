@@ -5,6 +5,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/hex"
+	"errors"
 
 	"github.com/google/uuid"
 )
@@ -45,6 +46,10 @@ func (e *Encryptor) Decode(value string) (string, error) {
 		return "", err
 	}
 
+	if len(encrypted) != aes.BlockSize {
+		return "", errors.New("the value is not a single block")
+	}
+
 	decrypted := make([]byte, aes.BlockSize)
 	e.aesblock.Decrypt(decrypted, encrypted)
 
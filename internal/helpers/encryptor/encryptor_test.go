@@ -1,10 +1,17 @@
 package encryptor
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers"
 )
 
@@ -46,3 +53,128 @@ func BenchmarkEncryptor_Decode(b *testing.B) {
 		}
 	})
 }
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	now := time.Now()
+
+	userID, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	token := Token{UserID: userID.String(), IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	old, err := NewKeyring(oldKey)
+	require.NoError(t, err)
+
+	issued, err := old.Seal(token)
+	require.NoError(t, err)
+
+	rotated, err := NewKeyring(newKey, oldKey)
+	require.NoError(t, err)
+
+	opened, stale, err := rotated.Open(issued, now)
+	require.NoError(t, err)
+	assert.Equal(t, userID.String(), opened.UserID)
+	assert.True(t, stale, "the cookie of the previous key must be issued again")
+
+	sealed, err := rotated.Seal(token)
+	require.NoError(t, err)
+
+	opened, stale, err = rotated.Open(sealed, now)
+	require.NoError(t, err)
+	assert.Equal(t, userID.String(), opened.UserID)
+	assert.Equal(t, now.Unix(), opened.IssuedAt.Unix())
+	assert.Equal(t, now.Add(time.Hour).Unix(), opened.ExpiresAt.Unix())
+	assert.False(t, stale)
+
+	unrelated, err := NewKeyring(newKey)
+	require.NoError(t, err)
+
+	_, _, err = unrelated.Open(issued, now)
+	assert.Error(t, err, "the retired keys must not open")
+
+	_, _, err = rotated.Open(sealedPrefix+strings.Repeat("z", 8)+".00", now)
+	assert.Error(t, err)
+}
+
+func TestKeyring_Expiry(t *testing.T) {
+	keyring, err := NewKeyring(bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err)
+
+	userID, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	sealed, err := keyring.Seal(Token{UserID: userID.String(), IssuedAt: now, ExpiresAt: now.Add(time.Minute)})
+	require.NoError(t, err)
+
+	_, _, err = keyring.Open(sealed, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	_, _, err = keyring.Open(sealed, now.Add(59*time.Second))
+	assert.NoError(t, err)
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	keyring, err := NewKeyring(bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err)
+
+	userID, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	sealed, err := keyring.Seal(Token{UserID: userID.String(), IssuedAt: now, ExpiresAt: now.Add(time.Minute)})
+	require.NoError(t, err)
+
+	last := sealed[len(sealed)-1]
+	replacement := "A"
+	if last == 'A' {
+		replacement = "B"
+	}
+
+	_, _, err = keyring.Open(sealed[:len(sealed)-1]+replacement, now)
+	assert.Error(t, err, "a changed cookie must not open")
+
+	_, err = keyring.Seal(Token{UserID: "not a uuid"})
+	assert.Error(t, err)
+}
+
+func TestKeyring_UnsealedCookie(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	now := time.Now()
+
+	userID, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	enc, err := New(key)
+	require.NoError(t, err)
+
+	keyring, err := NewKeyring(bytes.Repeat([]byte{2}, 16), key)
+	require.NoError(t, err)
+
+	forged := keyID(key) + keyIDSeparator + hex.EncodeToString(bytes.Repeat([]byte{7}, 16))
+
+	for _, value := range []string{enc.Encode(userID.Bytes()), keyID(key) + keyIDSeparator + enc.Encode(userID.Bytes()), forged, "00"} {
+		opened, _, err := keyring.Open(value, now)
+		assert.Error(t, err, "the unauthenticated value %q must not be opened", value)
+		assert.Empty(t, opened.UserID)
+	}
+
+	_, err = NewKeyring([]byte("short"))
+	assert.Error(t, err, "a key of an invalid size must be rejected")
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys([]string{" " + strings.Repeat("ab", 16) + " ", "", strings.Repeat("cd", 32)})
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	_, err = ParseKeys([]string{"abcd"})
+	assert.Error(t, err)
+
+	_, err = ParseKeys([]string{"not hex"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,247 @@
+package encryptor
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// keyIDSeparator separates the key id from the encrypted value in the cookies
+const keyIDSeparator = "."
+
+// sealedPrefix marks the values sealed with AEAD: "2.<key id>.<nonce and ciphertext>",
+// the values without it are rejected, the unauthenticated single AES blocks are not accepted anymore
+const sealedPrefix = "2" + keyIDSeparator
+
+// ErrExpired - the cookie is authentic but its lifetime is over
+var ErrExpired = errors.New("the cookie is expired")
+
+// Token is the content of a user cookie
+type Token struct {
+	UserID    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Keyring encrypts with the active key and decrypts with the active or the previous keys,
+// so the keys can be rotated without losing the issued cookies
+type Keyring struct {
+	// ids - the key ids in order, the first one is active
+	ids []string
+	// keys - the AEAD of the key derived from the configured one, by key id
+	keys map[string]cipher.AEAD
+}
+
+// NewKeyring is the keyring constructor, the first key is active
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no cookie keys")
+	}
+
+	k := &Keyring{
+		keys: make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for _, value := range keys {
+		if len(value) != 16 && len(value) != 24 && len(value) != 32 {
+			return nil, fmt.Errorf("invalid cookie key: %d bytes instead of 16, 24 or 32", len(value))
+		}
+
+		// the AEAD key is derived, so the configured key is never used directly
+		mac := hmac.New(sha256.New, value)
+		mac.Write([]byte("user cookie aead"))
+
+		block, err := aes.NewCipher(mac.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(value)
+		if _, ok := k.keys[id]; ok {
+			continue
+		}
+
+		k.ids = append(k.ids, id)
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// NewRandomKeyring returns the keyring with a random key, the cookies it issues do not survive a restart
+func NewRandomKeyring() (*Keyring, error) {
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyring(key)
+}
+
+// ParseKeys decodes the hex encoded AES keys of 16, 24 or 32 bytes
+func ParseKeys(values []string) ([][]byte, error) {
+	var keys [][]byte
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie key: %w", err)
+		}
+
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, fmt.Errorf("invalid cookie key: %d bytes instead of 16, 24 or 32", len(key))
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ReadKeys reads the hex encoded keys from the file, a key per line, the lines starting with # are ignored
+func ReadKeys(filePath string) ([][]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var values []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(line, "#") {
+			values = append(values, line)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ParseKeys(values)
+}
+
+// keyID identifies the key in the cookies without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:4])
+}
+
+// Seal encrypts and authenticates the token with the active key
+func (k *Keyring) Seal(token Token) (string, error) {
+	userID, err := hex.DecodeString(strings.ReplaceAll(token.UserID, "-", ""))
+	if err != nil || len(userID) != 16 {
+		return "", errors.New("the user id is not a UUID")
+	}
+
+	id := k.ids[0]
+	aead := k.keys[id]
+
+	plaintext := make([]byte, 32)
+	copy(plaintext, userID)
+	binary.BigEndian.PutUint64(plaintext[16:], uint64(token.IssuedAt.Unix()))
+	binary.BigEndian.PutUint64(plaintext[24:], uint64(token.ExpiresAt.Unix()))
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(id))
+
+	return sealedPrefix + id + keyIDSeparator + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns the token of the cookie value and reports whether it was issued with a previous key,
+// such a cookie should be issued again. An authentic token expired at now is returned with ErrExpired.
+func (k *Keyring) Open(value string, now time.Time) (Token, bool, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return Token{}, false, errors.New("the cookie is not sealed")
+	}
+
+	id, encoded, _ := cut(strings.TrimPrefix(value, sealedPrefix), keyIDSeparator)
+
+	aead, ok := k.keys[id]
+	if !ok {
+		return Token{}, false, errors.New("the cookie is sealed with an unknown key")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return Token{}, false, errors.New("the cookie is malformed")
+	}
+
+	nonceSize := aead.NonceSize()
+
+	plaintext, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(id))
+	if err != nil || len(plaintext) != 32 {
+		return Token{}, false, errors.New("the cookie is not authentic")
+	}
+
+	token := Token{
+		UserID:    formatUUID(plaintext[:16]),
+		IssuedAt:  time.Unix(int64(binary.BigEndian.Uint64(plaintext[16:])), 0).UTC(),
+		ExpiresAt: time.Unix(int64(binary.BigEndian.Uint64(plaintext[24:])), 0).UTC(),
+	}
+
+	if !now.Before(token.ExpiresAt) {
+		return token, false, ErrExpired
+	}
+
+	return token, id != k.ids[0], nil
+}
+
+// Issue seals a token of the user valid for ttl from now
+func (k *Keyring) Issue(userID string, now time.Time, ttl time.Duration) (string, error) {
+	return k.Seal(Token{UserID: userID, IssuedAt: now, ExpiresAt: now.Add(ttl)})
+}
+
+// NeedsRenewal reports whether the opened token should be issued again: it is stale or has less than half of ttl left,
+// so the active users are never signed out
+func NeedsRenewal(token Token, stale bool, now time.Time, ttl time.Duration) bool {
+	return stale || token.ExpiresAt.Sub(now) < ttl/2
+}
+
+// formatUUID formats the 16 bytes as a UUID string
+func formatUUID(b []byte) string {
+	h := hex.EncodeToString(b)
+
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// cut slices s around the first sep
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -0,0 +1,167 @@
+// Package jobs persists the background tasks of the worker pool so they survive a restart.
+// A job is run at least once: it is removed from the store only after its handler succeeds,
+// so the handlers must be idempotent.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+// Job is a persisted task
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Payload - the arguments of the handler
+	Payload json.RawMessage `json:"payload"`
+	// RequestID - the request created the job, the resumed jobs are logged with it
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps the jobs until they are done
+type Store interface {
+	// AddJob saves the job before it is run
+	AddJob(ctx context.Context, job Job) error
+	// DoneJob removes the job, an unknown id is not an error
+	DoneJob(ctx context.Context, id string) error
+	// PendingJobs returns the jobs not done yet in the order of creation
+	PendingJobs(ctx context.Context) ([]Job, error)
+}
+
+// Handler runs the job of a kind
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// FailureHandler is told about the job of a kind failed after all the retries, err is the error of the last run
+type FailureHandler func(ctx context.Context, payload json.RawMessage, err error)
+
+// Queue saves the jobs to the store and runs them on the worker pool
+type Queue struct {
+	store    Store
+	wp       *workers.WorkerPool
+	mtx      sync.RWMutex
+	handlers map[string]Handler
+	failures map[string]FailureHandler
+}
+
+// New is the queue constructor
+func New(store Store, wp *workers.WorkerPool) *Queue {
+	return &Queue{
+		store:    store,
+		wp:       wp,
+		handlers: make(map[string]Handler),
+		failures: make(map[string]FailureHandler),
+	}
+}
+
+// Handle registers the handler of the kind, it must be idempotent
+func (q *Queue) Handle(kind string, h Handler) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.handlers[kind] = h
+}
+
+// HandleFailure registers the handler of the failed jobs of the kind, optional.
+// The failed job is still run again on the next start, so a later success may follow the failure.
+func (q *Queue) HandleFailure(kind string, h FailureHandler) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.failures[kind] = h
+}
+
+// Enqueue saves the job and pushes it to the worker pool, the job is not run if it cannot be saved.
+// If the pool does not accept the job, it is removed and the error of TryPush is returned.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	job := Job{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		Payload:   data,
+		RequestID: logger.RequestID(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = q.store.AddJob(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	err = q.wp.TryPush(ctx, q.task(job))
+	if err != nil {
+		_ = q.store.DoneJob(ctx, job.ID)
+		return err
+	}
+
+	return nil
+}
+
+// Resume pushes the jobs left by the previous run to the worker pool, it returns their number
+func (q *Queue) Resume(ctx context.Context) (int, error) {
+	pending, err := q.store.PendingJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range pending {
+		origin := ctx
+
+		if job.RequestID != "" {
+			origin = logger.NewContext(logger.WithRequestID(ctx, job.RequestID), logger.FromContext(ctx).With("request_id", job.RequestID))
+		}
+
+		q.wp.PushTask(origin, q.task(job))
+	}
+
+	return len(pending), nil
+}
+
+// task runs the handler of the job and removes the job from the store if it succeeds.
+// A job failed for good is passed to the failure handler of its kind and stays in the store to be run again on the next start.
+func (q *Queue) task(job Job) workers.Task {
+	return workers.Task{
+		Name: job.Kind,
+		Run: func(ctx context.Context) error {
+			q.mtx.RLock()
+			h, ok := q.handlers[job.Kind]
+			q.mtx.RUnlock()
+
+			if !ok {
+				return workers.Permanent(fmt.Errorf("no handler for the job %s of kind %q", job.ID, job.Kind))
+			}
+
+			err := h(ctx, job.Payload)
+			if err != nil {
+				return fmt.Errorf("job %s of kind %q: %w", job.ID, job.Kind, err)
+			}
+
+			return q.store.DoneJob(ctx, job.ID)
+		},
+		OnDone: func(ctx context.Context, outcome workers.Outcome) {
+			if outcome.Err == nil {
+				return
+			}
+
+			q.mtx.RLock()
+			h, ok := q.failures[job.Kind]
+			q.mtx.RUnlock()
+
+			if ok {
+				h(ctx, job.Payload, outcome.Err)
+			}
+		},
+	}
+}
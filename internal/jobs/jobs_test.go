@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+type memoryStore struct {
+	mtx  sync.Mutex
+	jobs []Job
+}
+
+func (s *memoryStore) AddJob(ctx context.Context, job Job) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.jobs = append(s.jobs, job)
+
+	return nil
+}
+
+func (s *memoryStore) DoneJob(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for i, job := range s.jobs {
+		if job.ID == id {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) PendingJobs(ctx context.Context) ([]Job, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Job(nil), s.jobs...), nil
+}
+
+func (s *memoryStore) len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.jobs)
+}
+
+func TestQueue_Enqueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := workers.New(ctx, 1, 4)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	store := &memoryStore{}
+	queue := New(store, wp)
+
+	done := make(chan string, 1)
+
+	queue.Handle("echo", func(ctx context.Context, payload json.RawMessage) error {
+		var s string
+		require.NoError(t, json.Unmarshal(payload, &s))
+		done <- s + " " + logger.RequestID(ctx)
+		return nil
+	})
+
+	err := queue.Enqueue(logger.WithRequestID(ctx, "req-1"), "echo", "hello")
+	require.NoError(t, err)
+
+	select {
+	case s := <-done:
+		assert.Equal(t, "hello req-1", s)
+	case <-time.After(time.Second):
+		t.Fatal("the job was not run")
+	}
+
+	assert.Eventually(t, func() bool {
+		return store.len() == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestQueue_FailedJobStaysPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := workers.New(ctx, 1, 4)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	store := &memoryStore{}
+	queue := New(store, wp)
+
+	queue.Handle("fail", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("failed")
+	})
+
+	failures := make(chan string, 2)
+
+	queue.HandleFailure("fail", func(ctx context.Context, payload json.RawMessage, err error) {
+		failures <- string(payload) + ": " + err.Error()
+	})
+
+	require.NoError(t, queue.Enqueue(ctx, "fail", "payload"))
+	require.NoError(t, queue.Enqueue(ctx, "unknown", nil))
+
+	assert.Eventually(t, func() bool {
+		return wp.Failed() == 2
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, 2, store.len())
+
+	select {
+	case failure := <-failures:
+		assert.Contains(t, failure, `"payload": job`)
+	case <-time.After(time.Second):
+		t.Fatal("the failure handler is not called")
+	}
+
+	assert.Empty(t, failures, "only the kinds with a failure handler are passed to it")
+}
+
+func TestQueue_Resume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &memoryStore{}
+	require.NoError(t, store.AddJob(ctx, Job{ID: "1", Kind: "count", Payload: json.RawMessage(`1`), RequestID: "req-1"}))
+	require.NoError(t, store.AddJob(ctx, Job{ID: "2", Kind: "count", Payload: json.RawMessage(`2`)}))
+
+	wp := workers.New(ctx, 1, 4)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	queue := New(store, wp)
+
+	var mtx sync.Mutex
+	requests := map[string]string{}
+
+	queue.Handle("count", func(ctx context.Context, payload json.RawMessage) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		requests[string(payload)] = logger.RequestID(ctx)
+		return nil
+	})
+
+	resumed, err := queue.Resume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resumed)
+
+	assert.Eventually(t, func() bool {
+		return store.len() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	assert.Equal(t, map[string]string{"1": "req-1", "2": ""}, requests)
+}
+
+func TestQueue_EnqueueQueueFull(t *testing.T) {
+	ctx := context.Background()
+
+	// the pool is not running, so its single place is taken by the first job
+	wp := workers.New(ctx, 1, 1)
+	store := &memoryStore{}
+	queue := New(store, wp)
+
+	require.NoError(t, queue.Enqueue(ctx, "kind", 1))
+	assert.ErrorIs(t, queue.Enqueue(ctx, "kind", 2), workers.ErrQueueFull)
+	assert.Equal(t, 1, store.len(), "the refused job must not be resumed")
+}
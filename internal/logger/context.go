@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const (
+	loggerKey    contextKey = "logger"
+	requestIDKey contextKey = "requestID"
+)
+
+// defaultLogger - the logger of the contexts without one
+var defaultLogger atomic.Value
+
+func init() {
+	l, _ := New(os.Stderr, FormatJSON, LevelInfo)
+	defaultLogger.Store(l)
+}
+
+// SetDefault replaces the logger returned for the contexts without one
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+// Default returns the logger of the contexts without one
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+// NewContext returns the context carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger of the context or the default one
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
+
+// WithRequestID returns the context carrying the request id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the request id of the context or an empty string
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// Inherit returns ctx carrying the logger and the request id of origin,
+// it lets the background work outlive the request and still be traced to it
+func Inherit(ctx, origin context.Context) context.Context {
+	if origin == nil {
+		return ctx
+	}
+
+	if l, ok := origin.Value(loggerKey).(*Logger); ok {
+		ctx = NewContext(ctx, l)
+	}
+
+	if requestID := RequestID(origin); requestID != "" {
+		ctx = WithRequestID(ctx, requestID)
+	}
+
+	return ctx
+}
+
+// RequestIDHeader - the HTTP header and, in lower case, the gRPC metadata key of the request id
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength - longer ids from the clients are replaced
+const maxRequestIDLength = 128
+
+// AcceptRequestID returns the request id of the client if it is safe to log, otherwise a new one
+func AcceptRequestID(requestID string) string {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+
+	for _, r := range requestID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:/+=", r)) {
+			return uuid.NewString()
+		}
+	}
+
+	return requestID
+}
@@ -0,0 +1,230 @@
+// Package logger provides a leveled structured logger writing JSON or logfmt lines
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a log record
+type Level int
+
+// Levels in the order of severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(l))
+}
+
+// ParseLevel returns the level by its name
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Formats of the log lines.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Logger writes the records at or above its level with the message and the key-value pairs.
+// Loggers derived by With share the writer.
+type Logger struct {
+	mtx    *sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+	fields []interface{}
+}
+
+// New is the logger constructor
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatJSON && format != FormatLogfmt {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return &Logger{
+		mtx:    &sync.Mutex{},
+		w:      w,
+		format: format,
+		level:  level,
+	}, nil
+}
+
+// Nop returns the logger discarding all the records
+func Nop() *Logger {
+	return &Logger{
+		mtx:    &sync.Mutex{},
+		w:      ioutil.Discard,
+		format: FormatLogfmt,
+		level:  LevelError + 1,
+	}
+}
+
+// With returns the logger adding the key-value pairs to every record
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append(make([]interface{}, 0, len(l.fields)+len(keyvals)), l.fields...), keyvals...)
+
+	return &child
+}
+
+// Enabled reports whether the records of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug writes the record with the debug level
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info writes the record with the info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Warn writes the record with the warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+// Error writes the record with the error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	record := make([]interface{}, 0, 6+len(l.fields)+len(keyvals))
+	record = append(record, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	record = append(record, l.fields...)
+	record = append(record, keyvals...)
+
+	if len(record)%2 != 0 {
+		record = append(record, "(MISSING)")
+	}
+
+	var buf bytes.Buffer
+
+	if l.format == FormatJSON {
+		writeJSON(&buf, record)
+	} else {
+		writeLogfmt(&buf, record)
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	_, _ = l.w.Write(buf.Bytes())
+}
+
+// value converts the errors and the stringers to strings
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	default:
+		return v
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, record []interface{}) {
+	buf.WriteByte('{')
+
+	for i := 0; i < len(record); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(fmt.Sprint(record[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		v, err := json.Marshal(value(record[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(record[i+1]))
+		}
+
+		buf.Write(v)
+	}
+
+	buf.WriteString("}\n")
+}
+
+func writeLogfmt(buf *bytes.Buffer, record []interface{}) {
+	for i := 0; i < len(record); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(fmt.Sprint(record[i]))
+		buf.WriteByte('=')
+
+		v := value(record[i+1])
+		if v == nil {
+			buf.WriteString("null")
+			continue
+		}
+
+		s := fmt.Sprint(v)
+		if needsQuoting(s) {
+			s = strconv.Quote(s)
+		}
+
+		buf.WriteString(s)
+	}
+
+	buf.WriteByte('\n')
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, err := New(&buf, FormatJSON, LevelInfo)
+	require.NoError(t, err)
+
+	l.Debug("hidden")
+	l.With("request_id", "abc").Error("failed", "error", errors.New("boom"), "duration", time.Second, "count", 2)
+
+	var record map[string]interface{}
+
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "error", record["level"])
+	assert.Equal(t, "failed", record["msg"])
+	assert.Equal(t, "abc", record["request_id"])
+	assert.Equal(t, "boom", record["error"])
+	assert.Equal(t, "1s", record["duration"])
+	assert.Equal(t, float64(2), record["count"])
+	assert.NotEmpty(t, record["time"])
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"), "the debug record must be skipped")
+}
+
+func TestLogger_Logfmt(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, err := New(&buf, FormatLogfmt, LevelDebug)
+	require.NoError(t, err)
+
+	l.Debug("task done", "path", "/api/user/urls", "empty", "", "odd")
+
+	line := buf.String()
+	assert.Contains(t, line, ` level=debug msg="task done" path=/api/user/urls empty="" odd=(MISSING)`+"\n")
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+
+	_, err = New(&bytes.Buffer{}, "xml", LevelInfo)
+	assert.Error(t, err)
+}
+
+func TestInherit(t *testing.T) {
+	l := Nop().With("request_id", "abc")
+	origin := NewContext(WithRequestID(context.Background(), "abc"), l)
+
+	ctx := Inherit(context.Background(), origin)
+
+	assert.Equal(t, "abc", RequestID(ctx))
+	assert.Same(t, l, FromContext(ctx))
+	assert.Same(t, Default(), FromContext(context.Background()))
+}
+
+func TestAcceptRequestID(t *testing.T) {
+	assert.Equal(t, "req-1.2:3", AcceptRequestID("req-1.2:3"))
+
+	for _, requestID := range []string{"", "with space", "new\nline", strings.Repeat("a", 129)} {
+		generated := AcceptRequestID(requestID)
+		assert.NotEqual(t, requestID, generated)
+		assert.Len(t, generated, 36)
+	}
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+// Metrics contains the instruments of the shortener
+type Metrics struct {
+	registry     *Registry
+	httpRequests *CounterVec
+	httpDuration *HistogramVec
+	grpcRequests *CounterVec
+	grpcDuration *HistogramVec
+	repoDuration *HistogramVec
+	redirects    *CounterVec
+}
+
+// New registers the shortener metrics, the worker pool is observed when the metrics are scraped
+func New(wp *workers.WorkerPool) *Metrics {
+	r := NewRegistry()
+
+	m := &Metrics{
+		registry:     r,
+		httpRequests: r.NewCounter("shortener_http_requests_total", "Number of HTTP requests by route and status.", "method", "route", "code"),
+		httpDuration: r.NewHistogram("shortener_http_request_duration_seconds", "Latency of HTTP requests by route.", DefaultBuckets, "method", "route"),
+		grpcRequests: r.NewCounter("shortener_grpc_requests_total", "Number of gRPC requests by method and status code.", "method", "code"),
+		grpcDuration: r.NewHistogram("shortener_grpc_request_duration_seconds", "Latency of gRPC requests by method.", DefaultBuckets, "method"),
+		repoDuration: r.NewHistogram("shortener_repository_call_duration_seconds", "Latency of the storage calls by backend and method.", DefaultBuckets, "backend", "method"),
+		redirects:    r.NewCounter("shortener_redirects_total", "Number of successful redirects."),
+	}
+
+	if wp != nil {
+		r.NewGaugeFunc("shortener_workers_queue_depth", "Number of tasks waiting for a worker.", func() float64 {
+			return float64(wp.QueueLen())
+		})
+		r.NewGaugeFunc("shortener_workers_in_flight", "Number of tasks being run.", func() float64 {
+			return float64(wp.InFlight())
+		})
+		r.NewCounterFunc("shortener_workers_failures_total", "Number of tasks failed after all the attempts.", func() float64 {
+			return float64(wp.Failed())
+		})
+		r.NewCounterFunc("shortener_workers_retries_total", "Number of task retries.", func() float64 {
+			return float64(wp.Retried())
+		})
+	}
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return m.registry
+}
+
+// Middleware measures the requests by the chi route pattern so the ids do not end up in the labels,
+// the unmatched requests share the pattern of the router that rejected them
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+
+		m.httpRequests.Inc(r.Method, route, strconv.Itoa(code))
+		m.httpDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// UnaryServerInterceptor measures the gRPC requests by the full method name
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		m.grpcRequests.Inc(info.FullMethod, status.Code(err).String())
+		m.grpcDuration.Observe(time.Since(start).Seconds(), info.FullMethod)
+
+		return resp, err
+	}
+}
+
+// Redirects counts the successful redirects passed to the click recorder, next may be nil
+func (m *Metrics) Redirects(next services.ClickRecorder) services.ClickRecorder {
+	return &redirects{counter: m.redirects, next: next}
+}
+
+type redirects struct {
+	counter *CounterVec
+	next    services.ClickRecorder
+}
+
+func (r *redirects) Record(click models.Click) {
+	r.counter.Inc()
+
+	if r.next != nil {
+		r.next.Record(click)
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/memory"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	response := w.Result()
+
+	defer response.Body.Close()
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", response.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestRegistry_Format(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("test_total", "Test counter.", "path")
+	c.Inc(`/a"b`)
+	c.Add(2, "/c")
+
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	r.NewGaugeFunc("test_gauge", "Test gauge.", func() float64 { return 3 })
+
+	var sb strings.Builder
+
+	_, err := r.WriteTo(&sb)
+	require.NoError(t, err)
+
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{path="/a\"b"} 1
+test_total{path="/c"} 2
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 3
+`, sb.String())
+
+	assert.Panics(t, func() { c.Inc() }, "the label values must match the labels")
+}
+
+func TestMetrics_Middleware(t *testing.T) {
+	m := New(nil)
+
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Route("/", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		})
+	})
+
+	for _, target := range []string{"/abc", "/def", "/a/b"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	body := scrape(t, m)
+
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/{id}",code="307"} 2`)
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/*",code="404"} 1`)
+	assert.Contains(t, body, `shortener_http_request_duration_seconds_count{method="GET",route="/{id}"} 2`)
+}
+
+func TestMetrics_UnaryServerInterceptor(t *testing.T) {
+	m := New(nil)
+	interceptor := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.URL/GetURLStats"}
+
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.InvalidArgument, "invalid")
+	})
+	require.Error(t, err)
+
+	body := scrape(t, m)
+
+	assert.Contains(t, body, `shortener_grpc_requests_total{method="/pb.URL/GetURLStats",code="OK"} 1`)
+	assert.Contains(t, body, `shortener_grpc_requests_total{method="/pb.URL/GetURLStats",code="InvalidArgument"} 1`)
+	assert.Contains(t, body, `shortener_grpc_request_duration_seconds_count{method="/pb.URL/GetURLStats"} 2`)
+}
+
+func TestMetrics_RepositoryAndWorkers(t *testing.T) {
+	ctx := context.Background()
+	wp := workers.New(ctx, 1, 10)
+
+	wp.Push(ctx, func(ctx context.Context) error { return errors.New("failed") })
+
+	m := New(wp)
+	repo := m.Repository(memory.NewMemoryRepository("http://localhost:8080"), "memory")
+
+	require.NoError(t, repo.AddURL(ctx, "https://go.dev", "a", "user", models.Expiration{}))
+
+	_, err := repo.GetURL(ctx, "a")
+	require.NoError(t, err)
+
+	m.Redirects(nil).Record(models.Click{ShortURL: "a"})
+
+	body := scrape(t, m)
+
+	assert.Contains(t, body, `shortener_repository_call_duration_seconds_count{backend="memory",method="AddURL"} 1`)
+	assert.Contains(t, body, `shortener_repository_call_duration_seconds_count{backend="memory",method="GetURL"} 1`)
+	assert.Contains(t, body, "shortener_redirects_total 1\n")
+	assert.Contains(t, body, "shortener_workers_queue_depth 1\n")
+	assert.Contains(t, body, "shortener_workers_in_flight 0\n")
+	assert.Contains(t, body, "shortener_workers_failures_total 0\n")
+	assert.Contains(t, body, "shortener_workers_retries_total 0\n")
+}
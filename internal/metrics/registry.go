@@ -0,0 +1,305 @@
+// Package metrics exposes the service telemetry in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets - upper bounds of the latency histograms in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is a family of series written under a single HELP and TYPE
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry keeps the metrics in the order of registration and writes them in the text exposition format
+type Registry struct {
+	mtx     sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry is the registry constructor
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all the metrics
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mtx.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mtx.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	for _, m := range metrics {
+		m.write(cw)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP serves the metrics to the scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, _ = r.WriteTo(w)
+}
+
+// CounterVec is a counter partitioned by the label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mtx    sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounter registers a counter with the labels
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*counterSeries),
+	}
+
+	r.register(c)
+
+	return c
+}
+
+// Inc adds one to the series of the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the non-negative value to the series of the label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	checkLabels(c.name, c.labels, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+
+	s.value += value
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by the label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mtx     sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	// counts - number of observations per bucket, not cumulative
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the sorted bucket upper bounds and the labels
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+
+	r.register(h)
+
+	return h
+}
+
+// Observe adds the value to the series of the label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	checkLabels(h.name, h.labels, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	labels := append(append([]string(nil), h.labels...), "le")
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+
+		var cumulative uint64
+
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(append([]string(nil), s.labelValues...), formatValue(bound))), cumulative)
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(append([]string(nil), s.labelValues...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues), s.count)
+	}
+}
+
+// funcMetric is a single series read when the metrics are written
+type funcMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge reading its value from the function
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", value: value})
+}
+
+// NewCounterFunc registers a counter reading its value from the function
+func (r *Registry) NewCounterFunc(name, help string, value func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", value: value})
+}
+
+func (f *funcMetric) write(w io.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.value()))
+}
+
+func checkLabels(name string, labels, values []string) {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", name, len(labels), len(values)))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labels))
+
+	for i, label := range labels {
+		pairs[i] = label + `="` + labelValueReplacer.Replace(values[i]) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// countingWriter remembers the first error so the metrics are written without checking every line
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+
+	return n, err
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+)
+
+// Repository measures the latency of every call of the wrapped storage
+func (m *Metrics) Repository(repo services.RepositoryInterface, backend string) services.RepositoryInterface {
+	return &repository{
+		repo:     repo,
+		backend:  backend,
+		duration: m.repoDuration,
+	}
+}
+
+type repository struct {
+	repo     services.RepositoryInterface
+	backend  string
+	duration *HistogramVec
+}
+
+func (r *repository) observe(method string, start time.Time) {
+	r.duration.Observe(time.Since(start).Seconds(), r.backend, method)
+}
+
+func (r *repository) AddURL(ctx context.Context, longURL models.LongURL, shortURL models.ShortURL, user models.UserID, expiration models.Expiration) error {
+	defer r.observe("AddURL", time.Now())
+
+	return r.repo.AddURL(ctx, longURL, shortURL, user, expiration)
+}
+
+func (r *repository) AddURLs(ctx context.Context, user models.UserID, urls ...handlers.RequestGetURLs) ([]handlers.ResponseGetURLs, error) {
+	defer r.observe("AddURLs", time.Now())
+
+	return r.repo.AddURLs(ctx, user, urls...)
+}
+
+func (r *repository) DeleteURLs(ctx context.Context, user models.UserID, urls ...string) error {
+	defer r.observe("DeleteURLs", time.Now())
+
+	return r.repo.DeleteURLs(ctx, user, urls...)
+}
+
+func (r *repository) GetURL(ctx context.Context, shortURL models.ShortURL) (models.ShortURL, error) {
+	defer r.observe("GetURL", time.Now())
+
+	return r.repo.GetURL(ctx, shortURL)
+}
+
+func (r *repository) VisitURL(ctx context.Context, shortURL models.ShortURL) (models.LongURL, error) {
+	defer r.observe("VisitURL", time.Now())
+
+	return r.repo.VisitURL(ctx, shortURL)
+}
+
+func (r *repository) GetShortURL(ctx context.Context, longURL models.LongURL) (models.ShortURL, error) {
+	defer r.observe("GetShortURL", time.Now())
+
+	return r.repo.GetShortURL(ctx, longURL)
+}
+
+func (r *repository) GetUserURLs(ctx context.Context, user models.UserID) ([]handlers.ResponseGetURL, error) {
+	defer r.observe("GetUserURLs", time.Now())
+
+	return r.repo.GetUserURLs(ctx, user)
+}
+
+func (r *repository) GetStates(ctx context.Context) (handlers.ResponseStates, error) {
+	defer r.observe("GetStates", time.Now())
+
+	return r.repo.GetStates(ctx)
+}
+
+func (r *repository) BlockURLs(ctx context.Context, blocked func(longURL models.LongURL) bool) (int, error) {
+	defer r.observe("BlockURLs", time.Now())
+
+	return r.repo.BlockURLs(ctx, blocked)
+}
+
+func (r *repository) SweepURLs(ctx context.Context, now time.Time, archive bool) (int, error) {
+	defer r.observe("SweepURLs", time.Now())
+
+	return r.repo.SweepURLs(ctx, now, archive)
+}
+
+func (r *repository) AddClicks(ctx context.Context, clicks ...models.Click) error {
+	defer r.observe("AddClicks", time.Now())
+
+	return r.repo.AddClicks(ctx, clicks...)
+}
+
+func (r *repository) GetClicks(ctx context.Context, shortURL models.ShortURL) ([]models.Click, error) {
+	defer r.observe("GetClicks", time.Now())
+
+	return r.repo.GetClicks(ctx, shortURL)
+}
+
+func (r *repository) GetOwner(ctx context.Context, shortURL models.ShortURL) (models.UserID, error) {
+	defer r.observe("GetOwner", time.Now())
+
+	return r.repo.GetOwner(ctx, shortURL)
+}
+
+func (r *repository) GetShortURLs(ctx context.Context) ([]models.ShortURL, error) {
+	defer r.observe("GetShortURLs", time.Now())
+
+	return r.repo.GetShortURLs(ctx)
+}
+
+func (r *repository) AddDeleteJob(ctx context.Context, user models.UserID, job handlers.ResponseDeleteJob) error {
+	defer r.observe("AddDeleteJob", time.Now())
+
+	return r.repo.AddDeleteJob(ctx, user, job)
+}
+
+func (r *repository) UpdateDeleteJob(ctx context.Context, id string, update func(job *handlers.ResponseDeleteJob)) error {
+	defer r.observe("UpdateDeleteJob", time.Now())
+
+	return r.repo.UpdateDeleteJob(ctx, id, update)
+}
+
+func (r *repository) GetDeleteJob(ctx context.Context, id string) (models.UserID, handlers.ResponseDeleteJob, error) {
+	defer r.observe("GetDeleteJob", time.Now())
+
+	return r.repo.GetDeleteJob(ctx, id)
+}
+
+func (r *repository) RemoveDeleteJob(ctx context.Context, id string) error {
+	defer r.observe("RemoveDeleteJob", time.Now())
+
+	return r.repo.RemoveDeleteJob(ctx, id)
+}
+
+func (r *repository) PurgeDeleteJobs(ctx context.Context, completedBefore, createdBefore time.Time) (int, error) {
+	defer r.observe("PurgeDeleteJobs", time.Now())
+
+	return r.repo.PurgeDeleteJobs(ctx, completedBefore, createdBefore)
+}
+
+func (r *repository) AddAPIKey(ctx context.Context, key models.APIKey) error {
+	defer r.observe("AddAPIKey", time.Now())
+
+	return r.repo.AddAPIKey(ctx, key)
+}
+
+func (r *repository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	defer r.observe("GetAPIKey", time.Now())
+
+	return r.repo.GetAPIKey(ctx, hash)
+}
+
+func (r *repository) GetUserAPIKeys(ctx context.Context, user models.UserID) ([]models.APIKey, error) {
+	defer r.observe("GetUserAPIKeys", time.Now())
+
+	return r.repo.GetUserAPIKeys(ctx, user)
+}
+
+func (r *repository) DeleteAPIKey(ctx context.Context, user models.UserID, id string) error {
+	defer r.observe("DeleteAPIKey", time.Now())
+
+	return r.repo.DeleteAPIKey(ctx, user, id)
+}
+
+func (r *repository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	defer r.observe("TouchAPIKey", time.Now())
+
+	return r.repo.TouchAPIKey(ctx, id, usedAt)
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	defer r.observe("Ping", time.Now())
+
+	return r.repo.Ping(ctx)
+}
@@ -0,0 +1,17 @@
+// Package models for working with the API key entity
+package models
+
+import "time"
+
+// APIKey is a key of a user for the programmatic access, only the hash of the secret is stored
+type APIKey struct {
+	ID     string   `json:"id"`
+	User   UserID   `json:"user"`
+	Name   string   `json:"name,omitempty"`
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+	// CreatedAt - the moment the key was minted
+	CreatedAt time.Time `json:"created_at"`
+	// LastUsedAt - the last authenticated request, updated at most once a minute
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
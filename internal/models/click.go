@@ -0,0 +1,13 @@
+// Package models for working with the click entity
+package models
+
+import "time"
+
+// Click is a redirect through a short url
+type Click struct {
+	ShortURL  ShortURL  `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
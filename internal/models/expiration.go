@@ -0,0 +1,26 @@
+// Package models for working with the link expiration
+package models
+
+import "time"
+
+// Expiration limits the lifetime of a link, zero values mean no limit
+type Expiration struct {
+	// ExpiresAt - the moment the link stops working
+	ExpiresAt time.Time
+	// MaxClicks - the number of redirects allowed
+	MaxClicks int
+}
+
+// IsZero reports whether the link lives forever
+func (e Expiration) IsZero() bool {
+	return e.ExpiresAt.IsZero() && e.MaxClicks == 0
+}
+
+// Expired reports whether the link followed clicks times is expired at now
+func (e Expiration) Expired(now time.Time, clicks int) bool {
+	if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+		return true
+	}
+
+	return e.MaxClicks > 0 && clicks >= e.MaxClicks
+}
@@ -30,6 +30,8 @@ type URLClient interface {
 	DeleteBatch(ctx context.Context, in *DeleteBatchRequest, opts ...grpc.CallOption) (*DeleteBatchResponse, error)
 	GetStates(ctx context.Context, in *GetStatesRequest, opts ...grpc.CallOption) (*GetStatesResponse, error)
 	CreateBatch(ctx context.Context, in *CreateBatchRequest, opts ...grpc.CallOption) (*CreateBatchResponse, error)
+	GetURLStats(ctx context.Context, in *GetURLStatsRequest, opts ...grpc.CallOption) (*GetURLStatsResponse, error)
+	GetDeleteJob(ctx context.Context, in *GetDeleteJobRequest, opts ...grpc.CallOption) (*GetDeleteJobResponse, error)
 }
 
 type uRLClient struct {
@@ -103,6 +105,24 @@ func (c *uRLClient) CreateBatch(ctx context.Context, in *CreateBatchRequest, opt
 	return out, nil
 }
 
+func (c *uRLClient) GetURLStats(ctx context.Context, in *GetURLStatsRequest, opts ...grpc.CallOption) (*GetURLStatsResponse, error) {
+	out := new(GetURLStatsResponse)
+	err := c.cc.Invoke(ctx, "/urls.URL/GetURLStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLClient) GetDeleteJob(ctx context.Context, in *GetDeleteJobRequest, opts ...grpc.CallOption) (*GetDeleteJobResponse, error) {
+	out := new(GetDeleteJobResponse)
+	err := c.cc.Invoke(ctx, "/urls.URL/GetDeleteJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // URLServer is the server API for URL service.
 // All implementations must embed UnimplementedURLServer
 // for forward compatibility
@@ -114,6 +134,8 @@ type URLServer interface {
 	DeleteBatch(context.Context, *DeleteBatchRequest) (*DeleteBatchResponse, error)
 	GetStates(context.Context, *GetStatesRequest) (*GetStatesResponse, error)
 	CreateBatch(context.Context, *CreateBatchRequest) (*CreateBatchResponse, error)
+	GetURLStats(context.Context, *GetURLStatsRequest) (*GetURLStatsResponse, error)
+	GetDeleteJob(context.Context, *GetDeleteJobRequest) (*GetDeleteJobResponse, error)
 	mustEmbedUnimplementedURLServer()
 }
 
@@ -142,6 +164,12 @@ func (UnimplementedURLServer) GetStates(context.Context, *GetStatesRequest) (*Ge
 func (UnimplementedURLServer) CreateBatch(context.Context, *CreateBatchRequest) (*CreateBatchResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateBatch not implemented")
 }
+func (UnimplementedURLServer) GetURLStats(context.Context, *GetURLStatsRequest) (*GetURLStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLStats not implemented")
+}
+func (UnimplementedURLServer) GetDeleteJob(context.Context, *GetDeleteJobRequest) (*GetDeleteJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeleteJob not implemented")
+}
 func (UnimplementedURLServer) mustEmbedUnimplementedURLServer() {}
 
 // UnsafeURLServer may be embedded to opt out of forward compatibility for this service.
@@ -281,6 +309,42 @@ func _URL_CreateBatch_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _URL_GetURLStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetURLStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLServer).GetURLStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/urls.URL/GetURLStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLServer).GetURLStats(ctx, req.(*GetURLStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URL_GetDeleteJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeleteJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLServer).GetDeleteJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/urls.URL/GetDeleteJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLServer).GetDeleteJob(ctx, req.(*GetDeleteJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // URL_ServiceDesc is the grpc.ServiceDesc for URL service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -316,6 +380,14 @@ var URL_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CreateBatch",
 			Handler:    _URL_CreateBatch_Handler,
 		},
+		{
+			MethodName: "GetURLStats",
+			Handler:    _URL_GetURLStats_Handler,
+		},
+		{
+			MethodName: "GetDeleteJob",
+			Handler:    _URL_GetDeleteJob_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/proto/urls.proto",
@@ -0,0 +1,384 @@
+// Package policy decides which destinations can be shortened
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// regexpPrefix marks a rule with a regular expression matched against the whole host,
+// the expression is anchored at both ends implicitly
+const regexpPrefix = "regexp:"
+
+// Rules is the content of the policy file.
+// A rule is an exact host ("go.dev"), a wildcard subdomain ("*.go.dev" matches "pkg.go.dev"
+// but not "go.dev") or a regular expression ("regexp:go[0-9]*\\.dev" matches "go118.dev" but not "notgo.dev.example").
+type Rules struct {
+	// Allow - if not empty only matching hosts can be shortened
+	Allow []string `json:"allow"`
+	// Deny - matching hosts can not be shortened, deny rules take precedence over allow rules
+	Deny []string `json:"deny"`
+}
+
+type matcher struct {
+	exact    map[string]bool
+	suffixes []string
+	patterns []*regexp.Regexp
+}
+
+func newMatcher(rules []string) (*matcher, error) {
+	m := &matcher{
+		exact: map[string]bool{},
+	}
+
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+
+		switch {
+		case rule == "":
+			continue
+		case strings.HasPrefix(rule, regexpPrefix):
+			pattern, err := regexp.Compile(`^(?:` + strings.TrimPrefix(rule, regexpPrefix) + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule %q: %w", rule, err)
+			}
+
+			m.patterns = append(m.patterns, pattern)
+		case strings.HasPrefix(rule, "*."):
+			m.suffixes = append(m.suffixes, strings.ToLower(rule[1:]))
+		default:
+			m.exact[strings.ToLower(rule)] = true
+		}
+	}
+
+	return m, nil
+}
+
+func (m *matcher) empty() bool {
+	return len(m.exact) == 0 && len(m.suffixes) == 0 && len(m.patterns) == 0
+}
+
+func (m *matcher) match(host string) bool {
+	if m.exact[host] {
+		return true
+	}
+
+	for _, suffix := range m.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type ruleSet struct {
+	allow *matcher
+	deny  *matcher
+}
+
+// Policy checks the destination hosts against the allow and deny rules and refuses private addresses
+type Policy struct {
+	// filePath - path to the rules file, empty means no rules
+	filePath string
+	// resolve - look up the host names and refuse those resolving to private addresses
+	resolve bool
+	// lookup - resolves host names, replaced in tests
+	lookup  func(ctx context.Context, host string) ([]net.IPAddr, error)
+	rules   *ruleSet
+	modTime time.Time
+	mtx     sync.RWMutex
+}
+
+// New is the policy constructor, the rules file is read immediately
+func New(filePath string, resolve bool) (*Policy, error) {
+	p := &Policy{
+		filePath: filePath,
+		resolve:  resolve,
+		lookup:   net.DefaultResolver.LookupIPAddr,
+		rules:    &ruleSet{allow: &matcher{}, deny: &matcher{}},
+	}
+
+	if filePath == "" {
+		return p, nil
+	}
+
+	_, err := p.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Reload reads the rules file if it was modified since the last reading and reports whether the rules changed
+func (p *Policy) Reload() (bool, error) {
+	if p.filePath == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(p.filePath)
+	if err != nil {
+		return false, err
+	}
+
+	p.mtx.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.mtx.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(p.filePath)
+	if err != nil {
+		return false, err
+	}
+
+	var rules Rules
+
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return false, fmt.Errorf("invalid policy file %s: %w", p.filePath, err)
+	}
+
+	allow, err := newMatcher(rules.Allow)
+	if err != nil {
+		return false, err
+	}
+
+	deny, err := newMatcher(rules.Deny)
+	if err != nil {
+		return false, err
+	}
+
+	p.mtx.Lock()
+	p.rules = &ruleSet{allow: allow, deny: deny}
+	p.modTime = info.ModTime()
+	p.mtx.Unlock()
+
+	return true, nil
+}
+
+// Watch reloads the rules every interval until ctx is done, onReload is called after every change
+func (p *Policy) Watch(ctx context.Context, interval time.Duration, onReload func()) {
+	if p.filePath == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := p.Reload()
+			if err != nil {
+				logger.FromContext(ctx).Error("unable to reload the policy", "file", p.filePath, "error", err)
+				continue
+			}
+
+			if changed {
+				logger.FromContext(ctx).Info("policy reloaded", "file", p.filePath)
+
+				if onReload != nil {
+					onReload()
+				}
+			}
+		}
+	}
+}
+
+// Allowed reports whether the rules permit longURL, host names are not resolved
+func (p *Policy) Allowed(longURL string) bool {
+	return p.check(longURL) == ""
+}
+
+// Check returns a validation error for the field if longURL can not be shortened
+func (p *Policy) Check(ctx context.Context, field, longURL string) error {
+	if reason := p.check(longURL); reason != "" {
+		return customerrors.InvalidInput(field, "destination_denied", reason)
+	}
+
+	if !p.resolve {
+		return nil
+	}
+
+	u, err := url.Parse(longURL)
+	if err != nil || hostIP(strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")) != nil {
+		return nil
+	}
+
+	addrs, err := p.lookup(ctx, u.Hostname())
+	if err != nil {
+		return customerrors.InvalidInput(field, "destination_denied", "the host can not be resolved")
+	}
+
+	for _, addr := range addrs {
+		if isPrivate(addr.IP) {
+			return customerrors.InvalidInput(field, "destination_denied", "the host resolves to a private address")
+		}
+	}
+
+	return nil
+}
+
+// check returns the reason why longURL is denied or an empty string
+func (p *Policy) check(longURL string) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return "the url cannot be parsed"
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "private destinations are not allowed"
+	}
+
+	if ip := hostIP(host); ip != nil && isPrivate(ip) {
+		return "private destinations are not allowed"
+	}
+
+	p.mtx.RLock()
+	rules := p.rules
+	p.mtx.RUnlock()
+
+	if rules.deny.match(host) {
+		return fmt.Sprintf("the host %q is denied", host)
+	}
+
+	if !rules.allow.empty() && !rules.allow.match(host) {
+		return fmt.Sprintf("the host %q is not allowed", host)
+	}
+
+	return ""
+}
+
+// hostIP returns the address of the numeric host or nil for a host name.
+// Besides the standard forms it accepts the IPv4 forms the browsers accept too, e.g. "2130706433",
+// "0x7f000001", "0177.0.0.1" or "127.1", so they can not be used to pass the private addresses.
+func hostIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+
+	var value uint64
+
+	for i, part := range parts {
+		n, ok := parseIPv4Number(part)
+		if !ok {
+			return nil
+		}
+
+		// the last part fills the rest of the address, the others are a byte each
+		bits := uint(8)
+		if i == len(parts)-1 {
+			bits = uint(8 * (4 - i))
+		}
+
+		if n >= 1<<bits {
+			return nil
+		}
+
+		value = value<<bits | n
+	}
+
+	return net.IPv4(byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+// parseIPv4Number parses a part of the numeric host: decimal, octal with the leading zero or hexadecimal with 0x
+func parseIPv4Number(part string) (uint64, bool) {
+	base := 10
+
+	switch {
+	case part == "":
+		return 0, false
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		part, base = part[2:], 16
+
+		if part == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+
+	n, err := strconv.ParseUint(part, base, 32)
+
+	return n, err == nil
+}
+
+// privateNetworks - the address ranges not reachable from the internet
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+
+	return networks
+}()
+
+// embeddingNetworks - the IPv6 ranges carrying an IPv4 address in the last 4 bytes: IPv4-compatible and NAT64
+var embeddingNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+
+	for _, cidr := range []string{"::/96", "64:ff9b::/96"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+
+	return networks
+}()
+
+// isPrivate reports whether ip is not reachable from the internet, the IPv4 addresses embedded into IPv6 ones included
+func isPrivate(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+
+	if ip.To4() == nil && len(ip) == net.IPv6len {
+		for _, network := range embeddingNetworks {
+			if network.Contains(ip) {
+				return isPrivate(net.IPv4(ip[12], ip[13], ip[14], ip[15]))
+			}
+		}
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
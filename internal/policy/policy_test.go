@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+)
+
+func writeRules(t *testing.T, filePath, rules string, modTime time.Time) {
+	t.Helper()
+
+	require.NoError(t, ioutil.WriteFile(filePath, []byte(rules), 0644))
+	require.NoError(t, os.Chtimes(filePath, modTime, modTime))
+}
+
+func TestPolicy_Allowed(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "policy.json")
+	writeRules(t, filePath, `{
+		"allow": ["go.dev", "*.go.dev", "*.golang.org", "regexp:^go[0-9]+\\.example$"],
+		"deny": ["play.golang.org"]
+	}`, time.Now())
+
+	p, err := New(filePath, false)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		longURL string
+		want    bool
+	}{
+		{name: "exact", longURL: "https://go.dev/doc", want: true},
+		{name: "exact ignores case", longURL: "https://GO.dev", want: true},
+		{name: "wildcard subdomain", longURL: "https://pkg.go.dev", want: true},
+		{name: "wildcard does not match the domain", longURL: "https://golang.org", want: false},
+		{name: "regexp", longURL: "https://go118.example", want: true},
+		{name: "regexp matches the whole host", longURL: "https://go.example", want: false},
+		{name: "deny takes precedence", longURL: "https://play.golang.org", want: false},
+		{name: "not allowed", longURL: "https://example.com", want: false},
+		{name: "loopback", longURL: "http://127.0.0.1:8080", want: false},
+		{name: "localhost", longURL: "http://localhost", want: false},
+		{name: "private", longURL: "http://10.1.2.3", want: false},
+		{name: "ipv6 loopback", longURL: "http://[::1]/", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Allowed(tt.longURL))
+		})
+	}
+}
+
+func TestPolicy_RegexpAnchored(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "policy.json")
+	writeRules(t, filePath, `{"deny": ["regexp:evil\\.com"]}`, time.Now())
+
+	p, err := New(filePath, false)
+	require.NoError(t, err)
+
+	assert.False(t, p.Allowed("https://evil.com"))
+	assert.True(t, p.Allowed("https://notevil.com.example"), "the deny rule must not match a part of the host")
+
+	writeRules(t, filePath, `{"allow": ["regexp:go\\.dev|pkg\\.go\\.dev"]}`, time.Now().Add(time.Second))
+
+	_, err = p.Reload()
+	require.NoError(t, err)
+
+	assert.True(t, p.Allowed("https://pkg.go.dev"), "the alternation is anchored as a whole")
+	assert.False(t, p.Allowed("https://go.dev.evil.example"), "the allow rule must not match a part of the host")
+}
+
+func TestPolicy_PrivateHosts(t *testing.T) {
+	p, err := New("", false)
+	require.NoError(t, err)
+
+	for _, longURL := range []string{
+		"http://localhost./",
+		"http://LOCALHOST:8080",
+		"http://0.0.0.0",
+		"http://2130706433",
+		"http://0x7f000001",
+		"http://0177.0.0.1",
+		"http://127.1",
+		"http://0xa.0.0.1",
+		"http://10.1",
+		"http://0300.0250.1.1",
+		"http://[::ffff:127.0.0.1]",
+		"http://[::ffff:a00:1]",
+		"http://[::10.0.0.1]",
+		"http://[64:ff9b::c0a8:101]",
+	} {
+		assert.False(t, p.Allowed(longURL), longURL)
+	}
+
+	for _, longURL := range []string{
+		"http://93.184.216.34",
+		"http://1572395042",
+		"http://[2606:2800:220:1:248:1893:25c8:1946]",
+		"http://[64:ff9b::5db8:d822]",
+		"https://0x7f000001.example",
+		"https://127.1.example",
+	} {
+		assert.True(t, p.Allowed(longURL), longURL)
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	p, err := New("", true)
+	require.NoError(t, err)
+
+	p.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "internal.example":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("192.168.1.1")}}, nil
+		case "public.example":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		}
+
+		return nil, errors.New("no such host")
+	}
+
+	ctx := context.Background()
+
+	assert.NoError(t, p.Check(ctx, "url", "https://public.example"))
+	assert.NoError(t, p.Check(ctx, "url", "https://93.184.216.34"))
+
+	for _, longURL := range []string{"https://internal.example", "https://missing.example", "http://169.254.169.254/latest"} {
+		err := p.Check(ctx, "url", longURL)
+
+		var validationErr *customerrors.Error
+
+		require.True(t, errors.As(err, &validationErr), longURL)
+		assert.Equal(t, "url", validationErr.Field)
+		assert.Equal(t, "destination_denied", validationErr.Reason)
+	}
+}
+
+func TestPolicy_Reload(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "policy.json")
+	modTime := time.Now().Add(-time.Minute)
+	writeRules(t, filePath, `{"deny": ["evil.example"]}`, modTime)
+
+	p, err := New(filePath, false)
+	require.NoError(t, err)
+	assert.False(t, p.Allowed("https://evil.example"))
+	assert.True(t, p.Allowed("https://spam.example"))
+
+	changed, err := p.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed, "the file is not modified")
+
+	writeRules(t, filePath, `{"deny": ["*.example"]}`, modTime.Add(time.Second))
+
+	changed, err = p.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.False(t, p.Allowed("https://spam.example"))
+
+	writeRules(t, filePath, `{"deny": ["regexp:("]}`, modTime.Add(2*time.Second))
+
+	_, err = p.Reload()
+	assert.Error(t, err)
+	assert.False(t, p.Allowed("https://spam.example"), "the previous rules are kept")
+}
@@ -5,26 +5,51 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/configs"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers/middlewares"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/metrics"
 )
 
-// New router constructor
-func New(h *handlers.Handlers, cfg *configs.Config) *chi.Mux {
+// New router constructor, m may be nil to disable the metrics, l may be nil to disable the request log
+func New(h *handlers.Handlers, cfg *configs.Config, m *metrics.Metrics, l *logger.Logger) *chi.Mux {
 	router := chi.NewRouter()
 
-	router.Use(middleware.Logger)
+	if m != nil {
+		router.Use(m.Middleware)
+	}
+
+	if l == nil {
+		l = logger.Nop()
+	}
+
+	// the proxies are validated when the config is loaded
+	trustedProxies, _ := cfg.TrustedProxyNetworks()
+	router.Use(middlewares.ClientIPMiddleware(trustedProxies))
+
+	router.Use(middlewares.RequestLoggerMiddleware(l))
 	router.Use(middleware.Recoverer)
 
+	router.Use(middlewares.APIKeyMiddleware(h))
+
 	router.Route("/", func(r chi.Router) {
-		r.Post("/", h.CreateShortURL)
+		r.With(middlewares.RequireScope(auth.ScopeLinksWrite)).Post("/", h.CreateShortURL)
 		r.Get("/{id}", h.RetrieveShortURL)
 		r.Get("/ping", h.PingDB)
-		r.Post("/api/shorten", h.ShortenURL)
-		r.Get("/api/user/urls", h.GetUserURLs)
-		r.Delete("/api/user/urls", h.DeleteBatch)
-		r.Post("/api/shorten/batch", h.CreateBatch)
+		r.With(middlewares.RequireScope(auth.ScopeLinksWrite)).Post("/api/shorten", h.ShortenURL)
+		r.With(middlewares.RequireScope(auth.ScopeLinksRead)).Get("/api/user/urls", h.GetUserURLs)
+		r.With(middlewares.RequireScope(auth.ScopeLinksDelete)).Delete("/api/user/urls", h.DeleteBatch)
+		r.With(middlewares.RequireScope(auth.ScopeStatsRead)).Get("/api/user/urls/{id}/stats", h.GetURLStats)
+		r.With(middlewares.RequireScope(auth.ScopeLinksRead)).Get("/api/user/jobs/{id}", h.GetDeleteJob)
+		r.With(middlewares.RequireScope(auth.ScopeLinksWrite)).Post("/api/shorten/batch", h.CreateBatch)
+		r.With(middlewares.DenyAPIKeys).Post("/api/user/keys", h.CreateAPIKey)
+		r.With(middlewares.DenyAPIKeys).Get("/api/user/keys", h.GetAPIKeys)
+		r.With(middlewares.DenyAPIKeys).Delete("/api/user/keys/{id}", h.DeleteAPIKey)
 		r.Get("/api/internal/stats", h.GetStates)
+		r.Get("/api/internal/dead-letters", h.GetDeadLetters)
+		r.Put("/api/internal/workers", h.ResizeWorkers)
 	})
 
 	return router
@@ -7,30 +7,33 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers/middlewares"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/helpers/encryptor"
 )
 
 type Server struct {
 	// addr - contains the server address
 	addr string
-	// key - encryption key
-	key []byte
+	// keyring - the keys of the user cookies
+	keyring *encryptor.Keyring
 	// handler - composable HTTP services with a large set of handlers.
 	handler *chi.Mux
 	// s- defines parameters for running an HTTP server.
 	s *http.Server
 }
 
-// New is the server constructor
-func New(addr string, key []byte, handler *chi.Mux) *Server {
+// New is the server constructor, verifier authenticates the bearer tokens, nil refuses them
+func New(addr string, keyring *encryptor.Keyring, cookies helpers.CookieSettings, verifier *auth.Verifier, handler *chi.Mux) *Server {
 	srv := &http.Server{
 		Addr:    addr,
-		Handler: middlewares.Conveyor(handler, middlewares.GzipMiddleware, middlewares.CookieMiddleware(key)),
+		Handler: middlewares.Conveyor(handler, middlewares.GzipMiddleware, middlewares.CookieMiddleware(keyring, cookies), middlewares.BearerMiddleware(verifier)),
 	}
 
 	return &Server{
 		addr:    addr,
-		key:     key,
+		keyring: keyring,
 		handler: handler,
 		s:       srv,
 	}
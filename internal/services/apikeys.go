@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/auth"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// apiKeyPrefix - the prefix of the minted keys making them recognizable in the configs and the leaks
+const apiKeyPrefix = "sk_"
+
+// apiKeyTouchInterval - the last use of a key is saved at most this often
+const apiKeyTouchInterval = time.Minute
+
+// maxAPIKeyName - limit of the key name length
+const maxAPIKeyName = 64
+
+// CreateAPIKey mints a key of the user, only the hash of the secret is saved
+func (us *URLService) CreateAPIKey(ctx context.Context, user models.UserID, name string, scopes []string) (handlers.ResponseAPIKey, error) {
+	if len(name) > maxAPIKeyName {
+		return handlers.ResponseAPIKey{}, customerrors.InvalidInput("name", "too_long", fmt.Sprintf("the name must be at most %d characters", maxAPIKeyName))
+	}
+
+	if len(scopes) == 0 {
+		return handlers.ResponseAPIKey{}, customerrors.InvalidInput("scopes", "empty", "at least one scope is required")
+	}
+
+	unique := make([]string, 0, len(scopes))
+	seen := make(map[string]bool, len(scopes))
+
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			return handlers.ResponseAPIKey{}, customerrors.InvalidInput("scopes", "unknown", fmt.Sprintf("unknown scope %q", scope))
+		}
+
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return handlers.ResponseAPIKey{}, err
+	}
+
+	plain := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := models.APIKey{
+		ID:        uuid.NewString(),
+		User:      user,
+		Name:      name,
+		Hash:      hashAPIKey(plain),
+		Scopes:    unique,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = us.repo.AddAPIKey(ctx, key)
+	if err != nil {
+		return handlers.ResponseAPIKey{}, err
+	}
+
+	response := apiKeyResponse(key)
+	response.Key = plain
+
+	return response, nil
+}
+
+// GetAPIKeys returns the keys of the user without the secrets
+func (us *URLService) GetAPIKeys(ctx context.Context, user models.UserID) ([]handlers.ResponseAPIKey, error) {
+	keys, err := us.repo.GetUserAPIKeys(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]handlers.ResponseAPIKey, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, apiKeyResponse(key))
+	}
+
+	return result, nil
+}
+
+// DeleteAPIKey revokes the key of the user, the keys of other users are not found
+func (us *URLService) DeleteAPIKey(ctx context.Context, user models.UserID, id string) error {
+	return us.repo.DeleteAPIKey(ctx, user, id)
+}
+
+// AuthenticateAPIKey returns the key by its secret or auth.ErrInvalidAPIKey, the last use is saved at most once per apiKeyTouchInterval
+func (us *URLService) AuthenticateAPIKey(ctx context.Context, plain string) (models.APIKey, error) {
+	key, err := us.repo.GetAPIKey(ctx, hashAPIKey(plain))
+	if customerrors.KindOf(err) == customerrors.KindNotFound {
+		return models.APIKey{}, auth.ErrInvalidAPIKey
+	}
+
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	now := time.Now().UTC()
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		err = us.repo.TouchAPIKey(ctx, key.ID, now)
+		if err != nil {
+			logger.FromContext(ctx).Warn("unable to record the API key use", "key", key.ID, "error", err)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+
+	return key, nil
+}
+
+// hashAPIKey - the keys are random enough for a plain hash, it is looked up directly
+func hashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func apiKeyResponse(key models.APIKey) handlers.ResponseAPIKey {
+	return handlers.ResponseAPIKey{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+// JobDeleteURLs - the kind of the jobs deleting the urls of a user, the payload is DeleteJob
+const JobDeleteURLs = "delete_urls"
+
+// deleteChunk - the number of urls checked and deleted at once by a delete job
+const deleteChunk = 10
+
+// DeleteJob is the payload of the JobDeleteURLs jobs
+type DeleteJob struct {
+	// ID - the delete job the urls belong to
+	ID   string        `json:"id,omitempty"`
+	User models.UserID `json:"user"`
+	URLs []string      `json:"urls"`
+}
+
+// deleteJobTTL - how long the finished delete jobs can be queried
+const deleteJobTTL = time.Hour
+
+// deletePendingTTL - how long the unfinished delete jobs are kept, e.g. the jobs of the tasks lost without the durable queue
+const deletePendingTTL = 24 * time.Hour
+
+// startDeleteJob saves a new job with all the urls pending and purges the jobs finished long ago or never finished
+func (us *URLService) startDeleteJob(ctx context.Context, user models.UserID, urls []string) (string, error) {
+	now := time.Now().UTC()
+
+	purged, err := us.repo.PurgeDeleteJobs(ctx, now.Add(-deleteJobTTL), now.Add(-deletePendingTTL))
+	if err != nil {
+		logger.FromContext(ctx).Warn("unable to purge the delete jobs", "error", err)
+	} else if purged > 0 {
+		logger.FromContext(ctx).Debug("delete jobs purged", "jobs", purged)
+	}
+
+	job := handlers.ResponseDeleteJob{
+		ID:        uuid.NewString(),
+		Status:    handlers.DeleteJobPending,
+		URLs:      make([]handlers.DeleteURLResult, 0, len(urls)),
+		CreatedAt: now,
+	}
+
+	for _, url := range urls {
+		job.URLs = append(job.URLs, handlers.DeleteURLResult{ShortURL: url, Outcome: handlers.DeletePending})
+	}
+
+	completeDeleteJob(&job, now)
+
+	err = us.repo.AddDeleteJob(ctx, user, job)
+	if err != nil {
+		return "", err
+	}
+
+	return job.ID, nil
+}
+
+// forgetDeleteJob drops the job that was not accepted
+func (us *URLService) forgetDeleteJob(ctx context.Context, id string) {
+	err := us.repo.RemoveDeleteJob(ctx, id)
+	if err != nil {
+		logger.FromContext(ctx).Warn("unable to remove the delete job", "job", id, "error", err)
+	}
+}
+
+// recordDeleteJob sets the outcomes of the urls of the job, a url failed before may succeed when its job is resumed.
+// The removed jobs are ignored.
+func (us *URLService) recordDeleteJob(ctx context.Context, id string, outcomes map[string]string) error {
+	err := us.repo.UpdateDeleteJob(ctx, id, func(job *handlers.ResponseDeleteJob) {
+		for i, result := range job.URLs {
+			outcome, ok := outcomes[result.ShortURL]
+			if !ok || (result.Outcome != handlers.DeletePending && result.Outcome != handlers.DeleteFailed) {
+				continue
+			}
+
+			job.URLs[i].Outcome = outcome
+		}
+
+		completeDeleteJob(job, time.Now().UTC())
+	})
+	if customerrors.KindOf(err) == customerrors.KindNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// failDeleteJob keeps the error of the job, the urls stay pending while the job may be retried
+func (us *URLService) failDeleteJob(ctx context.Context, id string, cause error) {
+	err := us.repo.UpdateDeleteJob(ctx, id, func(job *handlers.ResponseDeleteJob) {
+		job.Error = cause.Error()
+	})
+	if err != nil && customerrors.KindOf(err) != customerrors.KindNotFound {
+		logger.FromContext(ctx).Warn("unable to save the error of the delete job", "job", id, "error", err)
+	}
+}
+
+// failDeleteURLs marks the pending urls of the task failed after all the retries
+func (us *URLService) failDeleteURLs(ctx context.Context, id string, urls []string, cause error) {
+	failed := make(map[string]string, len(urls))
+	for _, url := range urls {
+		failed[url] = handlers.DeleteFailed
+	}
+
+	err := us.repo.UpdateDeleteJob(ctx, id, func(job *handlers.ResponseDeleteJob) {
+		job.Error = cause.Error()
+
+		for i, result := range job.URLs {
+			if _, ok := failed[result.ShortURL]; ok && result.Outcome == handlers.DeletePending {
+				job.URLs[i].Outcome = handlers.DeleteFailed
+			}
+		}
+
+		completeDeleteJob(job, time.Now().UTC())
+	})
+	if err != nil && customerrors.KindOf(err) != customerrors.KindNotFound {
+		logger.FromContext(ctx).Error("unable to save the failure of the delete job", "job", id, "error", err)
+	}
+}
+
+// completeDeleteJob sets the status of the job by the outcomes of its urls, the job is completed at now once no url is pending
+func completeDeleteJob(job *handlers.ResponseDeleteJob, now time.Time) {
+	status := handlers.DeleteJobDone
+
+	for _, result := range job.URLs {
+		if result.Outcome == handlers.DeletePending {
+			return
+		}
+
+		if result.Outcome == handlers.DeleteFailed {
+			status = handlers.DeleteJobFailed
+		}
+	}
+
+	if job.Status != status || job.CompletedAt == nil {
+		job.Status = status
+		job.CompletedAt = &now
+	}
+}
+
+// DeleteBatch deletes the urls in the background as one task, the task is saved first if the job queue is set.
+// It returns the id of the delete job, or workers.ErrQueueFull without waiting if the worker pool is busy,
+// then nothing is deleted.
+func (us *URLService) DeleteBatch(ctx context.Context, urls []string, userID models.UserID) (string, error) {
+	id, err := us.startDeleteJob(ctx, userID, urls)
+	if err != nil {
+		return "", err
+	}
+
+	logger.FromContext(ctx).Debug("delete queued", "user", userID, "urls", len(urls), "job", id)
+
+	if us.jobs != nil {
+		err = us.jobs.Enqueue(ctx, JobDeleteURLs, DeleteJob{ID: id, User: userID, URLs: urls})
+	} else {
+		err = us.wp.TryPush(ctx, workers.Task{
+			Name: JobDeleteURLs,
+			Run: func(ctx context.Context) error {
+				return us.deleteBatch(ctx, id, userID, urls)
+			},
+			OnDone: func(ctx context.Context, outcome workers.Outcome) {
+				if outcome.Err == nil {
+					logger.FromContext(ctx).Debug("urls deleted", "user", userID, "urls", len(urls), "attempts", outcome.Attempts)
+					return
+				}
+
+				us.failDeleteURLs(ctx, id, urls, outcome.Err)
+			},
+		})
+	}
+	if err != nil {
+		us.forgetDeleteJob(ctx, id)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// HandleDeleteJob runs a JobDeleteURLs job, deleting the deleted urls again is harmless
+func (us *URLService) HandleDeleteJob(ctx context.Context, payload json.RawMessage) error {
+	var job DeleteJob
+
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return workers.Permanent(err)
+	}
+
+	return us.deleteBatch(ctx, job.ID, job.User, job.URLs)
+}
+
+// HandleDeleteJobFailure marks the urls of a JobDeleteURLs job failed after all the retries
+func (us *URLService) HandleDeleteJobFailure(ctx context.Context, payload json.RawMessage, err error) {
+	var job DeleteJob
+
+	if json.Unmarshal(payload, &job) != nil {
+		return
+	}
+
+	us.failDeleteURLs(ctx, job.ID, job.URLs, err)
+}
+
+// deleteBatch deletes the urls by deleteChunk, a retry starts over since deleting the deleted urls again is harmless
+func (us *URLService) deleteBatch(ctx context.Context, id string, user models.UserID, urls []string) error {
+	for len(urls) > 0 {
+		n := deleteChunk
+		if n > len(urls) {
+			n = len(urls)
+		}
+
+		err := us.deleteURLs(ctx, id, user, urls[:n])
+		if err != nil {
+			return err
+		}
+
+		urls = urls[n:]
+	}
+
+	return nil
+}
+
+// deleteURLs deletes the urls owned by the user and records the outcome of every url of the job
+func (us *URLService) deleteURLs(ctx context.Context, id string, user models.UserID, urls []string) error {
+	outcomes := make(map[string]string, len(urls))
+	owned := make([]string, 0, len(urls))
+
+	for _, url := range urls {
+		owner, err := us.repo.GetOwner(ctx, url)
+
+		switch {
+		case customerrors.KindOf(err) == customerrors.KindNotFound:
+			outcomes[url] = handlers.DeleteNotFound
+		case err != nil:
+			us.failDeleteJob(ctx, id, err)
+			return err
+		case owner != user:
+			outcomes[url] = handlers.DeleteNotOwner
+		default:
+			outcomes[url] = handlers.DeleteDeleted
+			owned = append(owned, url)
+		}
+	}
+
+	if len(owned) > 0 {
+		err := us.repo.DeleteURLs(ctx, user, owned...)
+		if err != nil {
+			us.failDeleteJob(ctx, id, err)
+			return err
+		}
+	}
+
+	return us.recordDeleteJob(ctx, id, outcomes)
+}
+
+// GetDeleteJob returns the state of the delete job of the user
+func (us *URLService) GetDeleteJob(ctx context.Context, id string, user models.UserID) (handlers.ResponseDeleteJob, error) {
+	owner, job, err := us.repo.GetDeleteJob(ctx, id)
+	if err != nil {
+		return handlers.ResponseDeleteJob{}, err
+	}
+
+	if owner != user {
+		return handlers.ResponseDeleteJob{}, customerrors.NotFound("the job not found")
+	}
+
+	return job, nil
+}
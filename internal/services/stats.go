@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// statsTop - number of values in the top referrers and user agents
+const statsTop = 10
+
+// buckets - the supported series granularity, "day" is the default
+var buckets = map[string]time.Duration{
+	"day":  24 * time.Hour,
+	"hour": time.Hour,
+}
+
+// GetURLStats returns the click statistics of the short url, only the owner of the url can read them
+func (us *URLService) GetURLStats(ctx context.Context, shortURL models.ShortURL, user models.UserID, bucket string) (handlers.ResponseStats, error) {
+	if bucket == "" {
+		bucket = "day"
+	}
+
+	size, ok := buckets[bucket]
+	if !ok {
+		return handlers.ResponseStats{}, customerrors.InvalidInput("bucket", "invalid", fmt.Sprintf("the bucket must be \"hour\" or \"day\": %q", bucket))
+	}
+
+	owner, err := us.repo.GetOwner(ctx, shortURL)
+	if err != nil {
+		return handlers.ResponseStats{}, err
+	}
+
+	if owner != user {
+		return handlers.ResponseStats{}, customerrors.Forbidden(fmt.Sprintf("the url belongs to another user: %q", shortURL))
+	}
+
+	clicks, err := us.repo.GetClicks(ctx, shortURL)
+	if err != nil {
+		return handlers.ResponseStats{}, err
+	}
+
+	return aggregateClicks(shortURL, bucket, size, clicks), nil
+}
+
+// aggregateClicks builds the statistics from the clicks sorted by time.
+// A visitor is identified by the ip and the user agent, the series has no gaps between the first and the last click.
+func aggregateClicks(shortURL models.ShortURL, bucket string, size time.Duration, clicks []models.Click) handlers.ResponseStats {
+	stats := handlers.ResponseStats{
+		ShortURL:      shortURL,
+		TotalClicks:   len(clicks),
+		Bucket:        bucket,
+		Series:        []handlers.StatsBucket{},
+		TopReferrers:  []handlers.StatsCount{},
+		TopUserAgents: []handlers.StatsCount{},
+	}
+
+	if len(clicks) == 0 {
+		return stats
+	}
+
+	visitors := make(map[string]bool)
+	referrers := make(map[string]int)
+	userAgents := make(map[string]int)
+	series := make(map[time.Time]int)
+
+	for _, click := range clicks {
+		visitors[click.IP+"|"+click.UserAgent] = true
+
+		referrer := click.Referrer
+		if referrer == "" {
+			referrer = "direct"
+		}
+
+		referrers[referrer]++
+		userAgents[userAgentFamily(click.UserAgent)]++
+		series[click.Time.UTC().Truncate(size)]++
+	}
+
+	stats.UniqueClicks = len(visitors)
+
+	first := clicks[0].Time.UTC().Truncate(size)
+	last := clicks[len(clicks)-1].Time.UTC().Truncate(size)
+
+	for t := first; !t.After(last); t = t.Add(size) {
+		stats.Series = append(stats.Series, handlers.StatsBucket{Time: t, Clicks: series[t]})
+	}
+
+	stats.TopReferrers = top(referrers, statsTop)
+	stats.TopUserAgents = top(userAgents, statsTop)
+
+	return stats
+}
+
+// top returns up to n values with the most clicks, the ties are ordered by value
+func top(counts map[string]int, n int) []handlers.StatsCount {
+	result := make([]handlers.StatsCount, 0, len(counts))
+
+	for value, clicks := range counts {
+		result = append(result, handlers.StatsCount{Value: value, Clicks: clicks})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Clicks != result[j].Clicks {
+			return result[i].Clicks > result[j].Clicks
+		}
+		return result[i].Value < result[j].Value
+	})
+
+	if len(result) > n {
+		result = result[:n]
+	}
+
+	return result
+}
+
+// userAgentFamilies - substrings of the user agent in the order of checking,
+// browsers built on Chrome and Safari mention them so they go first
+var userAgentFamilies = []struct {
+	substring string
+	family    string
+}{
+	{"bot", "Bot"},
+	{"spider", "Bot"},
+	{"crawler", "Bot"},
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"chrome/", "Chrome"},
+	{"chromium/", "Chrome"},
+	{"firefox/", "Firefox"},
+	{"safari/", "Safari"},
+	{"curl/", "curl"},
+	{"go-http-client/", "Go-http-client"},
+	{"grpc-go/", "grpc-go"},
+}
+
+// userAgentFamily returns the browser or client family of the user agent
+func userAgentFamily(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown"
+	}
+
+	userAgent = strings.ToLower(userAgent)
+
+	for _, f := range userAgentFamilies {
+		if strings.Contains(userAgent, f.substring) {
+			return f.family
+		}
+	}
+
+	return "Other"
+}
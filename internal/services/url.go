@@ -3,49 +3,309 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
 	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
 )
 
 type RepositoryInterface interface {
-	AddURL(ctx context.Context, longURL models.LongURL, shortURL models.ShortURL, user models.UserID) error
+	AddURL(ctx context.Context, longURL models.LongURL, shortURL models.ShortURL, user models.UserID, expiration models.Expiration) error
 	AddURLs(ctx context.Context, user models.UserID, urls ...handlers.RequestGetURLs) ([]handlers.ResponseGetURLs, error)
 	DeleteURLs(ctx context.Context, user models.UserID, urls ...string) error
 	GetURL(ctx context.Context, shortURL models.ShortURL) (models.ShortURL, error)
+	// VisitURL returns the long url like GetURL and counts the click
+	VisitURL(ctx context.Context, shortURL models.ShortURL) (models.LongURL, error)
+	// GetShortURL returns the first link of longURL that is neither deleted nor limited by an expiration
+	GetShortURL(ctx context.Context, longURL models.LongURL) (models.ShortURL, error)
 	GetUserURLs(ctx context.Context, user models.UserID) ([]handlers.ResponseGetURL, error)
 	GetStates(ctx context.Context) (handlers.ResponseStates, error)
+	// BlockURLs marks the links whose long urls are blocked and unmarks the rest, returns the number of changed links
+	BlockURLs(ctx context.Context, blocked func(longURL models.LongURL) bool) (int, error)
+	// SweepURLs removes the links expired at now or marks them deleted if archive is set, returns the number of swept links
+	SweepURLs(ctx context.Context, now time.Time, archive bool) (int, error)
+	// AddClicks saves the redirects, the clicks of purged links are removed with them
+	AddClicks(ctx context.Context, clicks ...models.Click) error
+	// GetClicks returns the redirects of the short url in the order of time
+	GetClicks(ctx context.Context, shortURL models.ShortURL) ([]models.Click, error)
+	// GetOwner returns the user who created the short url, deleted and expired urls included
+	GetOwner(ctx context.Context, shortURL models.ShortURL) (models.UserID, error)
+	// GetShortURLs returns the identifiers of all the stored links, deleted and expired included
+	GetShortURLs(ctx context.Context) ([]models.ShortURL, error)
+	// AddAPIKey saves the minted key
+	AddAPIKey(ctx context.Context, key models.APIKey) error
+	// GetAPIKey returns the key by the hash of its secret
+	GetAPIKey(ctx context.Context, hash string) (models.APIKey, error)
+	// GetUserAPIKeys returns the keys of the user in the order of creation
+	GetUserAPIKeys(ctx context.Context, user models.UserID) ([]models.APIKey, error)
+	// DeleteAPIKey revokes the key of the user
+	DeleteAPIKey(ctx context.Context, user models.UserID, id string) error
+	// TouchAPIKey records the use of the key
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+	// AddDeleteJob saves the new delete job of the user
+	AddDeleteJob(ctx context.Context, user models.UserID, job handlers.ResponseDeleteJob) error
+	// UpdateDeleteJob changes the delete job with update atomically, an unknown job is a KindNotFound error
+	UpdateDeleteJob(ctx context.Context, id string, update func(job *handlers.ResponseDeleteJob)) error
+	// GetDeleteJob returns the delete job and its owner
+	GetDeleteJob(ctx context.Context, id string) (models.UserID, handlers.ResponseDeleteJob, error)
+	// RemoveDeleteJob removes the delete job, an unknown job is not an error
+	RemoveDeleteJob(ctx context.Context, id string) error
+	// PurgeDeleteJobs removes the jobs completed before completedBefore and the pending jobs created before createdBefore,
+	// returns the number of removed jobs
+	PurgeDeleteJobs(ctx context.Context, completedBefore, createdBefore time.Time) (int, error)
 	Ping(ctx context.Context) error
 }
 
+// PolicyInterface decides which destinations can be shortened
+type PolicyInterface interface {
+	// Check returns a validation error for the field if longURL can not be shortened
+	Check(ctx context.Context, field string, longURL models.LongURL) error
+	// Allowed reports whether the stored longURL is still permitted
+	Allowed(longURL models.LongURL) bool
+}
+
+// ClickRecorder receives the redirects without blocking
+type ClickRecorder interface {
+	Record(click models.Click)
+}
+
+// JobQueue persists the background tasks before they are run
+type JobQueue interface {
+	Enqueue(ctx context.Context, kind string, payload interface{}) error
+}
+
+// maxAttempts - number of identifiers tried before giving up on collisions
+const maxAttempts = 5
+
+// aliasPattern - allowed characters and length of custom aliases
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+// reservedIDs - identifiers clashing with the router paths, neither aliases nor generated ones may use them
+var reservedIDs = map[string]bool{
+	"api":     true,
+	"debug":   true,
+	"docs":    true,
+	"metrics": true,
+	"ping":    true,
+	"swagger": true,
+}
+
 type URLService struct {
-	repo    RepositoryInterface
-	baseURL string
-	wp      *workers.WorkerPool
-	subnet  *net.IPNet
+	repo      RepositoryInterface
+	baseURL   string
+	wp        *workers.WorkerPool
+	subnet    *net.IPNet
+	generator shortener.Generator
+	// validator - canonicalizes the urls before saving, nil disables the validation
+	validator *URLValidator
+	// policy - the destination allow and deny rules, nil allows everything
+	policy PolicyInterface
+	// clicks - the redirects analytics, nil disables the recording
+	clicks ClickRecorder
+	// jobs - the durable queue of the deletes, nil pushes them to the worker pool directly
+	jobs JobQueue
 }
 
-func New(repo RepositoryInterface, baseURL string, wp *workers.WorkerPool, subnet *net.IPNet) *URLService {
+func New(repo RepositoryInterface, baseURL string, wp *workers.WorkerPool, subnet *net.IPNet, generator shortener.Generator, validator *URLValidator, policy PolicyInterface, clicks ClickRecorder, jobs JobQueue) *URLService {
 	return &URLService{
-		repo:    repo,
-		baseURL: baseURL,
-		wp:      wp,
-		subnet:  subnet,
+		repo:      repo,
+		baseURL:   baseURL,
+		wp:        wp,
+		subnet:    subnet,
+		generator: generator,
+		validator: validator,
+		policy:    policy,
+		clicks:    clicks,
+		jobs:      jobs,
 	}
 }
 
-func (us *URLService) GetURL(ctx context.Context, userID models.UserID) (string, error) {
-	return us.repo.GetURL(ctx, userID)
+// normalize validates longURL if the validator is set and checks it against the policy if the policy is set
+func (us *URLService) normalize(ctx context.Context, field string, longURL models.LongURL) (models.LongURL, error) {
+	if us.validator != nil {
+		var err error
+
+		longURL, err = us.validator.Normalize(field, longURL)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if us.policy != nil {
+		err := us.policy.Check(ctx, field, longURL)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return longURL, nil
+}
+
+// validateAlias checks the charset, the length and the reserved words
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return customerrors.InvalidInput("alias", "malformed", fmt.Sprintf("the alias must be 3-64 characters long and consist of letters, digits, '_' or '-': %q", alias))
+	}
+
+	if reserved(alias) {
+		return customerrors.InvalidInput("alias", "reserved", fmt.Sprintf("the alias is reserved: %q", alias))
+	}
+
+	return nil
+}
+
+// reserved reports whether the identifier clashes with the router paths
+func reserved(id string) bool {
+	return reservedIDs[strings.ToLower(id)]
+}
+
+// aliasTakenError is returned when the alias points to another url
+func aliasTakenError(alias string) error {
+	return customerrors.Conflict(customerrors.ReasonAliasTaken, fmt.Sprintf("the alias is already taken: %q", alias), nil)
+}
+
+// validateExpiration checks that the link can be followed at least once, prefix is prepended to the field names
+func validateExpiration(prefix string, expiration models.Expiration) error {
+	if !expiration.ExpiresAt.IsZero() && !expiration.ExpiresAt.After(time.Now()) {
+		return customerrors.InvalidInput(prefix+"expires_at", "in_past", "the expiration time must be in the future")
+	}
+
+	if expiration.MaxClicks < 0 {
+		return customerrors.InvalidInput(prefix+"max_clicks", "negative", "the number of clicks must not be negative")
+	}
+
+	return nil
+}
+
+// seed returns the value the identifier is generated from.
+// Expiring links are never shared, so their identifiers must not depend on the url alone.
+func seed(longURL models.LongURL, expiration models.Expiration) string {
+	if expiration.IsZero() {
+		return longURL
+	}
+
+	return longURL + "#" + strconv.FormatInt(time.Now().UnixNano(), 36)
 }
 
-func (us *URLService) CreateURL(ctx context.Context, longURL models.LongURL, user models.UserID) (string, error) {
-	shortURL := shortener.ShorterURL(longURL)
-	err := us.repo.AddURL(ctx, longURL, shortURL, user)
-	return fmt.Sprintf("%s/%s", us.baseURL, shortURL), err
+// retryable reports whether saving the link with a new identifier can succeed.
+// An expiring link must not reuse the permanent link with the same identifier.
+func retryable(err error, expiring bool) bool {
+	return customerrors.HasReason(err, customerrors.ReasonCollision) || (expiring && customerrors.HasReason(err, customerrors.ReasonURLExists))
+}
+
+// generate returns the identifier of the attempt, the reserved identifiers are skipped with the next attempts
+// of the generator that do not overlap the attempts of the caller
+func (us *URLService) generate(value string, attempt int) (string, error) {
+	for skipped := 0; skipped < maxAttempts; skipped++ {
+		shortURL, err := us.generator.Generate(value, attempt+skipped*maxAttempts)
+		if err != nil || !reserved(shortURL) {
+			return shortURL, err
+		}
+	}
+
+	return "", fmt.Errorf("only reserved short ids are generated after %d attempts", maxAttempts)
+}
+
+// noFreeID is returned when every generated identifier is taken, e.g. the identifiers are too short.
+// It is not a conflict of the request, so the kind of the last collision is not kept.
+func noFreeID(err error) error {
+	return fmt.Errorf("no free short id after %d attempts: %v", maxAttempts, err)
+}
+
+// GetURL returns the long url of the link counting the click
+func (us *URLService) GetURL(ctx context.Context, shortURL models.ShortURL) (string, error) {
+	return us.repo.VisitURL(ctx, shortURL)
+}
+
+// RecordClick passes the redirect to the analytics, the caller is never blocked
+func (us *URLService) RecordClick(click models.Click) {
+	if us.clicks == nil {
+		return
+	}
+
+	if click.Time.IsZero() {
+		click.Time = time.Now()
+	}
+
+	us.clicks.Record(click)
+}
+
+// CreateURL saves longURL under the alias or a generated identifier if the alias is empty.
+// If the url is already saved without an expiration the existing short url is returned
+// with a KindConflict error with ReasonURLExists.
+// The hash strategy dedups atomically since the same url gets the same identifier, with the other strategies
+// two concurrent calls may both save the url and the later calls return the oldest link.
+func (us *URLService) CreateURL(ctx context.Context, longURL models.LongURL, alias string, expiration models.Expiration, user models.UserID) (string, error) {
+	longURL, err := us.normalize(ctx, "url", longURL)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateExpiration("", expiration)
+	if err != nil {
+		return "", err
+	}
+
+	if alias != "" {
+		return us.createAlias(ctx, longURL, alias, expiration, user)
+	}
+
+	expiring := !expiration.IsZero()
+
+	var shortURL models.ShortURL
+
+	if !expiring {
+		shortURL, err = us.repo.GetShortURL(ctx, longURL)
+		if err == nil {
+			return fmt.Sprintf("%s/%s", us.baseURL, shortURL), customerrors.Conflict(customerrors.ReasonURLExists, "the same URL already exists", nil)
+		}
+
+		if customerrors.KindOf(err) != customerrors.KindNotFound {
+			return "", err
+		}
+	}
+
+	value := seed(longURL, expiration)
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		shortURL, err = us.generate(value, attempt)
+		if err != nil {
+			return "", err
+		}
+
+		err = us.repo.AddURL(ctx, longURL, shortURL, user, expiration)
+		if !retryable(err, expiring) {
+			return fmt.Sprintf("%s/%s", us.baseURL, shortURL), err
+		}
+	}
+
+	return "", noFreeID(err)
+}
+
+func (us *URLService) createAlias(ctx context.Context, longURL models.LongURL, alias string, expiration models.Expiration, user models.UserID) (string, error) {
+	err := validateAlias(alias)
+	if err != nil {
+		return "", err
+	}
+
+	shortURL := fmt.Sprintf("%s/%s", us.baseURL, alias)
+
+	err = us.repo.AddURL(ctx, longURL, alias, user, expiration)
+	if customerrors.HasReason(err, customerrors.ReasonCollision) {
+		return "", aliasTakenError(alias)
+	}
+
+	return shortURL, err
 }
 
 func (us *URLService) GetUserURLs(ctx context.Context, userID models.UserID) ([]handlers.ResponseGetURL, error) {
@@ -56,27 +316,236 @@ func (us *URLService) Ping(ctx context.Context) error {
 	return us.repo.Ping(ctx)
 }
 
+// CreateBatch saves the urls in one transaction, already saved urls get their existing short urls,
+// the dedup is best-effort for the strategies other than hash like in CreateURL
 func (us *URLService) CreateBatch(ctx context.Context, urls []handlers.RequestGetURLs, userID models.UserID) ([]handlers.ResponseGetURLs, error) {
-	return us.repo.AddURLs(ctx, userID, urls...)
+	normalized := make([]handlers.RequestGetURLs, len(urls))
+
+	for i, u := range urls {
+		longURL, err := us.normalize(ctx, fmt.Sprintf("[%d].original_url", i), u.OriginalURL)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateExpiration(fmt.Sprintf("[%d].", i), u.Expiration())
+		if err != nil {
+			return nil, err
+		}
+
+		normalized[i] = u
+		normalized[i].OriginalURL = longURL
+	}
+
+	urls = normalized
+
+	result := make([]handlers.ResponseGetURLs, len(urls))
+
+	// pending - indexes of the urls that have to be saved, the first occurrence of every long url
+	var pending []int
+	// duplicates - indexes of repeated long urls pointing to their first occurrence
+	duplicates := map[int]int{}
+	first := map[models.LongURL]int{}
+
+	// aliases - custom aliases requested in the batch
+	aliases := map[string]bool{}
+
+	for i, u := range urls {
+		result[i].CorrelationID = u.CorrelationID
+
+		if u.Alias != "" {
+			err := validateAlias(u.Alias)
+			if err != nil {
+				return nil, err
+			}
+
+			if aliases[u.Alias] {
+				return nil, aliasTakenError(u.Alias)
+			}
+
+			aliases[u.Alias] = true
+
+			longURL, err := us.repo.GetURL(ctx, u.Alias)
+			switch {
+			case err == nil && longURL == u.OriginalURL:
+				result[i].ShortURL = fmt.Sprintf("%s/%s", us.baseURL, u.Alias)
+			case err == nil || customerrors.KindOf(err) == customerrors.KindGone:
+				return nil, aliasTakenError(u.Alias)
+			case customerrors.KindOf(err) == customerrors.KindNotFound:
+				pending = append(pending, i)
+			default:
+				return nil, err
+			}
+
+			continue
+		}
+
+		// expiring links are never shared
+		if !u.Expiration().IsZero() {
+			pending = append(pending, i)
+			continue
+		}
+
+		if j, ok := first[u.OriginalURL]; ok {
+			duplicates[i] = j
+			continue
+		}
+
+		first[u.OriginalURL] = i
+
+		shortURL, err := us.repo.GetShortURL(ctx, u.OriginalURL)
+		if err == nil {
+			result[i].ShortURL = fmt.Sprintf("%s/%s", us.baseURL, shortURL)
+			continue
+		}
+
+		if customerrors.KindOf(err) != customerrors.KindNotFound {
+			return nil, err
+		}
+
+		pending = append(pending, i)
+	}
+
+	if len(pending) > 0 {
+		batch := make([]handlers.RequestGetURLs, len(pending))
+
+		var (
+			saved    []handlers.ResponseGetURLs
+			err      error
+			expiring bool
+		)
+
+		for _, i := range pending {
+			expiring = expiring || !urls[i].Expiration().IsZero()
+		}
+
+		for attempt := 0; attempt < maxAttempts; attempt++ {
+			for k, i := range pending {
+				batch[k] = urls[i]
+
+				if urls[i].Alias != "" {
+					batch[k].ShortURL = urls[i].Alias
+					continue
+				}
+
+				batch[k].ShortURL, err = us.generate(seed(urls[i].OriginalURL, urls[i].Expiration()), attempt)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			saved, err = us.repo.AddURLs(ctx, userID, batch...)
+			if !retryable(err, expiring) {
+				break
+			}
+
+			// an alias collision can not be resolved by generating new identifiers
+			taken, aliasErr := us.takenAlias(ctx, batch)
+			if aliasErr != nil {
+				return nil, aliasErr
+			}
+
+			if taken != "" {
+				return nil, aliasTakenError(taken)
+			}
+		}
+
+		if retryable(err, expiring) {
+			return nil, noFreeID(err)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for k, i := range pending {
+			result[i].ShortURL = saved[k].ShortURL
+		}
+	}
+
+	for i, j := range duplicates {
+		result[i].ShortURL = result[j].ShortURL
+	}
+
+	return result, nil
+}
+
+// takenAlias returns the first alias of the batch that is already saved
+func (us *URLService) takenAlias(ctx context.Context, batch []handlers.RequestGetURLs) (string, error) {
+	for _, u := range batch {
+		if u.Alias == "" {
+			continue
+		}
+
+		_, err := us.repo.GetURL(ctx, u.Alias)
+		if customerrors.KindOf(err) == customerrors.KindNotFound {
+			continue
+		}
+
+		if err == nil || customerrors.KindOf(err) == customerrors.KindGone {
+			return u.Alias, nil
+		}
+
+		return "", err
+	}
+
+	return "", nil
+}
+
+// EnforcePolicy blocks the stored links the policy no longer allows and unblocks the allowed ones
+func (us *URLService) EnforcePolicy(ctx context.Context) (int, error) {
+	if us.policy == nil {
+		return 0, nil
+	}
+
+	return us.repo.BlockURLs(ctx, func(longURL models.LongURL) bool {
+		return !us.policy.Allowed(longURL)
+	})
+}
+
+// SweepExpired removes the expired links or marks them deleted if archive is set
+func (us *URLService) SweepExpired(ctx context.Context, archive bool) (int, error) {
+	return us.repo.SweepURLs(ctx, time.Now(), archive)
 }
 
-func (us *URLService) DeleteBatch(urls []string, userID models.UserID) {
-	var sliceData [][]string
-	for i := 10; i <= len(urls); i += 10 {
-		sliceData = append(sliceData, urls[i-10:i])
+// GetDeadLetters returns the dead letters of the worker pool if ip belongs to the trusted subnet
+func (us *URLService) GetDeadLetters(ctx context.Context, ip net.IP) (bool, []workers.DeadLetter) {
+	if us.subnet == nil || !us.subnet.Contains(ip) {
+		return false, nil
 	}
-	rem := len(urls) % 10
-	if rem > 0 {
-		sliceData = append(sliceData, urls[len(urls)-rem:])
+
+	if us.wp == nil {
+		return true, nil
 	}
-	for _, item := range sliceData {
-		func(taskData []string) {
-			us.wp.Push(func(ctx context.Context) error {
-				err := us.repo.DeleteURLs(ctx, userID, taskData...)
-				return err
-			})
-		}(item)
+
+	return true, us.wp.DeadLetters()
+}
+
+// ResizeWorkers changes the number of the workers if ip belongs to the trusted subnet
+func (us *URLService) ResizeWorkers(ctx context.Context, ip net.IP, workers int) (bool, handlers.ResponseWorkers, error) {
+	if us.subnet == nil || !us.subnet.Contains(ip) {
+		return false, handlers.ResponseWorkers{}, nil
 	}
+
+	if workers < 1 {
+		return true, handlers.ResponseWorkers{}, customerrors.InvalidInput("workers", "invalid", "the number of workers must be positive")
+	}
+
+	if us.wp == nil {
+		return true, handlers.ResponseWorkers{}, errors.New("no worker pool")
+	}
+
+	err := us.wp.Resize(workers)
+	if err != nil {
+		return true, handlers.ResponseWorkers{}, err
+	}
+
+	logger.FromContext(ctx).Info("worker pool resized", "workers", workers)
+
+	return true, handlers.ResponseWorkers{
+		Workers:  us.wp.Size(),
+		Queued:   us.wp.QueueLen(),
+		InFlight: us.wp.InFlight(),
+	}, nil
 }
 
 func (us *URLService) GetStates(ctx context.Context, ip net.IP) (bool, handlers.ResponseStates, error) {
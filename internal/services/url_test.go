@@ -0,0 +1,507 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/database/memory"
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/services"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/shortener"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/workers"
+)
+
+const baseURL = "http://localhost:8080"
+
+// stubGenerator returns the identifiers in order, ignoring the url
+type stubGenerator struct {
+	ids []string
+}
+
+func (g *stubGenerator) Generate(longURL string, attempt int) (string, error) {
+	if len(g.ids) == 0 {
+		return "", errors.New("no identifiers left")
+	}
+
+	id := g.ids[0]
+	g.ids = g.ids[1:]
+
+	return id, nil
+}
+
+func TestURLService_CreateURL(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	generator := &stubGenerator{ids: []string{"a", "a", "b"}}
+	service := services.New(repo, baseURL, nil, nil, generator, nil, nil, nil, nil)
+
+	shortURL, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+	assert.Equal(t, baseURL+"/a", shortURL)
+
+	shortURL, err = service.CreateURL(ctx, "https://pkg.go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err, "the collision must be retried")
+	assert.Equal(t, baseURL+"/b", shortURL)
+
+	shortURL, err = service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "another")
+
+	var dbErr *customerrors.Error
+
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "url_exists", dbErr.Reason)
+	assert.Equal(t, baseURL+"/a", shortURL)
+}
+
+func TestURLService_CreateURLReservedID(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	generator := &stubGenerator{ids: []string{"api", "Ping", "a", "docs", "b"}}
+	service := services.New(repo, baseURL, nil, nil, generator, nil, nil, nil, nil)
+
+	shortURL, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+	assert.Equal(t, baseURL+"/a", shortURL, "the reserved words must be skipped")
+
+	urls, err := service.CreateBatch(ctx, []handlers.RequestGetURLs{{CorrelationID: "1", OriginalURL: "https://pkg.go.dev"}}, "user")
+	require.NoError(t, err)
+	assert.Equal(t, baseURL+"/b", urls[0].ShortURL)
+}
+
+func TestURLService_CreateURLNoFreeID(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	generator := &stubGenerator{ids: []string{"a", "a", "a", "a", "a", "a", "a", "a", "a", "a", "a"}}
+	service := services.New(repo, baseURL, nil, nil, generator, nil, nil, nil, nil)
+
+	_, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	shortURL, err := service.CreateURL(ctx, "https://pkg.go.dev", "", models.Expiration{}, "user")
+	require.Error(t, err)
+	assert.Empty(t, shortURL)
+	assert.Equal(t, customerrors.KindInternal, customerrors.KindOf(err), "the exhausted retries are not a conflict of the request")
+
+	_, err = service.CreateBatch(ctx, []handlers.RequestGetURLs{{CorrelationID: "1", OriginalURL: "https://go.dev/doc"}}, "user")
+	require.Error(t, err)
+	assert.Equal(t, customerrors.KindInternal, customerrors.KindOf(err))
+}
+
+func TestURLService_CreateBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	generator := &stubGenerator{ids: []string{"a", "b", "b", "c", "d"}}
+	service := services.New(repo, baseURL, nil, nil, generator, nil, nil, nil, nil)
+
+	_, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	urls, err := service.CreateBatch(ctx, []handlers.RequestGetURLs{
+		{CorrelationID: "1", OriginalURL: "https://go.dev"},
+		{CorrelationID: "2", OriginalURL: "https://pkg.go.dev"},
+		{CorrelationID: "3", OriginalURL: "https://go.dev/doc"},
+		{CorrelationID: "4", OriginalURL: "https://pkg.go.dev"},
+	}, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []handlers.ResponseGetURLs{
+		{CorrelationID: "1", ShortURL: baseURL + "/a"},
+		{CorrelationID: "2", ShortURL: baseURL + "/c"},
+		{CorrelationID: "3", ShortURL: baseURL + "/d"},
+		{CorrelationID: "4", ShortURL: baseURL + "/c"},
+	}, urls)
+}
+
+func TestURLService_CreateURLAlias(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	service := services.New(repo, baseURL, nil, nil, &stubGenerator{}, nil, nil, nil, nil)
+
+	tests := []struct {
+		name       string
+		longURL    string
+		alias      string
+		want       string
+		wantKind   customerrors.Kind
+		wantReason string
+	}{
+		{name: "new alias", longURL: "https://go.dev", alias: "spring-sale", want: baseURL + "/spring-sale"},
+		{name: "the same url", longURL: "https://go.dev", alias: "spring-sale", want: baseURL + "/spring-sale", wantReason: "url_exists"},
+		{name: "taken by another url", longURL: "https://pkg.go.dev", alias: "spring-sale", wantKind: customerrors.KindConflict},
+		{name: "too short", longURL: "https://go.dev", alias: "ab", wantKind: customerrors.KindInvalidInput},
+		{name: "forbidden characters", longURL: "https://go.dev", alias: "spring/sale", wantKind: customerrors.KindInvalidInput},
+		{name: "reserved", longURL: "https://go.dev", alias: "PING", wantKind: customerrors.KindInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.CreateURL(ctx, tt.longURL, tt.alias, models.Expiration{}, "user")
+
+			switch {
+			case tt.wantKind != customerrors.KindInternal:
+				require.Error(t, err)
+				assert.Equal(t, tt.wantKind, customerrors.KindOf(err))
+			case tt.wantReason != "":
+				var dbErr *customerrors.Error
+
+				require.True(t, errors.As(err, &dbErr), "unexpected error: %v", err)
+				assert.Equal(t, tt.wantReason, dbErr.Reason)
+				assert.Equal(t, tt.want, got)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestURLService_CreateBatchAlias(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	service := services.New(repo, baseURL, nil, nil, &stubGenerator{ids: []string{"a"}}, nil, nil, nil, nil)
+
+	_, err := service.CreateURL(ctx, "https://go.dev", "go-home", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	urls, err := service.CreateBatch(ctx, []handlers.RequestGetURLs{
+		{CorrelationID: "1", OriginalURL: "https://go.dev", Alias: "go-home"},
+		{CorrelationID: "2", OriginalURL: "https://pkg.go.dev", Alias: "packages"},
+		{CorrelationID: "3", OriginalURL: "https://go.dev/doc"},
+	}, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []handlers.ResponseGetURLs{
+		{CorrelationID: "1", ShortURL: baseURL + "/go-home"},
+		{CorrelationID: "2", ShortURL: baseURL + "/packages"},
+		{CorrelationID: "3", ShortURL: baseURL + "/a"},
+	}, urls)
+
+	_, err = service.CreateBatch(ctx, []handlers.RequestGetURLs{
+		{CorrelationID: "1", OriginalURL: "https://go.dev/blog", Alias: "packages"},
+	}, "user")
+
+	assert.True(t, customerrors.HasReason(err, customerrors.ReasonAliasTaken), "unexpected error: %v", err)
+}
+
+// stubPolicy denies the listed urls
+type stubPolicy struct {
+	denied map[string]bool
+}
+
+func (p *stubPolicy) Check(ctx context.Context, field, longURL string) error {
+	if p.denied[longURL] {
+		return customerrors.InvalidInput(field, "destination_denied", "the destination is denied")
+	}
+
+	return nil
+}
+
+func (p *stubPolicy) Allowed(longURL string) bool {
+	return !p.denied[longURL]
+}
+
+func TestURLService_Policy(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	policy := &stubPolicy{denied: map[string]bool{"https://evil.example": true}}
+	service := services.New(repo, baseURL, nil, nil, &stubGenerator{ids: []string{"a", "b"}}, nil, policy, nil, nil)
+
+	var validationErr *customerrors.Error
+
+	_, err := service.CreateURL(ctx, "https://evil.example", "", models.Expiration{}, "user")
+	require.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+	assert.Equal(t, "url", validationErr.Field)
+	assert.Equal(t, "destination_denied", validationErr.Reason)
+
+	_, err = service.CreateBatch(ctx, []handlers.RequestGetURLs{
+		{CorrelationID: "1", OriginalURL: "https://go.dev"},
+		{CorrelationID: "2", OriginalURL: "https://evil.example"},
+	}, "user")
+	require.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+	assert.Equal(t, "[1].original_url", validationErr.Field)
+
+	_, err = service.GetURL(ctx, "a")
+	require.Error(t, err, "the batch must not be saved partially")
+
+	_, err = service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	policy.denied["https://go.dev"] = true
+
+	changed, err := service.EnforcePolicy(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	var dbErr *customerrors.Error
+
+	_, err = service.GetURL(ctx, "a")
+	require.True(t, errors.As(err, &dbErr), "unexpected error: %v", err)
+	assert.Equal(t, "blocked", dbErr.Reason)
+}
+
+func TestURLService_Expiration(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	generator, err := shortener.New(shortener.StrategyHash, shortener.DefaultLength, shortener.DefaultAlphabet, 0)
+	require.NoError(t, err)
+
+	service := services.New(repo, baseURL, nil, nil, generator, nil, nil, nil, nil)
+
+	var validationErr *customerrors.Error
+
+	_, err = service.CreateURL(ctx, "https://go.dev", "", models.Expiration{ExpiresAt: time.Now().Add(-time.Minute)}, "user")
+	require.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+	assert.Equal(t, "expires_at", validationErr.Field)
+
+	_, err = service.CreateBatch(ctx, []handlers.RequestGetURLs{
+		{CorrelationID: "1", OriginalURL: "https://go.dev", MaxClicks: -1},
+	}, "user")
+	require.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+	assert.Equal(t, "[0].max_clicks", validationErr.Field)
+
+	permanent, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	limited, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{MaxClicks: 1}, "user")
+	require.NoError(t, err, "the expiring link must not reuse the permanent one")
+	assert.NotEqual(t, permanent, limited)
+
+	id := strings.TrimPrefix(limited, baseURL+"/")
+
+	longURL, err := service.GetURL(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", longURL)
+
+	var dbErr *customerrors.Error
+
+	_, err = service.GetURL(ctx, id)
+	require.True(t, errors.As(err, &dbErr), "unexpected error: %v", err)
+	assert.Equal(t, "expired", dbErr.Reason)
+
+	again, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "another")
+	require.True(t, errors.As(err, &dbErr), "unexpected error: %v", err)
+	assert.Equal(t, "url_exists", dbErr.Reason)
+	assert.Equal(t, permanent, again)
+
+	swept, err := service.SweepExpired(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, swept)
+}
+
+func TestURLService_GetURLStats(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	service := services.New(repo, baseURL, nil, nil, &stubGenerator{ids: []string{"a"}}, nil, nil, nil, nil)
+
+	_, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	start := time.Date(2022, 5, 1, 10, 15, 0, 0, time.UTC)
+	chrome := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0 Safari/537.36"
+
+	require.NoError(t, repo.AddClicks(ctx,
+		models.Click{ShortURL: "a", Time: start, IP: "192.0.2.1", UserAgent: chrome, Referrer: "https://news.ycombinator.com"},
+		models.Click{ShortURL: "a", Time: start.Add(10 * time.Minute), IP: "192.0.2.1", UserAgent: chrome},
+		models.Click{ShortURL: "a", Time: start.Add(2 * time.Hour), IP: "192.0.2.2", UserAgent: "curl/7.81.0"},
+	))
+
+	stats, err := service.GetURLStats(ctx, "a", "user", "hour")
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, stats.TotalClicks)
+	assert.Equal(t, 2, stats.UniqueClicks)
+	assert.Equal(t, []handlers.StatsBucket{
+		{Time: time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC), Clicks: 2},
+		{Time: time.Date(2022, 5, 1, 11, 0, 0, 0, time.UTC), Clicks: 0},
+		{Time: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC), Clicks: 1},
+	}, stats.Series)
+	assert.Equal(t, []handlers.StatsCount{{Value: "direct", Clicks: 2}, {Value: "https://news.ycombinator.com", Clicks: 1}}, stats.TopReferrers)
+	assert.Equal(t, []handlers.StatsCount{{Value: "Chrome", Clicks: 2}, {Value: "curl", Clicks: 1}}, stats.TopUserAgents)
+
+	stats, err = service.GetURLStats(ctx, "a", "user", "")
+	require.NoError(t, err)
+	assert.Equal(t, "day", stats.Bucket)
+	assert.Equal(t, []handlers.StatsBucket{{Time: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), Clicks: 3}}, stats.Series)
+
+	_, err = service.GetURLStats(ctx, "a", "another", "day")
+	assert.Equal(t, customerrors.KindForbidden, customerrors.KindOf(err))
+
+	_, err = service.GetURLStats(ctx, "a", "user", "week")
+	assert.Equal(t, customerrors.KindInvalidInput, customerrors.KindOf(err))
+
+	var dbErr *customerrors.Error
+
+	_, err = service.GetURLStats(ctx, "missing", "user", "day")
+	require.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "not_found", dbErr.Reason)
+}
+
+// stubQueue keeps the enqueued payloads without running them
+type stubQueue struct {
+	payloads []json.RawMessage
+}
+
+func (q *stubQueue) Enqueue(ctx context.Context, kind string, payload interface{}) error {
+	if kind != services.JobDeleteURLs {
+		return errors.New("unexpected kind")
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	q.payloads = append(q.payloads, data)
+
+	return nil
+}
+
+func TestURLService_DeleteBatchJobs(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	queue := &stubQueue{}
+
+	var ids []string
+	for i := 0; i < 12; i++ {
+		ids = append(ids, "id"+strconv.Itoa(i))
+	}
+
+	service := services.New(repo, baseURL, nil, nil, &stubGenerator{ids: append(append([]string(nil), ids...), "other")}, nil, nil, nil, queue)
+
+	for i := range ids {
+		_, err := service.CreateURL(ctx, "https://go.dev/"+strconv.Itoa(i), "", models.Expiration{}, "user")
+		require.NoError(t, err)
+	}
+
+	_, err := service.CreateURL(ctx, "https://go.dev/other", "", models.Expiration{}, "another")
+	require.NoError(t, err)
+
+	jobID, err := service.DeleteBatch(ctx, append(append([]string(nil), ids...), "other", "missing"), "user")
+	require.NoError(t, err)
+	require.Len(t, queue.payloads, 1, "the batch is one job")
+
+	job, err := service.GetDeleteJob(ctx, jobID, "user")
+	require.NoError(t, err)
+	assert.Equal(t, handlers.DeleteJobPending, job.Status)
+	assert.Nil(t, job.CompletedAt)
+
+	_, err = service.GetDeleteJob(ctx, jobID, "another")
+	assert.True(t, isNotFound(err), "the job is visible to its owner only")
+
+	// the jobs are resumed by another instance of the service, e.g. after a restart
+	service = services.New(repo, baseURL, nil, nil, &stubGenerator{}, nil, nil, nil, queue)
+
+	for i := 0; i < 2; i++ {
+		for _, payload := range queue.payloads {
+			require.NoError(t, service.HandleDeleteJob(ctx, payload), "the job must be idempotent")
+		}
+	}
+
+	for _, id := range ids {
+		_, err = service.GetURL(ctx, id)
+
+		var dbErr *customerrors.Error
+
+		require.True(t, errors.As(err, &dbErr))
+		assert.Equal(t, "deleted", dbErr.Reason)
+	}
+
+	_, err = service.GetURL(ctx, "other")
+	assert.NoError(t, err, "the url of another user must not be deleted")
+
+	job, err = service.GetDeleteJob(ctx, jobID, "user")
+	require.NoError(t, err)
+	assert.Equal(t, handlers.DeleteJobDone, job.Status)
+	assert.NotNil(t, job.CompletedAt)
+	require.Len(t, job.URLs, 14)
+	assert.Equal(t, handlers.DeleteURLResult{ShortURL: "id0", Outcome: handlers.DeleteDeleted}, job.URLs[0])
+	assert.Equal(t, handlers.DeleteURLResult{ShortURL: "other", Outcome: handlers.DeleteNotOwner}, job.URLs[12])
+	assert.Equal(t, handlers.DeleteURLResult{ShortURL: "missing", Outcome: handlers.DeleteNotFound}, job.URLs[13])
+}
+
+func TestURLService_DeleteBatchJobFailure(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewMemoryRepository(baseURL)
+	queue := &stubQueue{}
+	service := services.New(repo, baseURL, nil, nil, &stubGenerator{ids: []string{"a"}}, nil, nil, nil, queue)
+
+	_, err := service.CreateURL(ctx, "https://go.dev", "", models.Expiration{}, "user")
+	require.NoError(t, err)
+
+	jobID, err := service.DeleteBatch(ctx, []string{"a", "missing"}, "user")
+	require.NoError(t, err)
+	require.Len(t, queue.payloads, 1)
+
+	service.HandleDeleteJobFailure(ctx, queue.payloads[0], errors.New("connection refused"))
+
+	job, err := service.GetDeleteJob(ctx, jobID, "user")
+	require.NoError(t, err)
+	assert.Equal(t, handlers.DeleteJobFailed, job.Status)
+	assert.Equal(t, "connection refused", job.Error)
+	assert.NotNil(t, job.CompletedAt)
+	assert.Equal(t, []handlers.DeleteURLResult{{ShortURL: "a", Outcome: handlers.DeleteFailed}, {ShortURL: "missing", Outcome: handlers.DeleteFailed}}, job.URLs)
+
+	// the failed job stays in the durable queue and may succeed on the next start
+	require.NoError(t, service.HandleDeleteJob(ctx, queue.payloads[0]))
+
+	job, err = service.GetDeleteJob(ctx, jobID, "user")
+	require.NoError(t, err)
+	assert.Equal(t, handlers.DeleteJobDone, job.Status)
+	assert.Equal(t, []handlers.DeleteURLResult{{ShortURL: "a", Outcome: handlers.DeleteDeleted}, {ShortURL: "missing", Outcome: handlers.DeleteNotFound}}, job.URLs)
+}
+
+func TestURLService_DeleteBatchQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := memory.NewMemoryRepository(baseURL)
+
+	var ids []string
+	for i := 0; i < 50; i++ {
+		ids = append(ids, "id"+strconv.Itoa(i))
+	}
+
+	// the pool is not running yet, its two places are taken by another task and the first batch
+	wp := workers.New(ctx, 1, 2)
+
+	defer wp.Stop()
+
+	service := services.New(repo, baseURL, wp, nil, &stubGenerator{ids: append([]string(nil), ids...)}, nil, nil, nil, nil)
+
+	for i := range ids {
+		_, err := service.CreateURL(ctx, "https://go.dev/"+strconv.Itoa(i), "", models.Expiration{}, "user")
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, wp.TryPush(ctx, workers.Task{Run: func(ctx context.Context) error { return nil }}))
+
+	jobID, err := service.DeleteBatch(ctx, ids[:25], "user")
+	require.NoError(t, err, "the batch of several chunks takes one place")
+
+	_, err = service.DeleteBatch(ctx, ids[25:], "user")
+	require.ErrorIs(t, err, workers.ErrQueueFull)
+
+	go wp.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		job, err := service.GetDeleteJob(ctx, jobID, "user")
+		return err == nil && job.Status == handlers.DeleteJobDone
+	}, time.Second, 10*time.Millisecond)
+
+	for _, id := range ids[25:] {
+		_, err = service.GetURL(ctx, id)
+		assert.NoError(t, err, "the refused batch must delete nothing")
+	}
+}
+
+func isNotFound(err error) bool {
+	var dbErr *customerrors.Error
+
+	return errors.As(err, &dbErr) && dbErr.Reason == "not_found"
+}
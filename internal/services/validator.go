@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/models"
+)
+
+// defaultPorts - ports removed from the host when stripDefaultPort is set
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+// URLValidator checks the urls before shortening and brings them to the canonical form
+type URLValidator struct {
+	schemes          map[string]bool
+	maxLength        int
+	stripDefaultPort bool
+	stripFragment    bool
+}
+
+// NewURLValidator is the validator constructor, maxLength <= 0 disables the length check
+func NewURLValidator(schemes []string, maxLength int, stripDefaultPort, stripFragment bool) *URLValidator {
+	allowed := make(map[string]bool, len(schemes))
+
+	for _, scheme := range schemes {
+		allowed[strings.ToLower(strings.TrimSpace(scheme))] = true
+	}
+
+	return &URLValidator{
+		schemes:          allowed,
+		maxLength:        maxLength,
+		stripDefaultPort: stripDefaultPort,
+		stripFragment:    stripFragment,
+	}
+}
+
+// Normalize returns the canonical form of longURL or a validation error for the field
+func (v *URLValidator) Normalize(field string, longURL models.LongURL) (models.LongURL, error) {
+	longURL = strings.TrimSpace(longURL)
+
+	if longURL == "" {
+		return "", customerrors.InvalidInput(field, "empty", "the url cannot be empty")
+	}
+
+	if v.maxLength > 0 && len(longURL) > v.maxLength {
+		return "", customerrors.InvalidInput(field, "too_long", fmt.Sprintf("the url is longer than %d characters", v.maxLength))
+	}
+
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return "", customerrors.InvalidInput(field, "malformed", "the url cannot be parsed")
+	}
+
+	if !u.IsAbs() {
+		return "", customerrors.InvalidInput(field, "not_absolute", "the url must contain a scheme")
+	}
+
+	if !v.schemes[u.Scheme] {
+		return "", customerrors.InvalidInput(field, "scheme_not_allowed", fmt.Sprintf("the scheme %q is not allowed", u.Scheme))
+	}
+
+	if u.Host == "" || u.Hostname() == "" {
+		return "", customerrors.InvalidInput(field, "missing_host", "the url must contain a host")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+
+	if v.stripDefaultPort && port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	if v.stripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	return u.String(), nil
+}
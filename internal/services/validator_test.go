@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+)
+
+func TestURLValidator_Normalize(t *testing.T) {
+	tests := []struct {
+		name          string
+		stripFragment bool
+		longURL       string
+		want          string
+		wantReason    string
+	}{
+		{name: "lowercase host", longURL: "  https://Go.DEV/Doc  ", want: "https://go.dev/Doc"},
+		{name: "default port", longURL: "http://go.dev:80/", want: "http://go.dev/"},
+		{name: "custom port", longURL: "https://go.dev:8443/", want: "https://go.dev:8443/"},
+		{name: "ipv6 host", longURL: "https://[::1]:443/", want: "https://[::1]/"},
+		{name: "keep fragment", longURL: "https://go.dev/doc#install", want: "https://go.dev/doc#install"},
+		{name: "strip fragment", stripFragment: true, longURL: "https://go.dev/doc#install", want: "https://go.dev/doc"},
+		{name: "empty", longURL: "   ", wantReason: "empty"},
+		{name: "too long", longURL: "https://go.dev/" + strings.Repeat("a", 100), wantReason: "too_long"},
+		{name: "relative", longURL: "/doc", wantReason: "not_absolute"},
+		{name: "javascript", longURL: "javascript:alert(1)", wantReason: "scheme_not_allowed"},
+		{name: "no host", longURL: "https:///doc", wantReason: "missing_host"},
+		{name: "malformed", longURL: "https://go.dev/%zz", wantReason: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewURLValidator([]string{"http", "HTTPS"}, 100, true, tt.stripFragment)
+
+			got, err := v.Normalize("url", tt.longURL)
+			if tt.wantReason == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+				return
+			}
+
+			var validationErr *customerrors.Error
+
+			assert.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+			assert.Equal(t, tt.wantReason, validationErr.Reason)
+			assert.Equal(t, "url", validationErr.Field)
+		})
+	}
+}
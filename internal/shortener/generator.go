@@ -0,0 +1,210 @@
+package shortener
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Strategies available for New.
+const (
+	StrategyHash     = "hash"
+	StrategySequence = "sequence"
+	StrategyRandom   = "random"
+)
+
+// DefaultAlphabet - URL safe base62 alphabet
+const DefaultAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// DefaultLength - default length of the hash and random identifiers
+const DefaultLength = 8
+
+// Generator creates identifiers for short urls
+type Generator interface {
+	// Generate returns an identifier for longURL, attempt is increased on every retry after a collision
+	Generate(longURL string, attempt int) (string, error)
+}
+
+// New returns a generator for the strategy, length is ignored by the sequence strategy
+func New(strategy string, length int, alphabet string, start uint64) (Generator, error) {
+	if len(alphabet) < 2 {
+		return nil, errors.New("the alphabet must contain at least two characters")
+	}
+
+	seen := map[rune]bool{}
+
+	for _, c := range alphabet {
+		if c >= utf8.RuneSelf || seen[c] {
+			return nil, errors.New("the alphabet must consist of unique ASCII characters")
+		}
+
+		seen[c] = true
+	}
+
+	if length <= 0 && strategy != StrategySequence {
+		return nil, errors.New("the length must be positive")
+	}
+
+	switch strategy {
+	case StrategyHash:
+		return NewHashGenerator(length, alphabet), nil
+	case StrategySequence:
+		return NewSequenceGenerator(start, alphabet), nil
+	case StrategyRandom:
+		return NewRandomGenerator(length, alphabet), nil
+	default:
+		return nil, fmt.Errorf("unknown short id strategy %q", strategy)
+	}
+}
+
+// HashGenerator derives the identifier from the SHA-256 of the url, so the same url gets the same identifier
+type HashGenerator struct {
+	length   int
+	alphabet string
+}
+
+// NewHashGenerator is the hash generator constructor
+func NewHashGenerator(length int, alphabet string) *HashGenerator {
+	return &HashGenerator{
+		length:   length,
+		alphabet: alphabet,
+	}
+}
+
+// Generate hashes longURL, retries salt the url with the attempt number
+func (g *HashGenerator) Generate(longURL string, attempt int) (string, error) {
+	data := longURL
+	if attempt > 0 {
+		data = longURL + "#" + strconv.Itoa(attempt)
+	}
+
+	sum := sha256.Sum256([]byte(data))
+
+	id := encode(new(big.Int).SetBytes(sum[:]), g.alphabet)
+	if len(id) > g.length {
+		id = id[:g.length]
+	}
+
+	return id, nil
+}
+
+// SequenceGenerator encodes an increasing counter, identifiers grow in length with the counter
+type SequenceGenerator struct {
+	counter  uint64
+	alphabet string
+}
+
+// NewSequenceGenerator is the sequence generator constructor, start is the first counter value
+func NewSequenceGenerator(start uint64, alphabet string) *SequenceGenerator {
+	return &SequenceGenerator{
+		counter:  start,
+		alphabet: alphabet,
+	}
+}
+
+// Generate returns the next counter value, so a retry after a collision skips the taken identifier
+func (g *SequenceGenerator) Generate(longURL string, attempt int) (string, error) {
+	next := atomic.AddUint64(&g.counter, 1) - 1
+
+	return encode(new(big.Int).SetUint64(next), g.alphabet), nil
+}
+
+// SequenceStart returns the counter value following the highest identifier of ids encoded with the alphabet,
+// so a restarted sequence never repeats the stored identifiers. The identifiers not produced by the encoding,
+// e.g. the aliases with other characters, are skipped.
+func SequenceStart(ids []string, alphabet string) uint64 {
+	var start uint64
+
+	for _, id := range ids {
+		n, ok := decode(id, alphabet)
+		if ok && n >= start {
+			start = n + 1
+		}
+	}
+
+	return start
+}
+
+// RandomGenerator returns cryptographically random identifiers
+type RandomGenerator struct {
+	length   int
+	alphabet string
+}
+
+// NewRandomGenerator is the random generator constructor
+func NewRandomGenerator(length int, alphabet string) *RandomGenerator {
+	return &RandomGenerator{
+		length:   length,
+		alphabet: alphabet,
+	}
+}
+
+// Generate returns a new random identifier on every call
+func (g *RandomGenerator) Generate(longURL string, attempt int) (string, error) {
+	id := make([]byte, g.length)
+	max := big.NewInt(int64(len(g.alphabet)))
+
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+
+		id[i] = g.alphabet[n.Int64()]
+	}
+
+	return string(id), nil
+}
+
+// encode represents n in the positional system of the alphabet
+func encode(n *big.Int, alphabet string) string {
+	if n.Sign() == 0 {
+		return alphabet[:1]
+	}
+
+	base := big.NewInt(int64(len(alphabet)))
+	mod := new(big.Int)
+	n = new(big.Int).Set(n)
+
+	var result []byte
+
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		result = append(result, alphabet[mod.Int64()])
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return string(result)
+}
+
+// decode is the inverse of encode, it fails for the values encode never returns and for the overflows of uint64
+func decode(id string, alphabet string) (uint64, bool) {
+	if id == "" || (len(id) > 1 && id[0] == alphabet[0]) {
+		return 0, false
+	}
+
+	base := uint64(len(alphabet))
+
+	var n uint64
+
+	for i := 0; i < len(id); i++ {
+		digit := strings.IndexByte(alphabet, id[i])
+		if digit < 0 || n > (math.MaxUint64-uint64(digit))/base {
+			return 0, false
+		}
+
+		n = n*base + uint64(digit)
+	}
+
+	return n, n < math.MaxUint64
+}
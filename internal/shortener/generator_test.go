@@ -0,0 +1,81 @@
+package shortener
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		length   int
+		alphabet string
+		wantErr  bool
+	}{
+		{name: "hash", strategy: StrategyHash, length: 8, alphabet: DefaultAlphabet},
+		{name: "sequence ignores length", strategy: StrategySequence, alphabet: DefaultAlphabet},
+		{name: "random", strategy: StrategyRandom, length: 8, alphabet: DefaultAlphabet},
+		{name: "unknown strategy", strategy: "md5", length: 8, alphabet: DefaultAlphabet, wantErr: true},
+		{name: "zero length", strategy: StrategyHash, alphabet: DefaultAlphabet, wantErr: true},
+		{name: "short alphabet", strategy: StrategyHash, length: 8, alphabet: "a", wantErr: true},
+		{name: "repeated characters", strategy: StrategyHash, length: 8, alphabet: "abca", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.strategy, tt.length, tt.alphabet, 0)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestHashGenerator_Generate(t *testing.T) {
+	g := NewHashGenerator(8, DefaultAlphabet)
+
+	first, err := g.Generate("https://go.dev", 0)
+	require.NoError(t, err)
+	assert.Len(t, first, 8)
+
+	again, _ := g.Generate("https://go.dev", 0)
+	assert.Equal(t, first, again)
+
+	insecure, _ := g.Generate("http://go.dev", 0)
+	assert.NotEqual(t, first, insecure)
+
+	retry, _ := g.Generate("https://go.dev", 1)
+	assert.NotEqual(t, first, retry)
+}
+
+func TestSequenceGenerator_Generate(t *testing.T) {
+	g := NewSequenceGenerator(61, DefaultAlphabet)
+
+	for _, want := range []string{"z", "10", "11"} {
+		got, err := g.Generate("https://go.dev", 0)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestSequenceStart(t *testing.T) {
+	assert.Equal(t, uint64(0), SequenceStart(nil, DefaultAlphabet))
+	assert.Equal(t, uint64(62), SequenceStart([]string{"5", "z", "my-alias", "0z", ""}, DefaultAlphabet), "the foreign identifiers must be skipped")
+	assert.Equal(t, uint64(63), SequenceStart([]string{"10"}, DefaultAlphabet))
+	assert.Equal(t, uint64(0), SequenceStart([]string{"zzzzzzzzzzzzzzzzzzzzzzzz"}, DefaultAlphabet), "the overflow must be skipped")
+
+	g := NewSequenceGenerator(SequenceStart([]string{"z", "10"}, DefaultAlphabet), DefaultAlphabet)
+
+	next, err := g.Generate("https://go.dev", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "11", next)
+}
+
+func TestRandomGenerator_Generate(t *testing.T) {
+	g := NewRandomGenerator(10, "ab")
+
+	first, err := g.Generate("https://go.dev", 0)
+	require.NoError(t, err)
+	assert.Len(t, first, 10)
+	assert.Regexp(t, "^[ab]+$", first)
+}
@@ -8,6 +8,8 @@ import (
 )
 
 // ShorterURL function for URL shortening
+//
+// Deprecated: the identifiers are long and ignore the scheme, use a Generator instead.
 func ShorterURL(longURL string) string {
 	splitURL := strings.Split(longURL, "://")
 	hasher := sha1.New()
@@ -0,0 +1,133 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed task is run again
+type RetryPolicy struct {
+	// MaxAttempts - number of runs including the first one, less than 2 disables the retries
+	MaxAttempts int
+	// BaseDelay - delay before the first retry, doubled for every next one
+	BaseDelay time.Duration
+	// MaxDelay - limit of the delay, 0 means no limit
+	MaxDelay time.Duration
+	// Retryable classifies the errors, nil retries all but the permanent and the canceled ones
+	Retryable func(err error) bool
+}
+
+// NoRetry is the default policy of the pool
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// Backoff returns the delay before the retry following the attempt, the second half of the delay is random
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if delay <= 1 {
+		return delay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (p RetryPolicy) retry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return !IsPermanent(err) && !errors.Is(err, context.Canceled)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error of a task that must not be retried, e.g. an invalid payload
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether the error was marked by Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+
+	return errors.As(err, &permanent)
+}
+
+// Outcome is the final result of a task
+type Outcome struct {
+	// Attempts - number of runs
+	Attempts int
+	// Err - the error of the last run, nil if the task succeeded
+	Err error
+}
+
+// DeadLetter is a task failed after all the attempts
+type DeadLetter struct {
+	ID        uint64    `json:"id"`
+	Name      string    `json:"name"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	RequestID string    `json:"request_id,omitempty"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// DefaultDeadLetters - number of the dead letters kept by the pool, the oldest are dropped
+const DefaultDeadLetters = 1000
+
+// deadLetters keeps the last failed tasks for inspection
+type deadLetters struct {
+	mtx      sync.Mutex
+	capacity int
+	next     uint64
+	letters  []DeadLetter
+}
+
+func (d *deadLetters) add(letter DeadLetter) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.next++
+	letter.ID = d.next
+
+	if len(d.letters) >= d.capacity {
+		d.letters = append(d.letters[:0], d.letters[len(d.letters)-d.capacity+1:]...)
+	}
+
+	d.letters = append(d.letters, letter)
+}
+
+func (d *deadLetters) list() []DeadLetter {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return append([]DeadLetter(nil), d.letters...)
+}
@@ -3,63 +3,481 @@ package workers
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	customerrors "github.com/mkokoulin/go-musthave-shortener-tpl/internal/errors"
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+// Task is the work pushed to the pool
+type Task struct {
+	// Name - the name of the task in the logs and the dead letters
+	Name string
+	Run  func(ctx context.Context) error
+	// Retry - the policy of the task, nil uses the policy of the pool
+	Retry *RetryPolicy
+	// OnDone is called with the final outcome after the task succeeds or fails for good, optional
+	OnDone func(ctx context.Context, outcome Outcome)
+}
+
+// Errors of TryPush.
+var (
+	// ErrQueueFull - all the workers are busy and the buffer is full, the caller should try later
+	ErrQueueFull = customerrors.Unavailable("queue_full", "the worker pool queue is full")
+	// ErrDraining - the pool does not accept tasks anymore
+	ErrDraining = customerrors.Unavailable("draining", "the worker pool is draining")
 )
 
+// pendingRetry is the task waiting for its retry timer
+type pendingRetry struct {
+	t     task
+	err   error
+	timer *time.Timer
+}
+
+// task is the pushed task with the context of the caller
+type task struct {
+	Task
+	// origin - the context of the caller, its logger and request id are passed to the function
+	origin context.Context
+	// attempt - number of the previous runs
+	attempt int
+}
+
 type WorkerPool struct {
+	// inFlight - number of tasks being run, the 64-bit counters go first to be aligned for atomic access
+	inFlight int64
+	// failed - number of tasks failed after all the attempts
+	failed uint64
+	// retried - number of retries scheduled
+	retried uint64
+	// retrying - number of tasks waiting for a retry
+	retrying int64
 	// workers -number of workers
 	workers int
+	// quits - the channels stopping the running workers, one per worker, the last ones are closed on shrinking
+	quits []chan struct{}
+	// wg - the running workers
+	wg sync.WaitGroup
+	// runCtx - the context of Run passed to the workers started by Resize, nil before Run
+	runCtx context.Context
+	// sizeMtx - guards workers, quits and runCtx
+	sizeMtx sync.Mutex
 	// inputCh - these channel will receive work
-	inputCh chan func(ctx context.Context) error
+	inputCh chan task
 	// done - channel for stopping the work of the worker
 	done chan struct{}
+	// stopOnce - guards done from closing twice
+	stopOnce sync.Once
+	// closing - closed when the pool stops accepting tasks
+	closing chan struct{}
+	// closed - set together with closing, Push holds the read lock while sending to inputCh
+	closed bool
+	mtx    sync.RWMutex
+	// stopped - closed when all the workers have exited
+	stopped chan struct{}
+	// retry - the policy of the tasks without their own
+	retry RetryPolicy
+	// deadLetters - the tasks failed after all the attempts
+	deadLetters *deadLetters
+	// retries - the tasks waiting for a retry, a timer takes its task out before pushing it and Drain takes the rest
+	retries map[*pendingRetry]struct{}
+	// firing - the timers pushing their tasks
+	firing sync.WaitGroup
+	// retryMtx - guards retries
+	retryMtx sync.Mutex
 }
 
 // New is the worker constructor
 func New(ctx context.Context, workers int, buffer int) *WorkerPool {
 	return &WorkerPool{
 		workers: workers,
-		inputCh: make(chan func(ctx context.Context) error, buffer),
+		inputCh: make(chan task, buffer),
 		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+		retry:   NoRetry,
+		retries: make(map[*pendingRetry]struct{}),
+		deadLetters: &deadLetters{
+			capacity: DefaultDeadLetters,
+		},
 	}
 }
 
-// Run is the method to start the worker
-func (wp *WorkerPool) Run(ctx context.Context) {
-	wg := &sync.WaitGroup{}
+// SetRetryPolicy sets the policy of the tasks without their own, it must be called before Run
+func (wp *WorkerPool) SetRetryPolicy(policy RetryPolicy) {
+	wp.retry = policy
+}
 
+// Run is the method to start the worker, the tasks run with ctx carrying the logger and the request id of the caller
+func (wp *WorkerPool) Run(ctx context.Context) {
+	wp.sizeMtx.Lock()
+	wp.runCtx = ctx
 	for i := 0; i < wp.workers; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			fmt.Printf("Worker #%v start \n", i)
-		outer:
-			for {
-				select {
-				case f := <-wp.inputCh:
-					err := f(ctx)
-					if err != nil {
-						fmt.Printf("Error on worker #%v: %v\n", i, err.Error())
-					}
-				case <-wp.done:
+		wp.start()
+	}
+	wp.sizeMtx.Unlock()
+
+	wp.wg.Wait()
+	close(wp.stopped)
+}
+
+// start runs a new worker, the caller holds sizeMtx
+func (wp *WorkerPool) start() {
+	ctx := wp.runCtx
+	i := len(wp.quits)
+	quit := make(chan struct{})
+	wp.quits = append(wp.quits, quit)
+
+	wp.wg.Add(1)
+	go func() {
+		defer wp.wg.Done()
+		logger.FromContext(ctx).Debug("worker started", "worker", i)
+	outer:
+		for {
+			select {
+			case <-wp.done:
+				break outer
+			case <-quit:
+				break outer
+			default:
+			}
+
+			select {
+			case t, ok := <-wp.inputCh:
+				if !ok {
 					break outer
 				}
+				wp.run(logger.Inherit(ctx, t.origin), i, t)
+			case <-wp.done:
+				break outer
+			case <-quit:
+				break outer
 			}
-			log.Printf("Worker #%v close\n", i)
-		}(i)
+		}
+		logger.FromContext(ctx).Debug("worker closed", "worker", i)
+	}()
+}
+
+// Resize changes the number of workers at runtime, the removed workers exit after their current tasks.
+// It does nothing after the pool is stopped or draining.
+func (wp *WorkerPool) Resize(workers int) error {
+	if workers < 1 {
+		return errors.New("the worker pool needs at least one worker")
+	}
+
+	wp.sizeMtx.Lock()
+	defer wp.sizeMtx.Unlock()
+
+	// the read lock keeps Drain from closing inputCh, so the running workers do not exit while the new ones start
+	wp.mtx.RLock()
+	defer wp.mtx.RUnlock()
+
+	select {
+	case <-wp.done:
+		return ErrDraining
+	default:
+	}
+
+	if wp.closed {
+		return ErrDraining
+	}
+
+	wp.workers = workers
+
+	if wp.runCtx == nil {
+		return nil
+	}
+
+	for len(wp.quits) < workers {
+		wp.start()
 	}
-	wg.Wait()
-	close(wp.inputCh)
+
+	for len(wp.quits) > workers {
+		close(wp.quits[len(wp.quits)-1])
+		wp.quits = wp.quits[:len(wp.quits)-1]
+	}
+
+	return nil
+}
+
+// Size returns the number of workers
+func (wp *WorkerPool) Size() int {
+	wp.sizeMtx.Lock()
+	defer wp.sizeMtx.Unlock()
+
+	return wp.workers
 }
 
-// Stop is the method to stop the worker
+// Stop is the method to stop the worker, the workers exit after the current tasks and the queued ones are not run
 func (wp *WorkerPool) Stop() {
-	close(wp.done)
+	wp.sizeMtx.Lock()
+	defer wp.sizeMtx.Unlock()
+
+	wp.stopOnce.Do(func() {
+		close(wp.done)
+	})
+}
+
+// Drain stops accepting tasks and waits until the queued ones are done or ctx is done.
+// On the deadline the workers are stopped and waited for after the current tasks.
+// The tasks waiting for a retry are not run again, they fail with their last error and go to the dead letters.
+// The number of the tasks left in the queue and of the abandoned retries is returned.
+func (wp *WorkerPool) Drain(ctx context.Context) int {
+	wp.mtx.Lock()
+	if !wp.closed {
+		wp.closed = true
+		close(wp.closing)
+		close(wp.inputCh)
+	}
+	wp.mtx.Unlock()
+
+	abandoned := 0
+
+	select {
+	case <-wp.stopped:
+	case <-ctx.Done():
+		wp.Stop()
+		wp.wg.Wait()
+
+		for range wp.inputCh {
+			abandoned++
+		}
+	}
+
+	return abandoned + wp.abandonRetries()
+}
+
+// abandonRetries stops the retry timers and fails their tasks, the workers must be stopped.
+// It returns the number of the abandoned tasks.
+func (wp *WorkerPool) abandonRetries() int {
+	retries := wp.takeRetries()
+
+	// the timers fired before are pushing their tasks, the tasks refused by the closed pool are taken back
+	wp.firing.Wait()
+
+	retries = append(retries, wp.takeRetries()...)
+
+	for _, r := range retries {
+		r.timer.Stop()
+		atomic.AddInt64(&wp.retrying, -1)
+
+		ctx := logger.Inherit(context.Background(), r.t.origin)
+		logger.FromContext(ctx).Error("task failed, the retry is abandoned on shutdown", "task", r.t.Name, "attempt", r.t.attempt, "error", r.err)
+		wp.finish(ctx, r.t, r.err)
+	}
+
+	return len(retries)
+}
+
+// takeRetries removes all the tasks waiting for a retry
+func (wp *WorkerPool) takeRetries() []*pendingRetry {
+	wp.retryMtx.Lock()
+	defer wp.retryMtx.Unlock()
+
+	retries := make([]*pendingRetry, 0, len(wp.retries))
+	for r := range wp.retries {
+		retries = append(retries, r)
+		delete(wp.retries, r)
+	}
+
+	return retries
 }
 
-// Push is the method to push into the inputCh
-func (wp *WorkerPool) Push(task func(ctx context.Context) error) {
-	wp.inputCh <- task
+func (wp *WorkerPool) run(ctx context.Context, worker int, t task) {
+	atomic.AddInt64(&wp.inFlight, 1)
+	defer atomic.AddInt64(&wp.inFlight, -1)
+
+	start := time.Now()
+
+	t.attempt++
+
+	err := t.Run(ctx)
+	if err == nil {
+		logger.FromContext(ctx).Debug("task done", "task", t.Name, "worker", worker, "attempt", t.attempt, "duration", time.Since(start))
+		wp.finish(ctx, t, nil)
+		return
+	}
+
+	policy := wp.retry
+	if t.Retry != nil {
+		policy = *t.Retry
+	}
+
+	if policy.retry(t.attempt, err) {
+		delay := policy.Backoff(t.attempt)
+		logger.FromContext(ctx).Warn("task will be retried", "task", t.Name, "worker", worker, "attempt", t.attempt, "delay", delay, "error", err)
+		wp.retryAfter(t, delay, err)
+		return
+	}
+
+	logger.FromContext(ctx).Error("task failed", "task", t.Name, "worker", worker, "attempt", t.attempt, "duration", time.Since(start), "error", err)
+	wp.finish(ctx, t, err)
+}
+
+// retryAfter pushes the task again after the delay without holding a worker
+func (wp *WorkerPool) retryAfter(t task, delay time.Duration, err error) {
+	atomic.AddUint64(&wp.retried, 1)
+	atomic.AddInt64(&wp.retrying, 1)
+
+	r := &pendingRetry{t: t, err: err}
+
+	wp.retryMtx.Lock()
+	defer wp.retryMtx.Unlock()
+
+	wp.retries[r] = struct{}{}
+	r.timer = time.AfterFunc(delay, func() {
+		wp.fireRetry(r)
+	})
+}
+
+// fireRetry pushes the task of the timer unless Drain has taken it, the task refused by the draining pool is left to Drain
+func (wp *WorkerPool) fireRetry(r *pendingRetry) {
+	wp.retryMtx.Lock()
+	_, ok := wp.retries[r]
+	if ok {
+		delete(wp.retries, r)
+		wp.firing.Add(1)
+	}
+	wp.retryMtx.Unlock()
+
+	if !ok {
+		return
+	}
+
+	defer wp.firing.Done()
+
+	if !wp.push(r.t) {
+		wp.retryMtx.Lock()
+		wp.retries[r] = struct{}{}
+		wp.retryMtx.Unlock()
+
+		return
+	}
+
+	atomic.AddInt64(&wp.retrying, -1)
+}
+
+// finish records the final outcome of the task and passes it to the hook
+func (wp *WorkerPool) finish(ctx context.Context, t task, err error) {
+	if err != nil {
+		atomic.AddUint64(&wp.failed, 1)
+
+		wp.deadLetters.add(DeadLetter{
+			Name:      t.Name,
+			Attempts:  t.attempt,
+			Error:     err.Error(),
+			RequestID: logger.RequestID(ctx),
+			FailedAt:  time.Now().UTC(),
+		})
+	}
+
+	if t.OnDone != nil {
+		t.OnDone(ctx, Outcome{Attempts: t.attempt, Err: err})
+	}
+}
+
+// Push is the method to push into the inputCh, the logger and the request id of ctx are passed to the task.
+// The task is dropped if the pool is draining.
+func (wp *WorkerPool) Push(ctx context.Context, run func(ctx context.Context) error) {
+	wp.PushTask(ctx, Task{Run: run})
+}
+
+// PushTask pushes the task with its name, retry policy and hook like Push
+func (wp *WorkerPool) PushTask(ctx context.Context, t Task) {
+	if t.Name == "" {
+		t.Name = "task"
+	}
+
+	if !wp.push(task{Task: t, origin: ctx}) {
+		logger.FromContext(ctx).Warn("task dropped, the worker pool is draining", "task", t.Name)
+	}
+}
+
+// TryPush pushes the task like PushTask without waiting for a place in the queue,
+// it returns ErrQueueFull if the buffer is full and ErrDraining if the pool is draining
+func (wp *WorkerPool) TryPush(ctx context.Context, t Task) error {
+	if t.Name == "" {
+		t.Name = "task"
+	}
+
+	wp.mtx.RLock()
+	defer wp.mtx.RUnlock()
+
+	if wp.closed {
+		return ErrDraining
+	}
+
+	select {
+	case wp.inputCh <- task{Task: t, origin: ctx}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// push sends the task to inputCh, it returns false if the pool is draining
+func (wp *WorkerPool) push(t task) bool {
+	wp.mtx.RLock()
+	defer wp.mtx.RUnlock()
+
+	if wp.closed {
+		return false
+	}
+
+	wp.inputCh <- t
+
+	return true
+}
+
+// QueueLen returns the number of tasks waiting for a worker
+func (wp *WorkerPool) QueueLen() int {
+	return len(wp.inputCh)
+}
+
+// InFlight returns the number of tasks being run
+func (wp *WorkerPool) InFlight() int64 {
+	return atomic.LoadInt64(&wp.inFlight)
+}
+
+// Failed returns the number of tasks failed after all the attempts since the start
+func (wp *WorkerPool) Failed() uint64 {
+	return atomic.LoadUint64(&wp.failed)
+}
+
+// Retried returns the number of retries since the start
+func (wp *WorkerPool) Retried() uint64 {
+	return atomic.LoadUint64(&wp.retried)
+}
+
+// Retrying returns the number of tasks waiting for a retry
+func (wp *WorkerPool) Retrying() int64 {
+	return atomic.LoadInt64(&wp.retrying)
+}
+
+// DeadLetters returns the last tasks failed after all the attempts, the oldest first
+func (wp *WorkerPool) DeadLetters() []DeadLetter {
+	return wp.deadLetters.list()
+}
+
+// Schedule pushes the task every interval until ctx is done or the pool is stopped or draining
+func (wp *WorkerPool) Schedule(ctx context.Context, interval time.Duration, task func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wp.done:
+			return
+		case <-wp.closing:
+			return
+		case <-ticker.C:
+			wp.Push(ctx, task)
+		}
+	}
 }
@@ -0,0 +1,379 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mkokoulin/go-musthave-shortener-tpl/internal/logger"
+)
+
+func TestWorkerPool_Schedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 1)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	var runs int32
+
+	go wp.Schedule(ctx, 10*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) >= 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWorkerPool_Counters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 2)
+
+	release := make(chan struct{})
+
+	wp.Push(ctx, func(ctx context.Context) error {
+		<-release
+		return errors.New("failed")
+	})
+	wp.Push(ctx, func(ctx context.Context) error {
+		return nil
+	})
+
+	assert.Equal(t, 2, wp.QueueLen())
+
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	assert.Eventually(t, func() bool {
+		return wp.InFlight() == 1 && wp.QueueLen() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return wp.InFlight() == 0 && wp.QueueLen() == 0 && wp.Failed() == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWorkerPool_PushRequestID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 1)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	requestIDs := make(chan string, 1)
+
+	wp.Push(logger.WithRequestID(context.Background(), "abc"), func(ctx context.Context) error {
+		requestIDs <- logger.RequestID(ctx)
+		return nil
+	})
+
+	select {
+	case requestID := <-requestIDs:
+		assert.Equal(t, "abc", requestID)
+	case <-time.After(time.Second):
+		t.Fatal("the task was not run")
+	}
+}
+
+func TestWorkerPool_Drain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 2, 10)
+
+	var runs int32
+
+	for i := 0; i < 5; i++ {
+		wp.Push(ctx, func(ctx context.Context) error {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&runs, 1)
+			return nil
+		})
+	}
+
+	go wp.Run(ctx)
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, time.Second)
+	defer drainCancel()
+
+	assert.Equal(t, 0, wp.Drain(drainCtx))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&runs), "the queued tasks must be finished")
+
+	wp.Push(ctx, func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	assert.Equal(t, 0, wp.QueueLen(), "the tasks pushed after the drain must be dropped")
+}
+
+func TestWorkerPool_DrainDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 10)
+
+	release := make(chan struct{})
+
+	started := make(chan struct{})
+
+	wp.Push(ctx, func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		wp.Push(ctx, func(ctx context.Context) error {
+			return nil
+		})
+	}
+
+	go wp.Run(ctx)
+
+	<-started
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer drainCancel()
+
+	var finished int32
+
+	go func() {
+		<-drainCtx.Done()
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		close(release)
+	}()
+
+	assert.Equal(t, 3, wp.Drain(drainCtx), "the tasks behind the blocked one must be abandoned")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished), "the current task must be waited for")
+}
+
+func TestWorkerPool_DrainRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 1)
+	wp.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour})
+	go wp.Run(ctx)
+
+	var outcome atomic.Value
+
+	wp.PushTask(ctx, Task{
+		Name: "down",
+		Run: func(ctx context.Context) error {
+			return errors.New("unavailable")
+		},
+		OnDone: func(ctx context.Context, o Outcome) {
+			outcome.Store(o)
+		},
+	})
+
+	require.Eventually(t, func() bool {
+		return wp.Retrying() == 1
+	}, time.Second, time.Millisecond)
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, time.Second)
+	defer drainCancel()
+
+	assert.Equal(t, 1, wp.Drain(drainCtx), "the task waiting for a retry must be abandoned")
+
+	done, ok := outcome.Load().(Outcome)
+	require.True(t, ok, "the task must be finished before the drain returns")
+	assert.EqualError(t, done.Err, "unavailable")
+	assert.Equal(t, 1, done.Attempts)
+	assert.Equal(t, int64(0), wp.Retrying())
+	require.Len(t, wp.DeadLetters(), 1)
+	assert.Equal(t, "down", wp.DeadLetters()[0].Name)
+}
+
+func TestWorkerPool_Retry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 1)
+	wp.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	var runs int32
+
+	outcomes := make(chan Outcome, 1)
+
+	wp.PushTask(ctx, Task{
+		Name: "flaky",
+		Run: func(ctx context.Context) error {
+			if atomic.AddInt32(&runs, 1) < 3 {
+				return errors.New("unavailable")
+			}
+
+			return nil
+		},
+		OnDone: func(ctx context.Context, outcome Outcome) {
+			outcomes <- outcome
+		},
+	})
+
+	select {
+	case outcome := <-outcomes:
+		assert.NoError(t, outcome.Err)
+		assert.Equal(t, 3, outcome.Attempts)
+	case <-time.After(time.Second):
+		t.Fatal("the task was not finished")
+	}
+
+	assert.Equal(t, uint64(2), wp.Retried())
+	assert.Equal(t, uint64(0), wp.Failed())
+	assert.Empty(t, wp.DeadLetters())
+}
+
+func TestWorkerPool_DeadLetters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 2)
+	wp.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond})
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	outcomes := make(chan Outcome, 2)
+	onDone := func(ctx context.Context, outcome Outcome) {
+		outcomes <- outcome
+	}
+
+	wp.PushTask(logger.WithRequestID(ctx, "abc"), Task{
+		Name: "invalid",
+		Run: func(ctx context.Context) error {
+			return Permanent(errors.New("invalid payload"))
+		},
+		OnDone: onDone,
+	})
+	wp.PushTask(ctx, Task{
+		Name: "down",
+		Run: func(ctx context.Context) error {
+			return errors.New("unavailable")
+		},
+		Retry:  &RetryPolicy{MaxAttempts: 2},
+		OnDone: onDone,
+	})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case outcome := <-outcomes:
+			assert.Error(t, outcome.Err)
+		case <-time.After(time.Second):
+			t.Fatal("the task was not finished")
+		}
+	}
+
+	letters := wp.DeadLetters()
+	require.Len(t, letters, 2)
+
+	byName := map[string]DeadLetter{}
+	for _, letter := range letters {
+		byName[letter.Name] = letter
+	}
+
+	assert.Equal(t, 1, byName["invalid"].Attempts, "the permanent errors must not be retried")
+	assert.Equal(t, "abc", byName["invalid"].RequestID)
+	assert.Equal(t, "invalid payload", byName["invalid"].Error)
+	assert.Equal(t, 2, byName["down"].Attempts, "the policy of the task takes precedence")
+	assert.Equal(t, uint64(2), wp.Failed())
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		delay := policy.Backoff(attempt)
+		assert.True(t, delay >= max/2 && delay <= max, "attempt %d: %s", attempt, delay)
+	}
+}
+
+func TestWorkerPool_TryPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 1)
+
+	noop := Task{Run: func(ctx context.Context) error {
+		return nil
+	}}
+
+	require.NoError(t, wp.TryPush(ctx, noop))
+	assert.ErrorIs(t, wp.TryPush(ctx, noop), ErrQueueFull, "the push must not wait for the workers")
+
+	go wp.Run(ctx)
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, time.Second)
+	defer drainCancel()
+
+	assert.Equal(t, 0, wp.Drain(drainCtx))
+	assert.ErrorIs(t, wp.TryPush(ctx, noop), ErrDraining)
+}
+
+func TestWorkerPool_Resize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(ctx, 1, 10)
+	go wp.Run(ctx)
+	defer wp.Stop()
+
+	release := make(chan struct{})
+	block := func(ctx context.Context) error {
+		<-release
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		wp.Push(ctx, block)
+	}
+
+	assert.Eventually(t, func() bool {
+		return wp.InFlight() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, wp.Resize(3))
+	assert.Equal(t, 3, wp.Size())
+
+	assert.Eventually(t, func() bool {
+		return wp.InFlight() == 3
+	}, time.Second, 5*time.Millisecond, "the new workers must take the queued tasks")
+
+	require.NoError(t, wp.Resize(1))
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return wp.InFlight() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	var runs int32
+
+	for i := 0; i < 2; i++ {
+		wp.Push(ctx, func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.True(t, wp.InFlight() <= 1, "the removed workers must exit")
+
+	assert.Error(t, wp.Resize(0))
+}